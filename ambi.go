@@ -0,0 +1,331 @@
+package fqfilter
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+/* Provide an ambidexterous interface to files to read that may be gzipped, or
+ * compressed with Zstandard, bzip2 or xz. The format is chosen by the .gz,
+ * .zst, .bz2 or .xz suffix, or failing that by the first bytes of the file.
+ * An http://, https:// or s3:// URL is streamed as it downloads. */
+type AmbiReader struct {
+	fp    *os.File
+	body  io.ReadCloser
+	gz    io.ReadCloser
+	r     io.Reader
+	count *countingReader
+	size  int64
+}
+
+/* Counts the bytes read through it. The count can be read from another
+ * goroutine, to report progress. */
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+/* How AmbiReader decompresses files. More than one thread uses pgzip to read
+ * ahead and decompress gzip blocks in the background, or as many zstd
+ * decoders. */
+type ReadOptions struct {
+	Threads int
+}
+
+func (a AmbiReader) Read(b []byte) (n int, err error) {
+	return a.r.Read(b)
+}
+
+func (a *AmbiReader) Open(fn string) error {
+	return a.OpenWith(fn, ReadOptions{Threads: 1})
+}
+
+/* Like Open, but with the given options for compressed files */
+func (a *AmbiReader) OpenWith(fn string, opts ReadOptions) error {
+	if a.r != nil {
+		return fmt.Errorf("AmbiReader already open")
+	}
+	var err error
+	// If no filename is given, then read from stdin
+	if fn == "" {
+		a.count = &countingReader{r: os.Stdin}
+		a.gz, a.r, err = newDecompressor(fn, a.count, opts)
+		return err
+	}
+	if IsRemote(fn) {
+		a.body, a.size, err = openRemote(fn)
+		if err != nil {
+			return err
+		}
+		a.count = &countingReader{r: a.body}
+		a.gz, a.r, err = newDecompressor(remotePath(fn), a.count, opts)
+		if err != nil {
+			a.body.Close()
+			a.body = nil
+			return err
+		}
+		return nil
+	}
+	a.fp, err = os.Open(fn)
+	if err != nil {
+		return err
+	}
+	if info, err := a.fp.Stat(); err == nil && info.Mode().IsRegular() {
+		a.size = info.Size()
+	}
+	a.count = &countingReader{r: a.fp}
+	a.gz, a.r, err = newDecompressor(fn, a.count, opts)
+	if err != nil {
+		a.fp.Close()
+		a.fp = nil
+		return err
+	}
+	return nil
+}
+
+/* Read from a stream that is already open, such as a request body,
+ * decompressing it if its first few bytes say it is compressed. Close
+ * leaves r open. */
+func (a *AmbiReader) OpenReader(r io.Reader, opts ReadOptions) error {
+	if a.r != nil {
+		return fmt.Errorf("AmbiReader already open")
+	}
+	var err error
+	a.count = &countingReader{r: r}
+	a.gz, a.r, err = newDecompressor("", a.count, opts)
+	return err
+}
+
+/* The number of bytes read from the file so far, before decompression. It
+ * is safe to call from another goroutine. */
+func (a AmbiReader) BytesRead() int64 {
+	if a.count == nil {
+		return 0
+	}
+	return a.count.n.Load()
+}
+
+/* The size of the file, or 0 if it has none, like stdin or a pipe, or a URL
+ * whose server gave no Content-Length */
+func (a AmbiReader) Size() int64 {
+	return a.size
+}
+
+/* Close the file, if one was opened. Reading from stdin leaves it open. */
+func (a *AmbiReader) Close() error {
+	if a.gz != nil {
+		if err := a.gz.Close(); err != nil {
+			return err
+		}
+	}
+	if a.body != nil {
+		if err := a.body.Close(); err != nil {
+			return err
+		}
+	}
+	if a.fp != nil {
+		if err := a.fp.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* Provide an ambidexterous interface to files to write that may be gzipped, or
+ * with a .zst suffix, compressed with Zstandard */
+type AmbiWriter struct {
+	fp *os.File
+	// With Atomic, the name fp is renamed to on Close
+	final string
+	gz    io.WriteCloser
+	buf   *bufio.Writer
+	r     io.Writer
+}
+
+/* Writes are buffered to save a call into gzip or the OS for every line. This
+ * is the buffer size unless WriteOptions gives another. */
+const DefaultBufferSize = 1024 * 1024
+
+/* How AmbiWriter compresses files. More than one thread uses pgzip to
+ * compress gzip blocks in parallel, or as many zstd encoders. A ZstdLevel or
+ * BufferSize of zero means the default. Atomic writes a file under a
+ * temporary name, renaming it into place only once it is closed, so a run
+ * that dies part way leaves no truncated file that looks complete.
+ * NoClobber refuses to overwrite a file that already exists. With BGZF,
+ * gzip files are written as BGZF blocks, one thread at a time. */
+type WriteOptions struct {
+	Level      int
+	ZstdLevel  int
+	Threads    int
+	BufferSize int
+	Atomic     bool
+	NoClobber  bool
+	BGZF       bool
+}
+
+func (o WriteOptions) bufferSize() int {
+	if o.BufferSize <= 0 {
+		return DefaultBufferSize
+	}
+	return o.BufferSize
+}
+
+func (a AmbiWriter) Write(b []byte) (n int, err error) {
+	return a.r.Write(b)
+}
+
+func (a AmbiWriter) WriteString(s string) (n int, err error) {
+	return a.buf.WriteString(s)
+}
+
+/* Flush any buffered output and close the file, if one was opened. Writing to
+ * stdout leaves it open. The file is closed even if flushing fails, and the
+ * first error returned. */
+func (a *AmbiWriter) Close() error {
+	var err error
+	if a.buf != nil {
+		err = a.buf.Flush()
+	}
+	if a.gz != nil {
+		if gzErr := a.gz.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	if a.fp != nil {
+		if err != nil {
+			discardTarget(a.fp, a.final)
+			return err
+		}
+		return closeTarget(a.fp, a.final)
+	}
+	return err
+}
+
+func (a *AmbiWriter) Open(fn string) error {
+	return a.OpenWith(fn, WriteOptions{Level: gzip.DefaultCompression, Threads: 1})
+}
+
+/* Like Open, but with the given compression options */
+func (a *AmbiWriter) OpenWith(fn string, opts WriteOptions) error {
+	if a.r != nil {
+		return fmt.Errorf("AmbiWriter already open")
+	}
+	var err error
+	// If no filename (or -) is given, then write to stdout
+	if fn == "" || fn == "-" {
+		a.stdout(opts.bufferSize())
+		return nil
+	}
+	a.fp, a.final, err = createTarget(fn, opts)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(fn, ".gz") || strings.HasSuffix(fn, ".zst") {
+		a.gz, err = newCompressor(fn, a.fp, opts)
+		if err != nil {
+			a.fp.Close()
+			return err
+		}
+		a.buf = bufio.NewWriterSize(a.gz, opts.bufferSize())
+	} else {
+		a.buf = bufio.NewWriterSize(a.fp, opts.bufferSize())
+	}
+	a.r = a.buf
+	return nil
+}
+
+/* Open the file to write to. Besides ordinary paths (including named pipes),
+ * fd://N writes to file descriptor N inherited from the parent process. A
+ * logical name can follow, as in fd://3/reads.fq.gz, to choose compression.
+ * With opts.Atomic, a file is opened under a temporary name beside fn, a
+ * hidden one so that nothing mistakes it for the finished file, and fn is
+ * returned as the name for closeTarget to give it. Anything that already
+ * exists but isn't a regular file, like a named pipe or /dev/null, is written
+ * in place. */
+func createTarget(fn string, opts WriteOptions) (*os.File, string, error) {
+	if !strings.HasPrefix(fn, "fd://") {
+		if opts.NoClobber {
+			if err := checkClobber(fn); err != nil {
+				return nil, "", err
+			}
+		}
+		if info, err := os.Stat(fn); !opts.Atomic || err == nil && !info.Mode().IsRegular() {
+			fp, err := os.Create(fn)
+			return fp, "", err
+		}
+		tmp := filepath.Join(filepath.Dir(fn), fmt.Sprintf(".%s.tmp%d", filepath.Base(fn), os.Getpid()))
+		fp, err := os.Create(tmp)
+		return fp, fn, err
+	}
+	spec := strings.TrimPrefix(fn, "fd://")
+	if i := strings.Index(spec, "/"); i >= 0 {
+		spec = spec[:i]
+	}
+	fd, err := strconv.Atoi(spec)
+	if err != nil || fd < 0 {
+		return nil, "", fmt.Errorf("Invalid file descriptor in %s", fn)
+	}
+	fp := os.NewFile(uintptr(fd), fn)
+	if fp == nil {
+		return nil, "", fmt.Errorf("Invalid file descriptor in %s", fn)
+	}
+	return fp, "", nil
+}
+
+/* Refuse to overwrite an existing regular file */
+func checkClobber(fn string) error {
+	if info, err := os.Stat(fn); err == nil && info.Mode().IsRegular() {
+		return fmt.Errorf("%s already exists; use -force to overwrite it", fn)
+	}
+	return nil
+}
+
+/* Close a file from createTarget, moving it to its final name if it was
+ * written under a temporary one. A temporary file that can't be closed or
+ * moved is removed. */
+func closeTarget(fp *os.File, final string) error {
+	if err := fp.Close(); err != nil {
+		if final != "" {
+			os.Remove(fp.Name())
+		}
+		return err
+	}
+	if final != "" {
+		if err := os.Rename(fp.Name(), final); err != nil {
+			os.Remove(fp.Name())
+			return err
+		}
+	}
+	return nil
+}
+
+/* Close a file from createTarget that failed part way, removing it if it was
+ * written under a temporary name, so the final one is left as it was */
+func discardTarget(fp *os.File, final string) {
+	fp.Close()
+	if final != "" {
+		os.Remove(fp.Name())
+	}
+}
+
+func (a *AmbiWriter) Stdout() {
+	a.stdout(DefaultBufferSize)
+}
+
+func (a *AmbiWriter) stdout(size int) {
+	a.buf = bufio.NewWriterSize(os.Stdout, size)
+	a.r = a.buf
+}
@@ -0,0 +1,37 @@
+package fqfilter
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+func TestCloseStdin(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+	if _, err := io.WriteString(w, "@read1\nACGT\n+\nIIII\n"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	var a AmbiReader
+	if err := a.Open(""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Errorf("Close on stdin returned %v, want nil", err)
+	}
+	// A closed file fails, rather than reporting the end of the pipe
+	if _, err := r.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("reading stdin after Close got %v, want io.EOF, as it should still be open", err)
+	}
+}
@@ -0,0 +1,168 @@
+package fqfilter
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	flagSecondary     = 0x100
+	flagSupplementary = 0x800
+)
+
+/* SAM FLAG bits to go in BamFlags' Require or Exclude */
+const (
+	FlagProperPair = 0x2
+	FlagUnmapped   = 0x4
+)
+
+/* Which alignments in a SAM or BAM file contribute their read name. The zero
+ * value keeps them all. */
+type BamFlags struct {
+	// Skip secondary and supplementary alignments
+	Primary bool
+	// Only use alignments with all these FLAG bits set
+	Require int
+	// Skip alignments with any of these FLAG bits set
+	Exclude int
+}
+
+/* Whether an alignment with these SAM flags should contribute its read name */
+func (b BamFlags) Keep(flag int) bool {
+	if b.Primary && flag&(flagSecondary|flagSupplementary) != 0 {
+		return false
+	}
+	if flag&b.Require != b.Require {
+		return false
+	}
+	return flag&b.Exclude == 0
+}
+
+/* Add the read name (QNAME) of each alignment in a SAM or BAM file to the
+ * filter, returning the number of alignments used. Files ending in .sam or
+ * .sam.gz are read as text, anything else as BAM. */
+func LoadNamesBAM(filter NameSet, fn string, norm Normalizer, flags BamFlags) (int, error) {
+	if path := remotePath(fn); strings.HasSuffix(path, ".sam") || strings.HasSuffix(path, ".sam.gz") {
+		return LoadNamesSAM(filter, fn, norm, flags)
+	}
+	fp, err := openSource(fn)
+	if err != nil {
+		return 0, err
+	}
+	defer fp.Close()
+	// BGZF is a series of gzip members, which gzip.Reader reads as one stream
+	gz, err := gzip.NewReader(fp)
+	if err != nil {
+		return 0, err
+	}
+	defer gz.Close()
+	r := bufio.NewReaderSize(gz, 1024*1024)
+
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return 0, err
+	}
+	if string(magic[:]) != "BAM\x01" {
+		return 0, fmt.Errorf("Not a BAM file")
+	}
+	// Skip the header text and the reference sequence dictionary
+	var n int32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return 0, err
+	}
+	if _, err := r.Discard(int(n)); err != nil {
+		return 0, err
+	}
+	var numRefs int32
+	if err := binary.Read(r, binary.LittleEndian, &numRefs); err != nil {
+		return 0, err
+	}
+	for i := int32(0); i < numRefs; i++ {
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return 0, err
+		}
+		if _, err := r.Discard(int(n) + 4); err != nil {
+			return 0, err
+		}
+	}
+
+	var block []byte
+	used := 0
+	for {
+		var size int32
+		if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+			if err == io.EOF {
+				return used, nil
+			}
+			return used, err
+		}
+		if size < 32 {
+			return used, fmt.Errorf("Invalid BAM record size %d", size)
+		}
+		if cap(block) < int(size) {
+			block = make([]byte, size)
+		}
+		block = block[:size]
+		if _, err := io.ReadFull(r, block); err != nil {
+			return used, err
+		}
+		nameLen := int(block[8])
+		flag := int(binary.LittleEndian.Uint16(block[14:16]))
+		if 32+nameLen > len(block) || nameLen == 0 {
+			return used, fmt.Errorf("Invalid BAM read name length %d", nameLen)
+		}
+		if !flags.Keep(flag) {
+			continue
+		}
+		// The stored name includes a NUL terminator
+		name := string(bytes.TrimRight(block[32:32+nameLen], "\x00"))
+		if err := filter.Add(norm.Entry(name)); err != nil {
+			return used, fmt.Errorf("Invalid entry: %v", err)
+		}
+		used++
+	}
+}
+
+/* Add the read names of a SAM file, as for LoadNamesBAM */
+func LoadNamesSAM(filter NameSet, fn string, norm Normalizer, flags BamFlags) (int, error) {
+	sam := AmbiReader{}
+	if err := sam.Open(fn); err != nil {
+		return 0, err
+	}
+	defer sam.Close()
+
+	scanner := bufio.NewScanner(sam)
+	buf := make([]byte, 0, 1024*1024)
+	scanner.Buffer(buf, 10*1024*1024)
+	line := 0
+	used := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSuffix(scanner.Text(), "\r")
+		if text == "" || strings.HasPrefix(text, "@") {
+			continue
+		}
+		fields := strings.SplitN(text, "\t", 3)
+		if len(fields) < 3 {
+			return used, fmt.Errorf("Line %d is not a SAM record", line)
+		}
+		flag, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return used, fmt.Errorf("Line %d has an invalid FLAG: %s", line, fields[1])
+		}
+		if !flags.Keep(flag) {
+			continue
+		}
+		if err := filter.Add(norm.Entry(fields[0])); err != nil {
+			return used, fmt.Errorf("Invalid entry: %v", err)
+		}
+		used++
+	}
+	return used, scanner.Err()
+}
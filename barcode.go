@@ -0,0 +1,229 @@
+package fqfilter
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/* Where a barcode sits in a read: Len bases from Start, counting from 0 */
+type BarcodePos struct {
+	Start int
+	Len   int
+}
+
+/* Parse a position given as start:len */
+func ParseBarcodePos(s string) (BarcodePos, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return BarcodePos{}, fmt.Errorf("Barcode position %s should be start:len", s)
+	}
+	start, err1 := strconv.Atoi(parts[0])
+	n, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || start < 0 || n < 1 {
+		return BarcodePos{}, fmt.Errorf("Barcode position %s should be start:len, with a start of 0 or more and a length of 1 or more", s)
+	}
+	return BarcodePos{Start: start, Len: n}, nil
+}
+
+/* The barcode at the position in a sequence, or "" if the sequence is too
+ * short to hold it */
+func (p BarcodePos) Extract(seq string) string {
+	if len(seq) < p.Start+p.Len {
+		return ""
+	}
+	return seq[p.Start : p.Start+p.Len]
+}
+
+/* A set of barcodes, all the same length, that sequenced barcodes are
+ * matched against by Hamming distance. A barcode within MaxMismatches of
+ * exactly one entry (and closer to it than any other) matches it; one that
+ * is equally close to two is ambiguous and matches neither. */
+type BarcodeSet struct {
+	MaxMismatches int
+	barcodes      []string
+	index         map[string]int
+}
+
+func NewBarcodeSet(maxMismatches int) *BarcodeSet {
+	return &BarcodeSet{MaxMismatches: maxMismatches, index: make(map[string]int)}
+}
+
+/* Add a barcode, returning its index in the set */
+func (s *BarcodeSet) Add(barcode string) (int, error) {
+	barcode = strings.ToUpper(barcode)
+	if barcode == "" {
+		return 0, fmt.Errorf("Empty barcode")
+	}
+	if len(s.barcodes) > 0 && len(barcode) != len(s.barcodes[0]) {
+		return 0, fmt.Errorf("Barcode %s is not the same length as %s", barcode, s.barcodes[0])
+	}
+	if _, ok := s.index[barcode]; ok {
+		return 0, fmt.Errorf("Barcode %s is listed twice", barcode)
+	}
+	s.index[barcode] = len(s.barcodes)
+	s.barcodes = append(s.barcodes, barcode)
+	return len(s.barcodes) - 1, nil
+}
+
+/* The number of barcodes in the set */
+func (s *BarcodeSet) Len() int {
+	return len(s.barcodes)
+}
+
+/* The barcode with the given index */
+func (s *BarcodeSet) Barcode(i int) string {
+	return s.barcodes[i]
+}
+
+/* The index of the entry a sequenced barcode matches, and the number of
+ * mismatches, or -1 if it matches none. With one mismatch allowed, every
+ * single base change is looked up, which stays fast for whitelists of
+ * millions of cell barcodes; more than that compares against every entry. */
+func (s *BarcodeSet) Match(barcode string) (int, int) {
+	barcode = strings.ToUpper(barcode)
+	if i, ok := s.index[barcode]; ok {
+		return i, 0
+	}
+	if s.MaxMismatches == 0 || len(s.barcodes) == 0 || len(barcode) != len(s.barcodes[0]) {
+		return -1, 0
+	}
+	if s.MaxMismatches == 1 {
+		return s.matchOne(barcode)
+	}
+	best, bestDist, ties := -1, s.MaxMismatches+1, 0
+	for i, b := range s.barcodes {
+		d := hamming(barcode, b, bestDist)
+		if d < bestDist {
+			best, bestDist, ties = i, d, 0
+		} else if d == bestDist {
+			ties++
+		}
+	}
+	if best < 0 || ties > 0 {
+		return -1, 0
+	}
+	return best, bestDist
+}
+
+func (s *BarcodeSet) matchOne(barcode string) (int, int) {
+	found := -1
+	b := []byte(barcode)
+	for i := range b {
+		orig := b[i]
+		for _, c := range []byte("ACGTN") {
+			if c == orig {
+				continue
+			}
+			b[i] = c
+			if j, ok := s.index[string(b)]; ok {
+				if found >= 0 && found != j {
+					return -1, 0
+				}
+				found = j
+			}
+		}
+		b[i] = orig
+	}
+	return found, 1
+}
+
+/* The number of positions where two strings of the same length differ,
+ * stopping once it reaches limit */
+func hamming(a, b string, limit int) int {
+	d := 0
+	for i := 0; i < len(a) && d < limit; i++ {
+		if a[i] != b[i] {
+			d++
+		}
+	}
+	return d
+}
+
+/* Add the barcodes in a whitelist file, one per line, to the set, returning
+ * the number added. Blank lines are skipped. The file may be compressed. */
+func LoadBarcodes(set *BarcodeSet, fn string) (int, error) {
+	r := &AmbiReader{}
+	if err := r.Open(fn); err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	scanner := bufio.NewScanner(r)
+	lines, added := 0, 0
+	for scanner.Scan() {
+		lines++
+		barcode := strings.TrimSpace(scanner.Text())
+		if barcode == "" {
+			continue
+		}
+		if !isBases(barcode) {
+			return added, fmt.Errorf("Line %d of %s is not a barcode", lines, fn)
+		}
+		if _, err := set.Add(barcode); err != nil {
+			return added, fmt.Errorf("Line %d of %s: %v", lines, fn, err)
+		}
+		added++
+	}
+	return added, scanner.Err()
+}
+
+/* A line of a sample sheet. Dual indexed samples have a second barcode. */
+type SampleBarcode struct {
+	Barcode  string
+	Barcode2 string
+	Sample   string
+}
+
+/* Read a CSV sample sheet of barcode,sample lines, where a dual index
+ * barcode is written I1+I2. A first line that doesn't start with a barcode
+ * is taken to be a header and skipped, as are blank lines and lines starting
+ * with #. */
+func ReadSampleSheet(r io.Reader) ([]SampleBarcode, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	var samples []SampleBarcode
+	for line := 1; ; line++ {
+		fields, err := cr.Read()
+		if err == io.EOF {
+			return samples, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("Sample sheet line %d should be barcode,sample", line)
+		}
+		barcode, barcode2, _ := strings.Cut(strings.TrimSpace(fields[0]), "+")
+		if !isBases(barcode) || barcode2 != "" && !isBases(barcode2) {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("Sample sheet line %d has %s where a barcode should be", line, fields[0])
+		}
+		sample := strings.TrimSpace(fields[1])
+		if sample == "" || strings.ContainsAny(sample, "/\\") {
+			return nil, fmt.Errorf("Sample sheet line %d needs a sample name without slashes", line)
+		}
+		samples = append(samples, SampleBarcode{Barcode: strings.ToUpper(barcode), Barcode2: strings.ToUpper(barcode2), Sample: sample})
+	}
+}
+
+/* Whether s is a non-empty string of bases */
+func isBases(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case 'A', 'C', 'G', 'T', 'N', 'a', 'c', 'g', 't', 'n':
+		default:
+			return false
+		}
+	}
+	return true
+}
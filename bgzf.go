@@ -0,0 +1,210 @@
+package fqfilter
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+/* The most uncompressed data put in one BGZF block, leaving room for the
+ * block to stay under 64KiB even if it doesn't compress */
+const bgzfBlockSize = 0xff00
+
+/* The empty block that marks the end of a BGZF file */
+var bgzfEOF = []byte{
+	0x1f, 0x8b, 0x08, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0x06, 0x00, 0x42, 0x43,
+	0x02, 0x00, 0x1b, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+}
+
+/* Writes BGZF, the blocked gzip of BAM files: a series of gzip members of at
+ * most 64KiB, each recording its own size in a BC extra field, so readers can
+ * seek to a block. Any gzip reader reads it as one stream. Close writes the
+ * end-of-file block, but leaves the underlying writer open. */
+type BGZFWriter struct {
+	w     io.Writer
+	level int
+	buf   []byte
+	block bytes.Buffer
+	fw    *flate.Writer
+}
+
+/* Compress at the given gzip level */
+func NewBGZFWriter(w io.Writer, level int) (*BGZFWriter, error) {
+	fw, err := flate.NewWriter(nil, level)
+	if err != nil {
+		return nil, err
+	}
+	return &BGZFWriter{w: w, level: level, fw: fw}, nil
+}
+
+func (b *BGZFWriter) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	for len(b.buf) >= bgzfBlockSize {
+		if err := b.writeBlock(b.buf[:bgzfBlockSize]); err != nil {
+			return 0, err
+		}
+		b.buf = b.buf[:copy(b.buf, b.buf[bgzfBlockSize:])]
+	}
+	return len(p), nil
+}
+
+/* Compress data into one block */
+func (b *BGZFWriter) writeBlock(data []byte) error {
+	b.block.Reset()
+	b.fw.Reset(&b.block)
+	if _, err := b.fw.Write(data); err != nil {
+		return err
+	}
+	if err := b.fw.Close(); err != nil {
+		return err
+	}
+	header := []byte{0x1f, 0x8b, 0x08, 0x04, 0, 0, 0, 0, 0, 0xff, 6, 0, 'B', 'C', 2, 0, 0, 0}
+	// BSIZE is the size of the whole block, less one
+	binary.LittleEndian.PutUint16(header[16:], uint16(len(header)+b.block.Len()+8-1))
+	var trailer [8]byte
+	binary.LittleEndian.PutUint32(trailer[0:], crc32.ChecksumIEEE(data))
+	binary.LittleEndian.PutUint32(trailer[4:], uint32(len(data)))
+	for _, part := range [][]byte{header, b.block.Bytes(), trailer[:]} {
+		if _, err := b.w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* Write out what is left, then the end-of-file block */
+func (b *BGZFWriter) Close() error {
+	if len(b.buf) > 0 {
+		if err := b.writeBlock(b.buf); err != nil {
+			return err
+		}
+		b.buf = b.buf[:0]
+	}
+	_, err := b.w.Write(bgzfEOF)
+	return err
+}
+
+/* Reads BGZF a block at a time, so that it can seek to a virtual offset: the
+ * offset of a block in the file, shifted up 16 bits, plus an offset into its
+ * uncompressed data. */
+type BGZFReader struct {
+	r      io.ReadSeeker
+	fr     io.ReadCloser
+	header [12]byte
+	cdata  []byte
+	block  bytes.Buffer
+	data   []byte
+	pos    int
+	// Offsets in the file of the block in data and of the next one
+	offset int64
+	next   int64
+}
+
+/* Read from the start of r */
+func NewBGZFReader(r io.ReadSeeker) *BGZFReader {
+	return &BGZFReader{r: r}
+}
+
+/* Whether a file starts with a BGZF block, rather than plain gzip */
+func isBGZF(start []byte) bool {
+	return len(start) >= 16 && bytes.HasPrefix(start, gzipMagic) && start[3]&4 != 0 && start[12] == 'B' && start[13] == 'C'
+}
+
+/* Read the next block, returning io.EOF at the end of the file */
+func (b *BGZFReader) readBlock() error {
+	if _, err := io.ReadFull(b.r, b.header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("BGZF file ends part way through a block")
+		}
+		return err
+	}
+	if !bytes.HasPrefix(b.header[:], gzipMagic) || b.header[3]&4 == 0 {
+		return fmt.Errorf("Not a BGZF block at offset %d", b.next)
+	}
+	xlen := int(binary.LittleEndian.Uint16(b.header[10:]))
+	extra := make([]byte, xlen)
+	if _, err := io.ReadFull(b.r, extra); err != nil {
+		return fmt.Errorf("BGZF file ends part way through a block")
+	}
+	// Find the BC subfield, which holds the block size
+	size := -1
+	for i := 0; i+4 <= len(extra); {
+		n := int(binary.LittleEndian.Uint16(extra[i+2:]))
+		if extra[i] == 'B' && extra[i+1] == 'C' && n == 2 && i+6 <= len(extra) {
+			size = int(binary.LittleEndian.Uint16(extra[i+4:])) + 1
+		}
+		i += 4 + n
+	}
+	rest := size - len(b.header) - xlen
+	if size < 0 || rest < 8 {
+		return fmt.Errorf("Not a BGZF block at offset %d", b.next)
+	}
+	if cap(b.cdata) < rest {
+		b.cdata = make([]byte, rest)
+	}
+	b.cdata = b.cdata[:rest]
+	if _, err := io.ReadFull(b.r, b.cdata); err != nil {
+		return fmt.Errorf("BGZF file ends part way through a block")
+	}
+	if b.fr == nil {
+		b.fr = flate.NewReader(bytes.NewReader(b.cdata[:rest-8]))
+	} else if err := b.fr.(flate.Resetter).Reset(bytes.NewReader(b.cdata[:rest-8]), nil); err != nil {
+		return err
+	}
+	b.block.Reset()
+	if _, err := b.block.ReadFrom(b.fr); err != nil {
+		return err
+	}
+	b.data = b.block.Bytes()
+	trailer := b.cdata[rest-8:]
+	if crc32.ChecksumIEEE(b.data) != binary.LittleEndian.Uint32(trailer) || uint32(len(b.data)) != binary.LittleEndian.Uint32(trailer[4:]) {
+		return fmt.Errorf("Corrupt BGZF block at offset %d", b.next)
+	}
+	b.pos = 0
+	b.offset = b.next
+	b.next += int64(size)
+	return nil
+}
+
+func (b *BGZFReader) Read(p []byte) (int, error) {
+	// Skip past the end of the block, and any empty ones like the EOF block
+	for b.pos == len(b.data) {
+		if err := b.readBlock(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, b.data[b.pos:])
+	b.pos += n
+	return n, nil
+}
+
+/* The virtual offset of the next byte Read returns */
+func (b *BGZFReader) Tell() int64 {
+	if b.pos == len(b.data) {
+		return b.next << 16
+	}
+	return b.offset<<16 | int64(b.pos)
+}
+
+/* Move to a virtual offset, as given by Tell */
+func (b *BGZFReader) SeekVirtual(voffset int64) error {
+	offset, pos := voffset>>16, int(voffset&0xffff)
+	if _, err := b.r.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	b.data, b.pos, b.next = nil, 0, offset
+	if pos == 0 {
+		return nil
+	}
+	if err := b.readBlock(); err != nil {
+		return err
+	}
+	if pos > len(b.data) {
+		return fmt.Errorf("Virtual offset %d is past the end of its block", voffset)
+	}
+	b.pos = pos
+	return nil
+}
@@ -0,0 +1,113 @@
+package fqfilter
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+/* The false positive rate a BloomSet is sized for unless told otherwise */
+const DefaultBloomRate = 0.01
+
+/* Approximate matching with a Bloom filter, for lists too big even for
+ * HashSet. A name that is not in the list falsely matches with about the
+ * given rate; with 1% that takes under 10 bits per name, against 64 for
+ * HashSet. While loading, the hashes are held as in HashSet, and the filter
+ * is built (and the hashes dropped) on first lookup. Which entries matched is
+ * not tracked, so Matched is always 0. */
+type BloomSet struct {
+	Rate   float64
+	hashes []uint64
+	bits   []uint64
+	k      int
+	n      int
+}
+
+func (s *BloomSet) Add(entry string) error {
+	if s.bits != nil {
+		return fmt.Errorf("Cannot add names to a Bloom filter once it is built")
+	}
+	s.hashes = append(s.hashes, hashName(entry))
+	return nil
+}
+
+/* Size the filter for the distinct names: m = -n ln(p) / ln(2)^2 bits and
+ * k = m/n ln(2) hash functions */
+func (s *BloomSet) prepare() {
+	rate := s.Rate
+	if rate <= 0 || rate >= 1 {
+		rate = DefaultBloomRate
+	}
+	sort.Slice(s.hashes, func(i, j int) bool { return s.hashes[i] < s.hashes[j] })
+	for i, h := range s.hashes {
+		if i == 0 || h != s.hashes[i-1] {
+			s.n++
+		}
+	}
+	m := uint64(math.Ceil(-float64(s.n) * math.Log(rate) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	s.bits = make([]uint64, (m+63)/64)
+	s.k = int(math.Round(float64(m) / float64(max(s.n, 1)) * math.Ln2))
+	if s.k < 1 {
+		s.k = 1
+	}
+	for _, h := range s.hashes {
+		s.set(h)
+	}
+	s.hashes = nil
+}
+
+/* The k bit positions come from two hashes of the name (Kirsch and
+ * Mitzenmacher), the second mixed out of the first */
+func (s *BloomSet) bit(h uint64, i int) uint64 {
+	return (h + uint64(i)*(mix64(h)|1)) % (uint64(len(s.bits)) * 64)
+}
+
+func (s *BloomSet) set(h uint64) {
+	for i := 0; i < s.k; i++ {
+		b := s.bit(h, i)
+		s.bits[b/64] |= 1 << (b % 64)
+	}
+}
+
+/* The splitmix64 finalizer */
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func (s *BloomSet) Contains(name string) bool {
+	if s.bits == nil {
+		s.prepare()
+	}
+	h := hashName(name)
+	for i := 0; i < s.k; i++ {
+		b := s.bit(h, i)
+		if s.bits[b/64]&(1<<(b%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+/* The number of distinct hashes added */
+func (s *BloomSet) Len() int {
+	if s.bits == nil {
+		s.prepare()
+	}
+	return s.n
+}
+
+func (s *BloomSet) Matched() int {
+	return 0
+}
+
+func (s *BloomSet) Unmatched() ([]string, error) {
+	return nil, fmt.Errorf("names are not kept with -set-mode bloom")
+}
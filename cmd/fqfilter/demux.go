@@ -0,0 +1,240 @@
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kbullaugheysas/fqfilter"
+)
+
+/* The demux command splits reads into one set of files per sample, by a
+ * barcode read from index files or from a fixed place in the first mate */
+
+type DemuxArgs struct {
+	Samples      string
+	Index1       string
+	Index2       string
+	BarcodePos   string
+	TrimBarcode  bool
+	Mismatches   int
+	OutDir       string
+	OutTemplate  string
+	Undetermined string
+	GzipLevel    int
+	Limit        int
+	Threads      int
+	Quiet        bool
+}
+
+var demuxArgs = DemuxArgs{}
+
+var demuxFlags = flag.NewFlagSet("demux", flag.ExitOnError)
+
+func init() {
+	demuxFlags.StringVar(&demuxArgs.Samples, "samples", "", "CSV sample sheet of barcode,sample lines, with dual index barcodes written I1+I2 (required)")
+	demuxFlags.StringVar(&demuxArgs.Index1, "index1", "", "FASTQ file of the first index read (I1), in step with the inputs")
+	demuxFlags.StringVar(&demuxArgs.Index2, "index2", "", "FASTQ file of the second index read (I2), for dual index samples")
+	demuxFlags.StringVar(&demuxArgs.BarcodePos, "barcode-pos", "", "read the barcode from the first mate instead, as start:len (counting from 0)")
+	demuxFlags.BoolVar(&demuxArgs.TrimBarcode, "trim-barcode", false, "with -barcode-pos, clip the barcode, and anything before it, from the first mate")
+	demuxFlags.IntVar(&demuxArgs.Mismatches, "mismatches", 1, "the most mismatches allowed between a read's barcode and a sample's (a barcode as close to two samples matches neither)")
+	demuxFlags.StringVar(&demuxArgs.OutDir, "out-dir", ".", "directory to write <sample>.fq.gz, or <sample>_1.fq.gz and so on, into")
+	demuxFlags.StringVar(&demuxArgs.OutTemplate, "out-template", "", "name each sample's files in -out-dir by this template, as in {sample}_S1_R{mate}_001.fastq.gz, where {mate} is the mate's number and {tag} its R1 or I1 name")
+	demuxFlags.StringVar(&demuxArgs.Undetermined, "undetermined", "Undetermined", "the name to write reads that match no sample under")
+	demuxFlags.IntVar(&demuxArgs.GzipLevel, "gzip-level", gzip.DefaultCompression, "gzip compression level for output files (1-9, or 0 for uncompressed output)")
+	demuxFlags.IntVar(&demuxArgs.Limit, "limit-per-output", 0, "write at most this many reads for each sample (and -undetermined), stopping once every sample has them")
+	demuxFlags.IntVar(&demuxArgs.Threads, "threads", 1, "number of goroutines decompressing each compressed input and compressing each compressed output file")
+	addMaxLineFlag(demuxFlags)
+	demuxFlags.BoolVar(&demuxArgs.Quiet, "quiet", false, "don't log the counts to stderr")
+	addForceFlag(demuxFlags)
+	addLogFlags(demuxFlags)
+
+	demuxFlags.Usage = func() {
+		log.Println("usage: fqfilter demux -samples sheet.csv [options] reads_1.fq.gz [reads_2.fq.gz ...]")
+		demuxFlags.PrintDefaults()
+	}
+}
+
+/* Load the sample sheet into a set of barcodes, returning the sample for
+ * each barcode and the sample names in the order they first appear */
+func loadSamples(fn string, dual bool) (*fqfilter.BarcodeSet, []string, []string) {
+	fp, err := os.Open(fn)
+	if err != nil {
+		fatalf("Failed to open %s: %v\n", fn, err)
+	}
+	defer fp.Close()
+	sheet, err := fqfilter.ReadSampleSheet(fp)
+	if err != nil {
+		fatalf("Failed to read %s: %v\n", fn, err)
+	}
+	if len(sheet) == 0 {
+		fatalf("%s lists no samples\n", fn)
+	}
+	set := fqfilter.NewBarcodeSet(demuxArgs.Mismatches)
+	var barcodeSample, order []string
+	seen := make(map[string]bool)
+	for _, s := range sheet {
+		if dual && s.Barcode2 == "" {
+			fatalf("Sample %s needs an I1+I2 barcode, as there is an -index2\n", s.Sample)
+		}
+		if !dual && s.Barcode2 != "" {
+			fatalf("Sample %s has an I1+I2 barcode, but there is no -index2\n", s.Sample)
+		}
+		if s.Sample == demuxArgs.Undetermined {
+			fatalf("Sample %s has the same name as the -undetermined reads\n", s.Sample)
+		}
+		if _, err := set.Add(s.Barcode + s.Barcode2); err != nil {
+			fatalf("Failed to read %s: %v\n", fn, err)
+		}
+		barcodeSample = append(barcodeSample, s.Sample)
+		if !seen[s.Sample] {
+			seen[s.Sample] = true
+			order = append(order, s.Sample)
+		}
+	}
+	return set, barcodeSample, order
+}
+
+func runDemux(argv []string) {
+	demuxFlags.Parse(argv)
+	fq := demuxFlags.Args()
+	if len(fq) == 0 {
+		usageFatal("Must specify at least one fastq file")
+	}
+	if demuxArgs.Samples == "" {
+		usageFatal("Must provide a -samples sheet")
+	}
+	if (demuxArgs.Index1 == "") == (demuxArgs.BarcodePos == "") {
+		usageFatal("Must provide either -index1 or -barcode-pos")
+	}
+	if demuxArgs.Index2 != "" && demuxArgs.Index1 == "" {
+		usageFatal("-index2 needs -index1")
+	}
+	if demuxArgs.TrimBarcode && demuxArgs.BarcodePos == "" {
+		usageFatal("-trim-barcode needs -barcode-pos")
+	}
+	if demuxArgs.Mismatches < 0 {
+		usageFatal("-mismatches must not be negative")
+	}
+	if demuxArgs.OutTemplate != "" && !strings.Contains(demuxArgs.OutTemplate, "{sample}") {
+		usageFatal("-out-template needs a {sample} to keep the samples apart")
+	}
+	if demuxArgs.Threads < 1 {
+		usageFatal("-threads must be at least 1")
+	}
+	if demuxArgs.Limit < 0 {
+		usageFatal("-limit-per-output must not be negative")
+	}
+	var pos fqfilter.BarcodePos
+	if demuxArgs.BarcodePos != "" {
+		var err error
+		if pos, err = fqfilter.ParseBarcodePos(demuxArgs.BarcodePos); err != nil {
+			fatal(err)
+		}
+	}
+
+	set, barcodeSample, samples := loadSamples(demuxArgs.Samples, demuxArgs.Index2 != "")
+
+	// The index reads are read in step with the reads, as extra mates
+	files := append([]string{}, fq...)
+	for _, fn := range []string{demuxArgs.Index1, demuxArgs.Index2} {
+		if fn != "" {
+			files = append(files, fn)
+		}
+	}
+	inputs, readers := openInputs(files, false, false, demuxArgs.Threads)
+	for i := range inputs {
+		defer inputs[i].Close()
+	}
+	paired := fqfilter.NewPairedReader(files, readers)
+	paired.CheckNames = true
+
+	if err := os.MkdirAll(demuxArgs.OutDir, 0777); err != nil {
+		fatal(err)
+	}
+	opts := fqfilter.OutputOptions{
+		WriteOptions: safeWrites(fqfilter.WriteOptions{
+			Level:      demuxArgs.GzipLevel,
+			Threads:    demuxArgs.Threads,
+			BufferSize: fqfilter.DefaultBufferSize,
+		}),
+	}
+	if demuxArgs.OutTemplate != "" {
+		opts.Template = filepath.Join(demuxArgs.OutDir, demuxArgs.OutTemplate)
+		opts.MateNames = fqfilter.MateNamesFromFiles(fq)
+	}
+	outputs := make(map[string]*fqfilter.Output)
+	for _, sample := range append(samples, demuxArgs.Undetermined) {
+		opts.Vars = map[string]string{"sample": sample}
+		out, err := fqfilter.OpenOutput(filepath.Join(demuxArgs.OutDir, sample), len(fq), opts)
+		if err != nil {
+			fatal(err)
+		}
+		outputs[sample] = out
+	}
+
+	counts := make(map[string]int)
+	// The samples that have had -limit-per-output reads
+	full := 0
+	corrected := 0
+	mates := make([]fqfilter.Record, len(files))
+	for {
+		if err := paired.Read(mates); err == io.EOF {
+			break
+		} else if err != nil {
+			inputFatal(err)
+		}
+		var barcode string
+		if demuxArgs.Index1 != "" {
+			barcode = mates[len(fq)].Sequence
+			if demuxArgs.Index2 != "" {
+				barcode += mates[len(fq)+1].Sequence
+			}
+		} else {
+			barcode = pos.Extract(mates[0].Sequence)
+		}
+		sample := demuxArgs.Undetermined
+		if i, mismatches := set.Match(barcode); i >= 0 {
+			sample = barcodeSample[i]
+			if mismatches > 0 {
+				corrected++
+			}
+			if demuxArgs.TrimBarcode {
+				mates[0].Clip(pos.Start + pos.Len)
+			}
+		}
+		if demuxArgs.Limit > 0 && counts[sample] >= demuxArgs.Limit {
+			continue
+		}
+		counts[sample]++
+		name := mates[0].Header
+		if err := outputs[sample].Write(name, mates[:len(fq)]); err != nil {
+			fatal(err)
+		}
+		if demuxArgs.Limit > 0 && counts[sample] == demuxArgs.Limit {
+			if full++; full == len(outputs) {
+				if !demuxArgs.Quiet {
+					log.Println("reached -limit-per-output for every sample")
+				}
+				break
+			}
+		}
+	}
+
+	for sample, out := range outputs {
+		if err := out.Close(); err != nil {
+			fatalf("Failed to close output for %s: %v\n", sample, err)
+		}
+	}
+
+	if !demuxArgs.Quiet {
+		for _, sample := range append(samples, demuxArgs.Undetermined) {
+			log.Printf("%s: %d\n", sample, counts[sample])
+		}
+		log.Println("barcodes corrected:", corrected)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/kbullaugheysas/fqfilter"
+)
+
+/* The fetch command pulls the reads named in a reads file out of indexed
+ * FASTQ files, seeking straight to each one rather than reading the whole
+ * file. The reads are written in the order of the first input. */
+
+type FetchArgs struct {
+	Reads           string
+	Index           string
+	OutPrefix       string
+	GzipLevel       int
+	StripMate       bool
+	StripMateSuffix bool
+	Quiet           bool
+}
+
+var fetchArgs = FetchArgs{}
+
+var fetchFlags = flag.NewFlagSet("fetch", flag.ExitOnError)
+
+func init() {
+	fetchFlags.StringVar(&fetchArgs.Reads, "reads", "", "file with the names of the reads to fetch, in any format -reads takes (required)")
+	fetchFlags.StringVar(&fetchArgs.Index, "index", "", "comma-separated index files, one per input (default = each input with .fqi added)")
+	fetchFlags.StringVar(&fetchArgs.OutPrefix, "out", "", "output filename prefix, as for filter (default = stdout)")
+	fetchFlags.IntVar(&fetchArgs.GzipLevel, "gzip-level", gzip.DefaultCompression, "gzip compression level for output files (1-9, or 0 for uncompressed output)")
+	fetchFlags.BoolVar(&fetchArgs.StripMate, "strip-mate", false, "remove a trailing /1 or /2, or an Illumina 1:N:0: style tag, from read names before matching")
+	fetchFlags.BoolVar(&fetchArgs.StripMateSuffix, "strip-mate-suffix", false, "like -strip-mate, but also remove a trailing .1 or .2 (not for SRA names like SRR001.1, where it numbers the spot)")
+	fetchFlags.BoolVar(&fetchArgs.Quiet, "quiet", false, "don't log the counts to stderr")
+	addForceFlag(fetchFlags)
+	addLogFlags(fetchFlags)
+
+	fetchFlags.Usage = func() {
+		log.Println("usage: fqfilter fetch -reads names.txt [options] reads_1.fq.gz [reads_2.fq.gz ...]")
+		log.Println("Each input must have been indexed with fqfilter index.")
+		fetchFlags.PrintDefaults()
+	}
+}
+
+func runFetch(argv []string) {
+	fetchFlags.Parse(argv)
+	fq := fetchFlags.Args()
+	if len(fq) == 0 {
+		usageFatal("Must specify at least one fastq file")
+	}
+	if fetchArgs.Reads == "" {
+		usageFatal("Must provide -reads <file>")
+	}
+	indexes := make([]string, len(fq))
+	for i, fn := range fq {
+		indexes[i] = fqfilter.IndexFilename(fn)
+	}
+	if fetchArgs.Index != "" {
+		indexes = strings.Split(fetchArgs.Index, ",")
+		if len(indexes) != len(fq) {
+			usageFatalf("-index lists %d files, but there are %d inputs\n", len(indexes), len(fq))
+		}
+	}
+
+	norm := fqfilter.Normalizer{
+		ShortName:    true,
+		StripMate:    fetchArgs.StripMate || fetchArgs.StripMateSuffix,
+		StripMateDot: fetchArgs.StripMateSuffix,
+	}
+	names := make(fqfilter.ExactSet)
+	if _, err := fqfilter.LoadReads(names, fetchArgs.Reads, fqfilter.DetectReadsFormat(fetchArgs.Reads), norm, fqfilter.BamFlags{}, maxLineBytes); err != nil {
+		fatalf("Failed to load %s: %v\n", fetchArgs.Reads, err)
+	}
+
+	offsets := make([]map[string]int64, len(fq))
+	for i, fn := range fq {
+		found, err := fqfilter.LookupIndex(fn, indexes[i], names, norm)
+		if err != nil {
+			fatalf("Failed to read the index of %s: %v\n", fn, err)
+		}
+		offsets[i] = found
+	}
+	// Fetch in file order, so the reads come out as they were and the seeks
+	// all go forward
+	var order []string
+	for name := range offsets[0] {
+		order = append(order, name)
+	}
+	sort.Slice(order, func(a, b int) bool { return offsets[0][order[a]] < offsets[0][order[b]] })
+
+	inputs := make([]*fqfilter.IndexedFastq, len(fq))
+	for i, fn := range fq {
+		f, err := fqfilter.OpenIndexedFastq(fn)
+		if err != nil {
+			fatalf("Failed to open %s: %v\n", fn, err)
+		}
+		inputs[i] = f
+	}
+	opts := fqfilter.OutputOptions{
+		MateNames: fqfilter.MateNamesFromFiles(fq),
+		WriteOptions: safeWrites(fqfilter.WriteOptions{
+			Level:      fetchArgs.GzipLevel,
+			Threads:    1,
+			BufferSize: fqfilter.DefaultBufferSize,
+		}),
+	}
+	output, err := fqfilter.OpenOutput(fetchArgs.OutPrefix, len(fq), opts)
+	if err != nil {
+		fatal(err)
+	}
+
+	mates := make([]fqfilter.Record, len(fq))
+	for _, name := range order {
+		for i, fn := range fq {
+			offset, ok := offsets[i][name]
+			if !ok {
+				fatalf("%s is in %s but not in %s\n", name, fq[0], fn)
+			}
+			if err := inputs[i].ReadAt(offset, &mates[i]); err != nil {
+				inputFatalf("%s: %v\n", fn, err)
+			}
+		}
+		if err := output.Write(mates[0].Header, mates); err != nil {
+			fatal(err)
+		}
+	}
+	for i := range inputs {
+		inputs[i].Close()
+	}
+	if err := output.Close(); err != nil {
+		fatalf("Failed to close output: %v\n", err)
+	}
+	if !fetchArgs.Quiet {
+		log.Printf("fetched %d of %d names\n", len(order), names.Len())
+	}
+}
@@ -0,0 +1,2000 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kbullaugheysas/fqfilter"
+)
+
+/* The filter command takes on one or two (in the case of paried end data) fq
+ * files and returns a subset of the reads */
+
+type Args struct {
+	Invert           bool
+	ReadsFilenames   StringList
+	Pairs            StringList
+	PerLane          bool
+	FailIfEmpty      bool
+	Config           string
+	Names            StringList
+	NameRegexps      StringList
+	ReadsBAM         StringList
+	ReadsFormat      string
+	SetOp            string
+	BamPrimary       bool
+	BamRequire       int
+	BamExclude       int
+	BamMapped        bool
+	BamUnmapped      bool
+	BamProperPair    bool
+	OutPrefix        string
+	OutTemplate      string
+	ChunkSize        int
+	Rename           string
+	Limit            int
+	LimitExcluded    int
+	LimitPerOutput   int
+	Skip             int
+	Every            int
+	EveryOffset      int
+	Take             int
+	Range            string
+	Tab              bool
+	TabQual          bool
+	TabHeader        bool
+	Fasta            bool
+	OutFormat        string
+	ReadGroup        string
+	ReadGroupSample  string
+	InFormat         string
+	FastaWidth       int
+	ShortName        bool
+	Prefix           bool
+	MatchMode        string
+	Regexp           bool
+	HashSet          bool
+	SetMode          string
+	BloomRate        float64
+	GzipLevel        int
+	Zstd             bool
+	BGZF             bool
+	ZstdLevel        int
+	OutCompress      string
+	NoGzip           bool
+	CompressLevel    int
+	RejectedPrefix   string
+	Singletons       string
+	OutMatched       string
+	OutUnmatched     string
+	StatsJSON        string
+	SummaryJSON      string
+	QCReport         string
+	Quiet            bool
+	Unmatched        string
+	Interleaved      bool
+	Deinterleave     bool
+	OutInterleaved   bool
+	StripMate        bool
+	StripMateSuffix  bool
+	Fraction         float64
+	Sample           int
+	Seed             int64
+	Strict           bool
+	NoCheckPairs     bool
+	Threads          int
+	BufferSize       int
+	Sorted           bool
+	Progress         bool
+	ProgressInterval time.Duration
+	MinLen           int
+	MaxLen           int
+	MinMeanQual      float64
+	MinBaseQual      int
+	MaxLowQualFrac   float64
+	QualOffset       int
+	ConvertQual      int
+	PairPolicy       string
+	MaxN             float64
+	MinComplexity    float64
+	MinGC            float64
+	MaxGC            float64
+	FilterExpr       string
+	BarcodeWhitelist string
+	BarcodePos       string
+	BarcodeMismatch  int
+	BarcodeCorrect   bool
+	UMILen           int
+	UMIRead          int
+	Crop             int
+	Crop1            int
+	Crop2            int
+	HeadCrop         int
+	HeadCrop1        int
+	HeadCrop2        int
+	Adapters         StringList
+	AdapterErrorRate float64
+	AdapterOverlap   int
+	TrimQual         float64
+	TrimWindow       int
+	TrimPolyX        string
+	TrimPolyXMin     int
+	Dedup            bool
+	DedupBy          string
+	DedupStartLen    int
+	CountOnly        bool
+	Out1             string
+	Out2             string
+	Trim             int
+	RevComp          string
+	IgnoreCase       bool
+	Explain          int
+	ExplainEvery     int
+	AllowEmpty       bool
+	SeqMatch         StringList
+	SeqMatchMate     string
+	SeqMatchRevComp  bool
+	Contains         StringList
+	ContainsInvert   bool
+	Contaminants     string
+	ContaminantK     int
+	ContaminantHits  int
+	Plugins          StringList
+}
+
+/* A flag that can be given more than once, collecting each value */
+type StringList []string
+
+func (l *StringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *StringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+var args = Args{}
+
+/* The number of records each input reads ahead at a time with -threads */
+const readAheadBatch = 1024
+
+/* The number of reads handed over to each output's writer at a time with
+ * -threads */
+const writeBehindBatch = 1024
+
+var filterFlags = flag.NewFlagSet("filter", flag.ExitOnError)
+
+func init() {
+	filterFlags.BoolVar(&args.CountOnly, "count-only", false, "a dry run: apply every filter, but write no output, only logging how many reads would be included and excluded")
+	filterFlags.BoolVar(&args.Invert, "invert", false, "return reads NOT in the file")
+	filterFlags.BoolVar(&args.Tab, "tab", false, "print sequence as tabular output: the read name then one sequence column per input, in command-line order (written to <out>.tsv.gz when -out is given)")
+	filterFlags.BoolVar(&args.TabQual, "tab-qual", false, "with -tab, follow each sequence column with a column of its qualities")
+	filterFlags.BoolVar(&args.TabHeader, "tab-header", false, "with -tab, start with a row naming the columns (name, seq_1, qual_1 and so on), for loading into R or pandas")
+	filterFlags.BoolVar(&args.Fasta, "fasta", false, "same as -out-format fasta")
+	filterFlags.StringVar(&args.OutFormat, "out-format", "fastq", "how to write reads: fastq, fasta (header and sequence only, to <out>.fa.gz), tab (see -tab) or ubam (an unaligned BAM of unmapped pairs, to <out>.bam, as GATK takes)")
+	filterFlags.StringVar(&args.ReadGroup, "rg", "", "with -out-format ubam, tag every read with this read group ID")
+	filterFlags.StringVar(&args.ReadGroupSample, "rg-sample", "", "the sample (SM) of the -rg read group (default the -rg ID)")
+	filterFlags.StringVar(&args.InFormat, "in-format", "auto", "the format of the inputs: fastq, fasta, or auto to treat .fa, .fasta and .fna files as FASTA (which is then written as FASTA)")
+	filterFlags.IntVar(&args.FastaWidth, "fasta-width", 0, "wrap FASTA sequence lines at this many bases (0 for one line per sequence)")
+	filterFlags.BoolVar(&args.Interleaved, "interleaved", false, "the single input file (which can be - for stdin) holds both mates, alternating (output stays interleaved)")
+	filterFlags.BoolVar(&args.Deinterleave, "deinterleave", false, "with -interleaved, write the mates to separate _1 and _2 outputs")
+	filterFlags.BoolVar(&args.OutInterleaved, "out-interleaved", false, "write the mates from two or more inputs interleaved into a single <out>.fq.gz (stdout is always interleaved)")
+	filterFlags.BoolVar(&args.NoCheckPairs, "no-check-pairs", false, "don't check that the mates of each read have the same name (ignoring /1, /2 and Illumina comments)")
+	filterFlags.BoolVar(&args.Strict, "strict", false, "fail if a quality line differs in length from its sequence")
+	filterFlags.BoolVar(&args.ShortName, "short-name", false, "use just the first space-separated word of the read name")
+	filterFlags.BoolVar(&args.StripMate, "strip-mate", false, "remove a trailing /1 or /2, or an Illumina 1:N:0: style tag, from read names (after -short-name)")
+	filterFlags.BoolVar(&args.StripMateSuffix, "strip-mate-suffix", false, "like -strip-mate, but also remove a trailing .1 or .2, as some aligners write (not for SRA names like SRR001.1, where it numbers the spot)")
+	filterFlags.BoolVar(&args.IgnoreCase, "ignore-case", false, "match read names case-insensitively (after -short-name and -strip-mate)")
+	filterFlags.StringVar(&args.MatchMode, "match-mode", "exact", "how names match the reads file: exact, prefix (same as -prefix, for truncated names) or ci (same as -ignore-case)")
+	filterFlags.BoolVar(&args.Prefix, "prefix", false, "treat each entry in the reads file as a read name prefix (-invert applies after matching)")
+	filterFlags.BoolVar(&args.Regexp, "regexp", false, "treat each entry in the reads file as a regular expression (-invert applies after matching)")
+	filterFlags.BoolVar(&args.Sorted, "sorted", false, "the reads file and fastq are both sorted by name (LC_ALL=C), so stream the reads file instead of loading it")
+	filterFlags.StringVar(&args.SetMode, "set-mode", "exact", fmt.Sprintf("how exact names are held: exact (the names), hash (%d-bit hashes, to save memory on huge lists) or bloom (a Bloom filter, smaller again but with false matches at -bloom-rate)", fqfilter.HashBits))
+	filterFlags.BoolVar(&args.HashSet, "hash-set", false, "same as -set-mode hash")
+	filterFlags.Float64Var(&args.BloomRate, "bloom-rate", fqfilter.DefaultBloomRate, "with -set-mode bloom, the rate at which names not in the list falsely match")
+	filterFlags.Var(&args.Names, "name", "a read name to match, in addition to any -reads files (may be repeated)")
+	filterFlags.Var(&args.NameRegexps, "name-regex", "only select reads whose whole header matches this regular expression, such as :2108: for a tile, as well as being in any -reads files (may be repeated, to select reads matching any)")
+	filterFlags.StringVar(&args.ReadsFormat, "reads-format", "auto", "what the -reads files hold: names (one per line), fastq, fasta, sam or bam, or auto to go by each file's suffix (.fq, .fastq, .fa, .fasta, .fna, .sam or .bam, otherwise names); or index for the numbers of the reads to select, one per line, counting from 1")
+	filterFlags.StringVar(&args.SetOp, "set-op", "union", "how several -reads files combine: union (a name in any), intersect (in all) or subtract (in the first but none of the others)")
+	filterFlags.Var(&args.ReadsBAM, "reads-bam", "BAM or SAM file whose read names to match (may be repeated)")
+	filterFlags.Var(&args.ReadsBAM, "bam", "same as -reads-bam")
+	filterFlags.BoolVar(&args.BamPrimary, "bam-primary", false, "with SAM or BAM reads files, only use primary alignments")
+	filterFlags.IntVar(&args.BamRequire, "bam-flags", 0, "with SAM or BAM reads files, only use alignments with all these FLAG bits set")
+	filterFlags.IntVar(&args.BamExclude, "bam-exclude", 0, "with SAM or BAM reads files, skip alignments with any of these FLAG bits set")
+	filterFlags.BoolVar(&args.BamMapped, "bam-mapped", false, "with SAM or BAM reads files, only use mapped alignments (same as -bam-exclude 4)")
+	filterFlags.BoolVar(&args.BamUnmapped, "bam-unmapped", false, "with SAM or BAM reads files, only use unmapped reads (same as -bam-flags 4)")
+	filterFlags.BoolVar(&args.BamProperPair, "bam-proper-pair", false, "with SAM or BAM reads files, only use alignments mapped in a proper pair (same as -bam-flags 2)")
+	filterFlags.Var(&args.ReadsFilenames, "reads", "file of read names to match, or of reads or alignments whose names to match (may be repeated, see -reads-format)")
+	filterFlags.StringVar(&args.OutPrefix, "out", "", "output filename prefix (default = stdout)")
+	filterFlags.StringVar(&args.Rename, "rename", "", "write the selected reads under new names from this template, as in sample1_{n}, where {n} counts them from 1 (keeping each mate's /1 or /2, but dropping any comment)")
+	filterFlags.IntVar(&args.ChunkSize, "chunk-size", 0, "split the -out, -rejected and -singletons files into numbered chunks of this many reads, as <out>_1.chunk0001.fq.gz, for aligning in parallel")
+	filterFlags.StringVar(&args.OutTemplate, "out-template", "", "name the -out, -rejected and -singletons files by this template, as in {prefix}_R{mate}.filtered.fastq.gz, where {prefix} is the prefix, {mate} the mate's number and {tag} its R1 or I1 name (compression follows the suffix)")
+	filterFlags.IntVar(&args.GzipLevel, "gzip-level", gzip.DefaultCompression, "gzip compression level for output files (1-9, or 0 for uncompressed output)")
+	filterFlags.BoolVar(&args.Zstd, "zstd", false, "compress -out and -rejected files with Zstandard, as .zst, rather than gzip")
+	filterFlags.IntVar(&args.ZstdLevel, "zstd-level", fqfilter.DefaultZstdLevel, "Zstandard compression level for .zst output files (1-22)")
+	filterFlags.StringVar(&args.OutCompress, "out-compress", "", "how to compress files named from a prefix: none, gzip, bgzf (gzip in blocks, as bgzip writes, so the files can be indexed by fqfilter index or samtools fqidx) or zstd (default gzip, or as -zstd and -gzip-level say)")
+	filterFlags.BoolVar(&args.NoGzip, "no-gzip", false, "write plain, uncompressed files (same as -out-compress none)")
+	filterFlags.IntVar(&args.CompressLevel, "compress-level", 0, "the compression level for -out-compress or -zstd (gzip 1-9, zstd 1-22), such as 1 for intermediate files or 9 for archives")
+	filterFlags.IntVar(&args.Threads, "threads", 1, "number of goroutines decompressing each compressed input and compressing each compressed output file; above 1, each input is also parsed, and each output written, on a goroutine of its own")
+	addMaxLineFlag(filterFlags)
+	filterFlags.IntVar(&args.BufferSize, "buffer-size", fqfilter.DefaultBufferSize, "bytes of output to buffer for each output file before writing it")
+	filterFlags.StringVar(&args.Out1, "out1", "", "output filename for the first (or only) input, overriding -out (- for stdout, fd://N[/name.gz] for a file descriptor)")
+	filterFlags.StringVar(&args.Out2, "out2", "", "output filename for the second input, overriding -out")
+	filterFlags.StringVar(&args.OutMatched, "out-matched", "", "write the reads whose names matched to files with this prefix (like -out, so after any other filters)")
+	filterFlags.StringVar(&args.OutUnmatched, "out-unmatched", "", "write the reads whose names didn't match to files with this prefix, in the same pass (like -rejected)")
+	filterFlags.StringVar(&args.RejectedPrefix, "rejected", "", "also write reads that are not selected to files with this prefix (with -invert, the reads that are in the file)")
+	filterFlags.StringVar(&args.Singletons, "singletons", "", "with paired inputs, write the mates that pass the length, quality, -max-n, -min-complexity and -filter filters when their partner doesn't to <singletons>_1.fq.gz and so on, as Trimmomatic's unpaired outputs")
+	filterFlags.BoolVar(&args.Progress, "progress", false, "periodically log the reads processed, the throughput in MB/s read and, for input files, the percent done and an ETA to stderr")
+	filterFlags.DurationVar(&args.ProgressInterval, "progress-interval", 10*time.Second, "how often -progress logs")
+	filterFlags.StringVar(&args.StatsJSON, "stats-json", "", "write a JSON summary of the counts to this file")
+	filterFlags.StringVar(&args.SummaryJSON, "summary-json", "", "write a JSON summary of the whole run, with the inputs, outputs, options given, counts, bases written and wall time, to this file")
+	filterFlags.StringVar(&args.QCReport, "qc-report", "", "write the quality and base composition at each position of the reads kept to <qc-report>.qc.tsv, with plots of them in <qc-report>.qc.html")
+	filterFlags.StringVar(&args.Unmatched, "unmatched", "", "write the names from the reads file that matched no read to this file")
+	filterFlags.IntVar(&args.Explain, "explain", 0, "log how the name of each of the first N reads was looked up and whether it was selected")
+	filterFlags.IntVar(&args.ExplainEvery, "explain-every", 0, "also explain every Nth read")
+	filterFlags.BoolVar(&args.AllowEmpty, "allow-empty", false, "don't warn when the reads files hold no names")
+	filterFlags.BoolVar(&args.Quiet, "quiet", false, "don't log the counts to stderr")
+	filterFlags.Var(&args.SeqMatch, "seq-match", "keep only selected reads containing this motif, such as a primer: bases with IUPAC codes (like ACGTN or GGWCC), or else a regular expression (may be repeated, to keep reads containing any)")
+	filterFlags.StringVar(&args.SeqMatchMate, "seq-match-mate", "either", "which mate -seq-match searches: 1, 2 or either")
+	filterFlags.BoolVar(&args.SeqMatchRevComp, "seq-match-revcomp", false, "with -seq-match, also search the reverse complement of each mate")
+	filterFlags.Var(&args.Contains, "contains", "drop selected reads where any mate's sequence contains this subsequence (may be repeated)")
+	filterFlags.BoolVar(&args.ContainsInvert, "contains-invert", false, "with -contains, keep only the reads that do contain one")
+	filterFlags.StringVar(&args.Contaminants, "contaminants", "", "drop selected reads that share k-mers with the sequences in this FASTA file, such as PhiX or adapters")
+	filterFlags.IntVar(&args.ContaminantK, "contaminant-k", 21, "the length of the k-mers -contaminants screens with (at most 32)")
+	filterFlags.IntVar(&args.ContaminantHits, "contaminant-max-hits", 0, "with -contaminants, the most k-mers a read, over all its mates, may share with them and be kept")
+	filterFlags.Var(&args.Plugins, "plugin", "drop selected reads that the filter of this Go plugin, given as file.so or file.so=arg, turns down (may be repeated; see the README)")
+	filterFlags.StringVar(&args.BarcodeWhitelist, "barcode-whitelist", "", "file of cell barcodes, one per line; drop selected reads whose first mate's barcode (see -barcode-pos) isn't within -barcode-mismatch of one")
+	filterFlags.StringVar(&args.BarcodePos, "barcode-pos", "0:16", "with -barcode-whitelist, where the barcode is in the first mate, as start:len (counting from 0)")
+	filterFlags.IntVar(&args.BarcodeMismatch, "barcode-mismatch", 1, "with -barcode-whitelist, the most mismatches allowed (a barcode as close to two whitelisted ones matches neither)")
+	filterFlags.BoolVar(&args.BarcodeCorrect, "barcode-correct", false, "with -barcode-whitelist, add the whitelisted barcode each read matched to its names as _BARCODE, before any UMI")
+	filterFlags.IntVar(&args.UMILen, "umi-len", 0, "clip a UMI of this many bases from the start of -umi-read and add it to every mate's read name as _UMI (as umi_tools does), before the trims")
+	filterFlags.IntVar(&args.UMIRead, "umi-read", 1, "which mate, 1 or 2, starts with the UMI")
+	filterFlags.IntVar(&args.Crop, "crop", 0, "keep just the first N bases of each mate of selected reads, before -headcrop and the other trims and filters")
+	filterFlags.IntVar(&args.Crop1, "crop1", 0, "like -crop, for the first mate only (overriding -crop)")
+	filterFlags.IntVar(&args.Crop2, "crop2", 0, "like -crop, for the second mate only (overriding -crop)")
+	filterFlags.IntVar(&args.HeadCrop, "headcrop", 0, "remove the first N bases of each mate of selected reads, after -crop")
+	filterFlags.IntVar(&args.HeadCrop1, "headcrop1", 0, "like -headcrop, for the first mate only (overriding -headcrop)")
+	filterFlags.IntVar(&args.HeadCrop2, "headcrop2", 0, "like -headcrop, for the second mate only (overriding -headcrop)")
+	filterFlags.Var(&args.Adapters, "adapter", "trim this 3' adapter, or as much of its start as runs off the end, from each mate of selected reads, before the other filters (may be repeated)")
+	filterFlags.Float64Var(&args.AdapterErrorRate, "adapter-error-rate", fqfilter.DefaultAdapterErrorRate, "the fraction of an adapter's aligned bases that may mismatch")
+	filterFlags.IntVar(&args.AdapterOverlap, "adapter-min-overlap", fqfilter.DefaultAdapterMinOverlap, "only trim an adapter that overlaps the read by at least this many bases")
+	filterFlags.Float64Var(&args.TrimQual, "trim-qual", 0, "cut each mate of selected reads at the first -trim-window whose mean quality is below this, after -adapter (add -min-length to drop reads left too short)")
+	filterFlags.IntVar(&args.TrimWindow, "trim-window", fqfilter.DefaultTrimWindow, "the number of bases -trim-qual averages over")
+	filterFlags.StringVar(&args.TrimPolyX, "trim-polyx", "", "cut homopolymer tails of these comma-separated bases, such as A for poly-A or G for the poly-G of two-color chemistry, from the 3' end of each mate of selected reads, after -adapter")
+	filterFlags.IntVar(&args.TrimPolyXMin, "trim-polyx-min", fqfilter.DefaultPolyXMinLen, "the shortest tail -trim-polyx cuts")
+	filterFlags.IntVar(&args.Trim, "trim", 0, "trim the sequence and quality of written reads to at most N bases")
+	filterFlags.StringVar(&args.RevComp, "revcomp", "", "reverse complement the sequence, and reverse the quality, of these mates of written reads: 1, 2, 1,2 or all")
+	filterFlags.IntVar(&args.MinLen, "min-length", 0, "drop selected reads with a mate shorter than this (see -pair-policy)")
+	filterFlags.IntVar(&args.MinLen, "min-len", 0, "same as -min-length")
+	filterFlags.IntVar(&args.MaxLen, "max-length", 0, "drop selected reads with a mate longer than this (see -pair-policy)")
+	filterFlags.IntVar(&args.MaxLen, "max-len", 0, "same as -max-length")
+	filterFlags.Float64Var(&args.MinMeanQual, "min-mean-qual", 0, "drop selected reads whose mean base quality is below this")
+	filterFlags.IntVar(&args.MinBaseQual, "min-base-qual", 0, "bases with a quality below this are low quality (see -max-low-qual-frac)")
+	filterFlags.Float64Var(&args.MaxLowQualFrac, "max-low-qual-frac", 0, "with -min-base-qual, drop selected reads where more than this fraction of the bases are low quality")
+	filterFlags.IntVar(&args.QualOffset, "qual-offset", fqfilter.DefaultQualOffset, "the ASCII offset of quality scores (33, or 64 for old Illumina files)")
+	filterFlags.IntVar(&args.ConvertQual, "convert-qual", 0, "rewrite qualities from -qual-offset (64 unless given) to this offset, such as 33 to bring old Illumina files up to date; filters and trimming then work on the new scores")
+	filterFlags.Float64Var(&args.MaxN, "max-n", -1, "drop selected reads with more than this many N bases in a mate, or below 1, more than this fraction of its bases (-1 for no limit)")
+	filterFlags.Float64Var(&args.MinComplexity, "min-complexity", 0, "drop selected reads with a mate less complex than this, from 0 (a homopolymer) to 1 (trinucleotides all equally common); 0.5 drops most repeats")
+	filterFlags.Float64Var(&args.MinGC, "min-gc", 0, "drop selected reads whose GC content is below this percentage (over all the mates together)")
+	filterFlags.Float64Var(&args.MaxGC, "max-gc", 0, "drop selected reads whose GC content is above this percentage (over all the mates together)")
+	filterFlags.StringVar(&args.FilterExpr, "filter", "", "drop selected reads with a mate that fails this condition, such as 'len >= 50 && meanq >= 30 && gc < 0.6', with the variables len, meanq, gc, ncount, name, header, seq and qual (see -pair-policy)")
+	filterFlags.StringVar(&args.PairPolicy, "pair-policy", "both", "with paired inputs, whether both mates or either mate must pass the length, quality, -max-n, -min-complexity and -filter filters to keep the read")
+	filterFlags.BoolVar(&args.Dedup, "dedup", false, "drop selected reads that are duplicates of one already written, keeping the first (the keys are held in memory, hashed unless -set-mode is exact)")
+	filterFlags.StringVar(&args.DedupBy, "dedup-by", "name", "with -dedup, what makes reads duplicates: the same name, seq for the same sequence in every mate, or umi for the same UMI (the end of the name, after _) and start of each mate, keeping the best quality read of each group")
+	filterFlags.IntVar(&args.DedupStartLen, "dedup-start-len", 20, "with -dedup-by umi, how many bases at the start of each mate must match (0 for the whole sequence)")
+	filterFlags.Float64Var(&args.Fraction, "sample-fraction", 0, "keep each selected read, with its mates, with probability F (applied before -limit; without a name list, samples all the reads)")
+	filterFlags.Float64Var(&args.Fraction, "fraction", 0, "same as -sample-fraction")
+	filterFlags.IntVar(&args.Sample, "sample-n", 0, "keep a random sample of exactly N selected reads, with their mates (held in memory; without a name list, samples all the reads)")
+	filterFlags.IntVar(&args.Sample, "sample", 0, "same as -sample-n")
+	filterFlags.IntVar(&args.Every, "every", 0, "keep every Nth selected read, with its mates, as a quick downsample that needs no random numbers")
+	filterFlags.IntVar(&args.EveryOffset, "every-offset", 0, "with -every, start from this selected read, counting from 0 (below -every)")
+	filterFlags.Int64Var(&args.Seed, "seed", 0, "random seed for -sample-fraction and -sample-n, so a sample can be repeated")
+	filterFlags.IntVar(&args.Limit, "limit", 0, "stop once LIMIT reads have been included, each written with all its mates")
+	filterFlags.IntVar(&args.LimitExcluded, "limit-excluded", 0, "stop once this many reads have been excluded (not selected by name)")
+	filterFlags.IntVar(&args.LimitPerOutput, "limit-per-output", 0, "include at most this many reads in each output: in each lane's with -per-lane, moving on to the next lane, or else in each chunk of -chunk-size, passing over the rest of the chunk")
+	filterFlags.IntVar(&args.Skip, "skip", 0, "pass over the first N reads of the inputs, before any other filter")
+	filterFlags.IntVar(&args.Take, "take", 0, "stop after the next N reads of the inputs (after -skip), whether they are selected or not")
+	filterFlags.IntVar(&args.Take, "limit-records", 0, "same as -take")
+	filterFlags.StringVar(&args.Range, "range", "", "only consider reads START to END of the inputs, as START:END counting from 0 and leaving out END (same as -skip START -take END-START)")
+
+	filterFlags.Var(&args.Pairs, "pair", "filter this lane's comma-separated inputs, as L001_R1.fq.gz,L001_R2.fq.gz, in place of the arguments (may be repeated, to filter several lanes against the names loaded once)")
+	filterFlags.BoolVar(&args.PerLane, "per-lane", false, "with -pair, write each lane to its own outputs, named <out>_L001 and so on after the lane in the first input's filename (or {lane} in -out-template), rather than merging them")
+	filterFlags.BoolVar(&args.FailIfEmpty, "fail-if-empty", false, "exit with status 4 if no reads were included, so a workflow can tell an empty result from success")
+	filterFlags.StringVar(&args.Config, "config", "", "take options from this recipe file: key = value settings, then a [[step]] for each operation, in the order filter runs them (see the README); options given on the command line win")
+	addForceFlag(filterFlags)
+	addLogFlags(filterFlags)
+
+	filterFlags.Usage = func() {
+		log.Println("usage: fqfilter [filter] [options] unaligned_1.fq.gz [unaligned_2.fq.gz ...]")
+		filterFlags.PrintDefaults()
+		log.Println()
+		printCommands()
+	}
+}
+
+/* How names are normalized, from -short-name, -strip-mate (or
+ * -strip-mate-suffix), -ignore-case and -regexp */
+func normalizer() fqfilter.Normalizer {
+	return fqfilter.Normalizer{
+		ShortName:    args.ShortName,
+		StripMate:    args.StripMate || args.StripMateSuffix,
+		StripMateDot: args.StripMateSuffix,
+		IgnoreCase:   args.IgnoreCase,
+		Regexp:       args.Regexp,
+	}
+}
+
+/* A new, empty set of the kind asked for by -prefix, -regexp and -set-mode */
+func newNameSet() fqfilter.NameSet {
+	if args.Prefix {
+		return &fqfilter.PrefixSet{}
+	} else if args.Regexp {
+		return &fqfilter.RegexpSet{IgnoreCase: args.IgnoreCase}
+	} else if args.SetMode == "hash" {
+		return &fqfilter.HashSet{}
+	} else if args.SetMode == "bloom" {
+		return &fqfilter.BloomSet{Rate: args.BloomRate}
+	}
+	return make(fqfilter.ExactSet)
+}
+
+var setOps = map[string]fqfilter.SetOp{
+	"union":     fqfilter.SetUnion,
+	"intersect": fqfilter.SetIntersect,
+	"subtract":  fqfilter.SetSubtract,
+}
+
+var dedupKeys = map[string]fqfilter.DedupKey{
+	"name": fqfilter.DedupName,
+	"seq":  fqfilter.DedupSequence,
+}
+
+var pairPolicies = map[string]fqfilter.PairPolicy{
+	"both":   fqfilter.PairBoth,
+	"either": fqfilter.PairEither,
+}
+
+/* The format of a -reads file, from -reads-format or its suffix */
+func readsFormat(fn string) fqfilter.ReadsFormat {
+	if args.ReadsFormat == "auto" {
+		return fqfilter.DetectReadsFormat(fn)
+	}
+	return fqfilter.ReadsFormat(args.ReadsFormat)
+}
+
+/* The layout and compression settings for output files, from the command line */
+func outputOptions() fqfilter.OutputOptions {
+	opts := fqfilter.OutputOptions{
+		Zstd: args.Zstd,
+		WriteOptions: safeWrites(fqfilter.WriteOptions{
+			Level:      args.GzipLevel,
+			ZstdLevel:  args.ZstdLevel,
+			Threads:    args.Threads,
+			BufferSize: args.BufferSize,
+			BGZF:       args.BGZF,
+		}),
+	}
+	opts.Template = args.OutTemplate
+	opts.ChunkSize = args.ChunkSize
+	// With threads to spare, each output is laid out and written on its own
+	// goroutine, while this one filters
+	if args.Threads > 1 {
+		opts.WriteBehind = writeBehindBatch
+	}
+	if args.OutFormat == "ubam" {
+		opts.Format = fqfilter.FormatBAM
+		opts.ReadGroup = args.ReadGroup
+		opts.ReadGroupSample = args.ReadGroupSample
+		opts.QualOffset = args.QualOffset
+	} else if args.Tab {
+		opts.Format = fqfilter.FormatTab
+		opts.TabQuality = args.TabQual
+		opts.TabHeader = args.TabHeader
+	} else if args.Fasta {
+		opts.Format = fqfilter.FormatFasta
+		opts.FastaWidth = args.FastaWidth
+	}
+	return opts
+}
+
+/* Whether any of the -crop or -headcrop options are given */
+func cropping() bool {
+	return max(args.Crop, args.Crop1, args.Crop2, args.HeadCrop, args.HeadCrop1, args.HeadCrop2) > 0
+}
+
+/* The -crop and -headcrop settings for each mate, where -crop1 and the like
+ * override the setting for all mates */
+func cropTrimmer(mates int) *fqfilter.CropTrimmer {
+	t := &fqfilter.CropTrimmer{Crop: make([]int, mates), HeadCrop: make([]int, mates)}
+	for i := 0; i < mates; i++ {
+		t.Crop[i] = args.Crop
+		t.HeadCrop[i] = args.HeadCrop
+	}
+	for i, n := range []int{args.Crop1, args.Crop2} {
+		if n > 0 && i < mates {
+			t.Crop[i] = n
+		}
+	}
+	for i, n := range []int{args.HeadCrop1, args.HeadCrop2} {
+		if n > 0 && i < mates {
+			t.HeadCrop[i] = n
+		}
+	}
+	return t
+}
+
+/* The mates -revcomp picks, by number or all of them */
+func revcompMates(spec string, mates int) []bool {
+	picked := make([]bool, mates)
+	if spec == "all" {
+		for i := range picked {
+			picked[i] = true
+		}
+		return picked
+	}
+	for _, field := range strings.Split(spec, ",") {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 1 {
+			usageFatalf("Invalid -revcomp %s: it should be mate numbers, such as 2 or 1,2, or all\n", spec)
+		}
+		if n > mates {
+			usageFatalf("-revcomp %d needs a mate %d, but there are %d\n", n, n, mates)
+		}
+		picked[n-1] = true
+	}
+	return picked
+}
+
+/* Whether to log the match decision for the nth read: the first -explain
+ * reads and then, with -explain-every, every so many after that */
+func explain(n int) bool {
+	if n <= args.Explain {
+		return true
+	}
+	return args.ExplainEvery > 0 && n%args.ExplainEvery == 0
+}
+
+/* Warn, returning true, once the qualities read by the nth read show that
+ * they aren't encoded with the offset given, or mix encodings */
+func checkEncoding(ranges []fqfilter.QualityRange, offset, n int) bool {
+	which := "the qualities"
+	other := 0
+	for i := range ranges {
+		if len(ranges) > 1 {
+			which = fmt.Sprintf("the qualities of mate %d", i+1)
+		}
+		if ranges[i].Mixed() {
+			warnf("by read %d, %s seem to mix Phred+33 and Phred+64\n", n, which)
+			return true
+		}
+		guess, sure := ranges[i].Offset()
+		if !sure {
+			continue
+		}
+		if other != 0 && guess != other {
+			warnf("by read %d, the mates' qualities seem to be encoded differently, as Phred+33 and Phred+64\n", n)
+			return true
+		}
+		other = guess
+		if guess != offset {
+			warnf("by read %d, %s look like Phred+%d, but are being read as Phred+%d (see -qual-offset and -convert-qual)\n", n, which, guess, offset)
+			return true
+		}
+	}
+	return false
+}
+
+/* The summary written by -stats-json. Base counts and the mean length cover
+ * the sequences of all mates, before any -trim. */
+type RunStats struct {
+	Included             int     `json:"included"`
+	Excluded             int     `json:"excluded"`
+	LengthFiltered       int     `json:"length_filtered"`
+	QualityFiltered      int     `json:"quality_filtered"`
+	NFiltered            int     `json:"n_filtered"`
+	ComplexityFiltered   int     `json:"complexity_filtered"`
+	GCFiltered           int     `json:"gc_filtered"`
+	ExpressionFiltered   int     `json:"expression_filtered"`
+	MotifFiltered        int     `json:"motif_filtered"`
+	ContaminantFiltered  int     `json:"contaminant_filtered"`
+	PluginFiltered       int     `json:"plugin_filtered"`
+	AdapterTrimmed       int     `json:"adapter_trimmed"`
+	QualityTrimmed       int     `json:"quality_trimmed"`
+	PolyXTrimmed         int     `json:"polyx_trimmed"`
+	PolyXBasesTrimmed    int     `json:"polyx_bases_trimmed"`
+	ContentFiltered      int     `json:"content_filtered"`
+	BarcodeFiltered      int     `json:"barcode_filtered"`
+	SampledOut           int     `json:"sampled_out"`
+	Duplicates           int     `json:"duplicates"`
+	OverLimit            int     `json:"over_limit"`
+	Singletons           int     `json:"singletons"`
+	Skipped              int     `json:"skipped"`
+	Total                int     `json:"total"`
+	BasesIncluded        int     `json:"total_bases_included"`
+	BasesExcluded        int     `json:"total_bases_excluded"`
+	MeanLength           float64 `json:"mean_length"`
+	ReadsInFilter        int     `json:"reads_in_filter"`
+	ReadsInFilterMatched int     `json:"reads_in_filter_matched"`
+	ElapsedMs            int64   `json:"elapsed_ms"`
+}
+
+/* Write the filter entries that never matched a read, one per line */
+func writeUnmatched(fn string, filter fqfilter.NameSet) error {
+	names, err := filter.Unmatched()
+	if err != nil {
+		return err
+	}
+	w := fqfilter.AmbiWriter{}
+	if err := w.Open(fn); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, err := io.WriteString(w, name+"\n"); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
+
+/* The summary written by -summary-json, for workflow managers to pick up */
+type RunSummary struct {
+	Inputs         []string          `json:"inputs"`
+	Outputs        []string          `json:"outputs"`
+	Rejected       []string          `json:"rejected,omitempty"`
+	Singletons     []string          `json:"singletons,omitempty"`
+	Options        map[string]string `json:"options"`
+	Counts         RunStats          `json:"counts"`
+	RecordsWritten int               `json:"records_written"`
+	BasesWritten   int               `json:"bases_written"`
+	Started        time.Time         `json:"started"`
+	WallSeconds    float64           `json:"wall_seconds"`
+}
+
+/* The files an output wrote, with stdout as - */
+func outputFilenames(outputs []*fqfilter.Output) []string {
+	var names []string
+	for _, o := range outputs {
+		for _, fn := range o.Filenames() {
+			if fn == "" {
+				fn = "-"
+			}
+			names = append(names, fn)
+		}
+	}
+	return names
+}
+
+/* The sets of inputs to filter one after another: the arguments, or with
+ * -pair, the comma-separated files of each lane */
+func inputLanes(fq []string) [][]string {
+	if len(args.Pairs) == 0 && slices.ContainsFunc(fq, isPattern) {
+		return patternLanes(fq)
+	}
+	if len(args.Pairs) == 0 {
+		return [][]string{fq}
+	}
+	if len(fq) > 0 {
+		fatal("Give the inputs either as arguments or with -pair, not both")
+	}
+	var lanes [][]string
+	for _, pair := range args.Pairs {
+		lane := strings.Split(pair, ",")
+		if len(lanes) > 0 && len(lane) != len(lanes[0]) {
+			fatalf("-pair %s has %d inputs, but the first -pair has %d\n", pair, len(lane), len(lanes[0]))
+		}
+		for _, fn := range lane {
+			if fn == "" || fn == "-" {
+				fatalf("-pair %s must name files, not stdin\n", pair)
+			}
+		}
+		lanes = append(lanes, lane)
+	}
+	return lanes
+}
+
+/* Whether an input is a directory or a glob, standing for the files in it
+ * or matching it, rather than a file of its own */
+func isPattern(fn string) bool {
+	info, err := os.Stat(fn)
+	if err == nil {
+		return info.IsDir()
+	}
+	return strings.ContainsAny(fn, "*?[")
+}
+
+/* The reads files in a directory, in order */
+func readsInDir(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		fatal(err)
+	}
+	var fns []string
+	for _, e := range entries {
+		fn := filepath.Join(dir, e.Name())
+		if format := fqfilter.DetectReadsFormat(fn); !e.IsDir() && (format == fqfilter.ReadsFastq || format == fqfilter.ReadsFasta) {
+			fns = append(fns, fn)
+		}
+	}
+	if len(fns) == 0 {
+		usageFatalf("%s holds no FASTQ or FASTA files\n", dir)
+	}
+	return fns
+}
+
+/* The lanes of inputs given with globs or directories. One glob or directory
+ * is split into lanes of the files that differ only in their R1 and R2 (or
+ * _1 and _2) tags. With several, each gives one mate of every lane: the nth
+ * lane is the nth file of each, in sorted order. */
+func patternLanes(patterns []string) [][]string {
+	lists := make([][]string, len(patterns))
+	for i, p := range patterns {
+		if info, err := os.Stat(p); err == nil && info.IsDir() {
+			lists[i] = readsInDir(p)
+			continue
+		} else if err == nil {
+			lists[i] = []string{p}
+			continue
+		}
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			usageFatalf("Invalid pattern %s: %v\n", p, err)
+		}
+		if len(matches) == 0 {
+			usageFatalf("%s matches no files\n", p)
+		}
+		lists[i] = matches
+	}
+	var lanes [][]string
+	if len(lists) == 1 {
+		var err error
+		if lanes, err = fqfilter.GroupMateFiles(lists[0]); err != nil {
+			usageFatal(err)
+		}
+	} else {
+		for i, list := range lists {
+			if len(list) != len(lists[0]) {
+				usageFatalf("%s matches %d files, but %s matches %d\n", patterns[i], len(list), patterns[0], len(lists[0]))
+			}
+		}
+		for j := range lists[0] {
+			lane := make([]string, len(lists))
+			for i := range lists {
+				lane[i] = lists[i][j]
+				if i > 0 && !fqfilter.SameLane(lane[0], lane[i]) {
+					usageFatalf("%s and %s don't look like mates of one lane; check that the patterns match the files in the same order\n", lane[0], lane[i])
+				}
+			}
+			lanes = append(lanes, lane)
+		}
+	}
+	if !args.Quiet {
+		for _, lane := range lanes {
+			log.Println("input:", strings.Join(lane, ","))
+		}
+	}
+	return lanes
+}
+
+/* The L001 and the like in an Illumina style filename */
+var laneTag = regexp.MustCompile(`[_.](L\d{3})(?:[_.]|$)`)
+
+/* Name each lane by the tag in its first input's filename, or if they don't
+ * all have a different one, as lane1, lane2 and so on */
+func laneNames(lanes [][]string) []string {
+	names := make([]string, len(lanes))
+	seen := make(map[string]bool)
+	for i, lane := range lanes {
+		m := laneTag.FindStringSubmatch(filepath.Base(lane[0]))
+		if m == nil || seen[m[1]] {
+			for j := range names {
+				names[j] = fmt.Sprintf("lane%d", j+1)
+			}
+			return names
+		}
+		seen[m[1]] = true
+		names[i] = m[1]
+	}
+	return names
+}
+
+func writeJSON(fn string, v interface{}) error {
+	fp, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(fp)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
+func runFilter(argv []string) {
+	filterWith(argv, nil)
+}
+
+/* Filter, first calling check, if there is one, once the options are read */
+func filterWith(argv []string, check func()) {
+	start := time.Now()
+	filterFlags.Parse(argv)
+	given := make(map[string]bool)
+	filterFlags.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	fns := filterFlags.Args()
+	if args.Config != "" {
+		fns = applyRecipe(args.Config, given, fns)
+		filterFlags.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	}
+	if check != nil {
+		check()
+	}
+	lanes := inputLanes(fns)
+	fq := lanes[0]
+
+	// FASTA inputs have no qualities, so are written as FASTA unless asked
+	fasta := false
+	for i, fn := range slices.Concat(lanes...) {
+		isFasta := args.InFormat == "fasta" || args.InFormat == "auto" && fqfilter.DetectReadsFormat(fn) == fqfilter.ReadsFasta
+		if i > 0 && isFasta != fasta {
+			usageFatal("Inputs must be all FASTQ or all FASTA")
+		}
+		fasta = isFasta
+	}
+	if args.InFormat != "auto" && args.InFormat != "fastq" && args.InFormat != "fasta" {
+		usageFatal("-in-format must be auto, fastq or fasta")
+	}
+
+	// -fasta and -tab are shorthands for -out-format
+	if args.Fasta && args.Tab {
+		usageFatal("Cannot combine -fasta with -tab")
+	}
+	if args.Fasta || args.Tab {
+		short := "fasta"
+		if args.Tab {
+			short = "tab"
+		}
+		if args.OutFormat != "fastq" && args.OutFormat != short {
+			usageFatalf("Cannot combine -%s with -out-format %s\n", short, args.OutFormat)
+		}
+		args.OutFormat = short
+	}
+	if args.OutFormat != "fastq" && args.OutFormat != "fasta" && args.OutFormat != "tab" && args.OutFormat != "ubam" {
+		usageFatal("-out-format must be fastq, fasta, tab or ubam")
+	}
+	if fasta && !given["out-format"] && !args.Tab {
+		args.OutFormat = "fasta"
+	}
+	if fasta && args.OutFormat == "fastq" {
+		usageFatal("FASTA input has no qualities to write as FASTQ")
+	}
+	args.Fasta = args.OutFormat == "fasta"
+	args.Tab = args.OutFormat == "tab"
+	if (args.ReadGroup != "" || args.ReadGroupSample != "") && args.OutFormat != "ubam" {
+		usageFatal("-rg and -rg-sample need -out-format ubam")
+	}
+	if args.ReadGroupSample != "" && args.ReadGroup == "" {
+		usageFatal("-rg-sample needs -rg")
+	}
+	if args.ReadGroupSample == "" {
+		args.ReadGroupSample = args.ReadGroup
+	}
+	if args.OutFormat == "ubam" && (len(fq) > 2 || args.OutInterleaved || args.Deinterleave || args.Out1 != "") {
+		usageFatal("-out-format ubam takes one or two mates, and can't be combined with -out-interleaved, -deinterleave or -out1")
+	}
+	if (args.TabQual || args.TabHeader) && !args.Tab {
+		usageFatal("-tab-qual and -tab-header need -tab")
+	}
+	if args.TabQual && fasta {
+		usageFatal("FASTA input has no qualities for -tab-qual")
+	}
+
+	if args.FastaWidth < 0 {
+		usageFatal("-fasta-width must not be negative")
+	}
+
+	// The matched and unmatched partitions are -out and -rejected without -invert
+	if args.OutMatched != "" || args.OutUnmatched != "" {
+		if args.OutPrefix != "" || args.RejectedPrefix != "" || args.Invert {
+			usageFatal("-out-matched and -out-unmatched can't be combined with -out, -rejected or -invert")
+		}
+		args.OutPrefix = args.OutMatched
+		args.RejectedPrefix = args.OutUnmatched
+	}
+
+	if args.MinMeanQual < 0 || args.MinBaseQual < 0 {
+		usageFatal("-min-mean-qual and -min-base-qual must not be negative")
+	}
+
+	if args.MaxLowQualFrac < 0 || args.MaxLowQualFrac > 1 {
+		usageFatal("-max-low-qual-frac must be between 0 and 1")
+	}
+
+	if args.QualOffset <= 0 {
+		usageFatal("-qual-offset must be positive")
+	}
+	// Qualities are converted as they are read, so everything after works
+	// with the new offset
+	var converter *fqfilter.QualityConverter
+	if args.ConvertQual != 0 {
+		if args.ConvertQual != 33 && args.ConvertQual != 64 {
+			usageFatal("-convert-qual must be 33 or 64")
+		}
+		if fasta {
+			usageFatal("FASTA input has no qualities to convert")
+		}
+		if !given["qual-offset"] {
+			args.QualOffset = 33 + 64 - args.ConvertQual
+		}
+		if args.QualOffset != args.ConvertQual {
+			converter = &fqfilter.QualityConverter{From: args.QualOffset, To: args.ConvertQual}
+		}
+	}
+	inputOffset := args.QualOffset
+	if converter != nil {
+		args.QualOffset = args.ConvertQual
+	}
+
+	pairPolicy, ok := pairPolicies[args.PairPolicy]
+	if !ok {
+		usageFatal("-pair-policy must be both or either")
+	}
+
+	quality := fqfilter.QualityFilter{
+		Offset:     args.QualOffset,
+		MinMean:    args.MinMeanQual,
+		MinBase:    args.MinBaseQual,
+		MaxLowFrac: args.MaxLowQualFrac,
+	}
+	if fasta && (quality.Enabled() || args.TrimQual > 0) {
+		usageFatal("FASTA input has no qualities, so can't be quality filtered or trimmed")
+	}
+
+	if args.MaxN < 0 && args.MaxN != -1 {
+		usageFatal("-max-n must not be negative, apart from -1 for no limit")
+	}
+
+	if args.MinComplexity < 0 || args.MinComplexity > 1 {
+		usageFatal("-min-complexity must be between 0 and 1")
+	}
+
+	if args.MinGC < 0 || args.MinGC > 100 || args.MaxGC < 0 || args.MaxGC > 100 {
+		usageFatal("-min-gc and -max-gc must be percentages, between 0 and 100")
+	}
+
+	if args.MaxGC > 0 && args.MaxGC < args.MinGC {
+		usageFatal("-max-gc must be at least -min-gc")
+	}
+
+	dedupBy, ok := dedupKeys[args.DedupBy]
+	collapseUMIs := args.Dedup && args.DedupBy == "umi"
+	if !ok && args.DedupBy != "umi" {
+		usageFatal("-dedup-by must be name, seq or umi")
+	}
+
+	if collapseUMIs && args.Sample > 0 {
+		usageFatal("-dedup-by umi holds reads back, so can't be combined with -sample-n")
+	}
+
+	if args.DedupStartLen < 0 {
+		usageFatal("-dedup-start-len must not be negative")
+	}
+
+	// Without any names to match, every read is selected, which only makes
+	// sense when sampling, trimming or filtering on content
+	byName := len(args.ReadsFilenames) > 0 || len(args.Names) > 0 || len(args.ReadsBAM) > 0
+	filtering := len(args.NameRegexps) > 0 || args.FilterExpr != "" || len(args.SeqMatch) > 0 || args.Fraction > 0 || args.Sample > 0 || args.MinLen > 0 || args.MaxLen > 0 || quality.Enabled() || args.MaxN >= 0 || args.MinComplexity > 0 || args.MinGC > 0 || args.MaxGC > 0 || len(args.Adapters) > 0 || args.TrimQual > 0 || args.TrimPolyX != "" || cropping() || args.UMILen > 0 || args.Dedup || args.BarcodeWhitelist != "" || args.Skip > 0 || args.Take > 0 || args.Range != "" || args.Every > 0 || args.ConvertQual != 0 || args.RevComp != "" || args.Contaminants != "" || len(args.Plugins) > 0
+	if !byName && !filtering {
+		usageFatal("Must provide -reads <file>, -reads-bam <file>, -name <read> or -name-regex <re> argument, or an option that samples, trims or filters all the reads (such as -sample-fraction, -adapter, -min-length or -dedup)")
+	}
+
+	if !byName && args.Invert && len(args.NameRegexps) == 0 {
+		usageFatal("-invert needs -reads, -reads-bam, -name or -name-regex")
+	}
+
+	if !byName && (args.Unmatched != "" || args.Sorted) {
+		usageFatal("-unmatched and -sorted need -reads, -reads-bam or -name")
+	}
+
+	var expr *fqfilter.Expr
+	if args.FilterExpr != "" {
+		var err error
+		if expr, err = fqfilter.CompileExpr(args.FilterExpr); err != nil {
+			usageFatalf("Invalid -filter: %v\n", err)
+		}
+		expr.Offset = args.QualOffset
+	}
+
+	var headerRegexps []*regexp.Regexp
+	for _, expr := range args.NameRegexps {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			usageFatalf("Invalid -name-regex %s: %v\n", expr, err)
+		}
+		headerRegexps = append(headerRegexps, re)
+	}
+
+	if len(fq) == 0 {
+		usageFatal("Must specify at least one fastq file")
+	}
+
+	// -match-mode names -prefix and -ignore-case the other way
+	if given["match-mode"] && (given["prefix"] || given["ignore-case"]) {
+		usageFatal("Cannot combine -match-mode with -prefix or -ignore-case")
+	}
+	switch args.MatchMode {
+	case "exact":
+	case "prefix":
+		args.Prefix = true
+	case "ci":
+		args.IgnoreCase = true
+	default:
+		usageFatal("-match-mode must be exact, prefix or ci")
+	}
+
+	if args.Prefix && args.Regexp {
+		usageFatal("Cannot combine -prefix with -regexp")
+	}
+
+	if args.HashSet {
+		if args.SetMode != "exact" && args.SetMode != "hash" {
+			usageFatalf("Cannot combine -hash-set with -set-mode %s\n", args.SetMode)
+		}
+		args.SetMode = "hash"
+	}
+
+	if args.SetMode != "exact" && args.SetMode != "hash" && args.SetMode != "bloom" {
+		usageFatal("-set-mode must be exact, hash or bloom")
+	}
+
+	if args.SetMode != "exact" && (args.Prefix || args.Regexp) {
+		usageFatalf("-set-mode %s only supports exact matching\n", args.SetMode)
+	}
+
+	if args.BloomRate <= 0 || args.BloomRate >= 1 {
+		usageFatal("-bloom-rate must be between 0 and 1")
+	}
+
+	// -out-compress and -compress-level are another way to set -zstd and the
+	// -gzip-level or -zstd-level
+	if args.NoGzip {
+		if args.OutCompress != "" && args.OutCompress != "none" {
+			usageFatalf("Cannot combine -no-gzip with -out-compress %s\n", args.OutCompress)
+		}
+		args.OutCompress = "none"
+	}
+	if args.OutCompress != "" {
+		if given["zstd"] || given["gzip-level"] || given["zstd-level"] {
+			usageFatal("Cannot combine -out-compress with -zstd, -gzip-level or -zstd-level")
+		}
+		switch args.OutCompress {
+		case "none":
+			if given["compress-level"] {
+				usageFatal("-compress-level can't be used with -out-compress none")
+			}
+			args.GzipLevel = 0
+		case "gzip":
+		case "zstd":
+			args.Zstd = true
+		case "bgzf":
+			args.BGZF = true
+		default:
+			usageFatal("-out-compress must be none, gzip, bgzf or zstd")
+		}
+	}
+	if given["compress-level"] {
+		if given["gzip-level"] || given["zstd-level"] {
+			usageFatal("Cannot combine -compress-level with -gzip-level or -zstd-level")
+		}
+		if args.Zstd {
+			if args.CompressLevel < 1 || args.CompressLevel > 22 {
+				usageFatal("-compress-level must be between 1 and 22 for zstd")
+			}
+			args.ZstdLevel = args.CompressLevel
+		} else {
+			if args.CompressLevel < gzip.BestSpeed || args.CompressLevel > gzip.BestCompression {
+				usageFatal("-compress-level must be between 1 and 9 for gzip")
+			}
+			args.GzipLevel = args.CompressLevel
+		}
+	}
+
+	if args.GzipLevel < gzip.HuffmanOnly || args.GzipLevel > gzip.BestCompression {
+		usageFatal("-gzip-level must be between 0 and 9")
+	}
+
+	if args.ZstdLevel < 1 || args.ZstdLevel > 22 {
+		usageFatal("-zstd-level must be between 1 and 22")
+	}
+
+	if args.SetMode != "exact" && args.Unmatched != "" {
+		usageFatalf("Cannot combine -set-mode %s with -unmatched\n", args.SetMode)
+	}
+
+	if args.PerLane && len(lanes) < 2 {
+		usageFatal("-per-lane needs two or more -pair lanes")
+	}
+	if args.PerLane && (args.Out1 != "" || args.Sample > 0 || collapseUMIs) {
+		usageFatal("-per-lane can't be combined with -out1, -sample-n or -dedup-by umi")
+	}
+	if args.PerLane && args.OutTemplate != "" && !strings.Contains(args.OutTemplate, "{lane}") {
+		usageFatal("With -per-lane, -out-template needs a {lane}")
+	}
+	if args.LimitPerOutput > 0 && !args.PerLane && args.ChunkSize == 0 {
+		usageFatal("-limit-per-output needs -per-lane or -chunk-size, for there to be more than one output")
+	}
+	// The sample is only written at the end, when it's too late to pass
+	// over the reads past each chunk's limit
+	if args.LimitPerOutput > 0 && !args.PerLane && args.Sample > 0 {
+		usageFatal("-limit-per-output with -chunk-size can't be combined with -sample-n")
+	}
+	// UMI groups are only written at the end, so nothing is included until
+	// every read has been read
+	if collapseUMIs && (args.Limit > 0 || args.LimitPerOutput > 0) {
+		usageFatal("-limit and -limit-per-output can't be combined with -dedup-by umi")
+	}
+
+	if args.Interleaved && len(fq) != 1 {
+		usageFatal("-interleaved takes a single fastq file")
+	}
+
+	stdinUsers := 0
+	for _, fn := range fq {
+		if fn == "-" {
+			stdinUsers++
+		}
+	}
+	for _, fn := range args.ReadsFilenames {
+		if fn == "stdin" {
+			stdinUsers++
+		}
+	}
+	if stdinUsers > 1 {
+		usageFatal("Only one input or -reads file can be read from stdin")
+	}
+
+	if args.OutInterleaved && (len(fq) < 2 || args.Tab) {
+		usageFatal("-out-interleaved needs two or more inputs, and can't be combined with -tab")
+	}
+
+	if args.Deinterleave && !args.Interleaved {
+		usageFatal("-deinterleave only applies to -interleaved input")
+	}
+
+	if args.Fraction < 0 || args.Fraction > 1 {
+		usageFatal("-sample-fraction must be between 0 and 1")
+	}
+
+	if args.Range != "" {
+		if args.Skip > 0 || args.Take > 0 {
+			usageFatal("Cannot combine -range with -skip or -take")
+		}
+		var end int
+		if n, err := fmt.Sscanf(args.Range, "%d:%d", &args.Skip, &end); n != 2 || err != nil || args.Skip < 0 || end <= args.Skip {
+			usageFatalf("Invalid -range %s: it should be START:END with START below END\n", args.Range)
+		}
+		args.Take = end - args.Skip
+	}
+	if args.Skip < 0 || args.Take < 0 {
+		usageFatal("-skip and -take must not be negative")
+	}
+
+	if args.Every < 0 || args.EveryOffset < 0 || args.EveryOffset > 0 && args.EveryOffset >= args.Every {
+		usageFatal("-every must not be negative, and -every-offset must be below it")
+	}
+
+	if args.Sample > 0 && args.Limit > 0 {
+		usageFatal("Cannot combine -sample-n with -limit")
+	}
+	if args.Limit < 0 || args.LimitExcluded < 0 || args.LimitPerOutput < 0 {
+		usageFatal("-limit, -limit-excluded and -limit-per-output must not be negative")
+	}
+
+	if args.BamUnmapped && (args.BamMapped || args.BamProperPair) {
+		usageFatal("-bam-unmapped can't be combined with -bam-mapped or -bam-proper-pair")
+	}
+
+	if args.Sorted && (len(args.ReadsFilenames) != 1 || len(args.Names) > 0 || len(args.ReadsBAM) > 0) {
+		usageFatal("-sorted needs exactly one -reads file and no -name or -reads-bam")
+	}
+
+	if args.Sorted && (args.Prefix || args.Regexp || args.SetMode != "exact" || args.Unmatched != "") {
+		usageFatal("-sorted only supports exact matching, without -unmatched")
+	}
+
+	switch fqfilter.ReadsFormat(args.ReadsFormat) {
+	case "auto", fqfilter.ReadsNames, fqfilter.ReadsFastq, fqfilter.ReadsFasta, fqfilter.ReadsSAM, fqfilter.ReadsBAM, fqfilter.ReadsIndex:
+	default:
+		usageFatal("-reads-format must be auto, names, fastq, fasta, sam, bam or index")
+	}
+
+	// Record numbers select reads by place, so none of the ways of matching
+	// names apply
+	byIndex := args.ReadsFormat == string(fqfilter.ReadsIndex)
+	if byIndex && (len(args.Names) > 0 || len(args.ReadsBAM) > 0 || args.Sorted || args.SetOp != "union" || args.Unmatched != "") {
+		usageFatal("-reads-format index can't be combined with -name, -reads-bam, -sorted, -set-op or -unmatched")
+	}
+
+	if args.Sorted && readsFormat(args.ReadsFilenames[0]) != fqfilter.ReadsNames {
+		usageFatal("-sorted needs a -reads file of names, one per line")
+	}
+
+	setOp, ok := setOps[args.SetOp]
+	if !ok {
+		usageFatal("-set-op must be union, intersect or subtract")
+	}
+
+	if setOp != fqfilter.SetUnion && (len(args.ReadsFilenames) < 2 || len(args.Names) > 0 || len(args.ReadsBAM) > 0) {
+		usageFatalf("-set-op %s needs at least two -reads files and no -name or -reads-bam\n", args.SetOp)
+	}
+
+	if args.BufferSize <= 0 {
+		usageFatal("-buffer-size must be positive")
+	}
+
+	if args.Progress && args.ProgressInterval <= 0 {
+		usageFatal("-progress-interval must be positive")
+	}
+
+	if args.SeqMatchMate != "1" && args.SeqMatchMate != "2" && args.SeqMatchMate != "either" {
+		usageFatal("-seq-match-mate must be 1, 2 or either")
+	}
+
+	var motif *fqfilter.MotifMatcher
+	if len(args.SeqMatch) > 0 {
+		var err error
+		if motif, err = fqfilter.NewMotifMatcher(args.SeqMatch); err != nil {
+			usageFatalf("Invalid -seq-match: %v\n", err)
+		}
+		motif.RevComp = args.SeqMatchRevComp
+	}
+
+	for _, seq := range args.Contains {
+		if seq == "" {
+			usageFatal("-contains needs a non-empty sequence")
+		}
+	}
+
+	if args.ContainsInvert && len(args.Contains) == 0 {
+		usageFatal("-contains-invert needs -contains")
+	}
+
+	if args.ContaminantK < 1 || args.ContaminantK > fqfilter.MaxKmerLen {
+		usageFatalf("-contaminant-k must be from 1 to %d\n", fqfilter.MaxKmerLen)
+	}
+	if args.ContaminantHits < 0 {
+		usageFatal("-contaminant-max-hits must not be negative")
+	}
+
+	if args.BarcodeMismatch < 0 {
+		usageFatal("-barcode-mismatch must not be negative")
+	}
+
+	if args.UMILen < 0 {
+		usageFatal("-umi-len must not be negative")
+	}
+
+	if args.UMIRead != 1 && args.UMIRead != 2 {
+		usageFatal("-umi-read must be 1 or 2")
+	}
+
+	if min(args.Crop, args.Crop1, args.Crop2, args.HeadCrop, args.HeadCrop1, args.HeadCrop2) < 0 {
+		usageFatal("-crop and -headcrop must not be negative")
+	}
+
+	for _, adapter := range args.Adapters {
+		if adapter == "" {
+			usageFatal("-adapter needs a non-empty sequence")
+		}
+	}
+
+	if args.AdapterErrorRate < 0 || args.AdapterErrorRate >= 1 {
+		usageFatal("-adapter-error-rate must be at least 0 and below 1")
+	}
+
+	if args.AdapterOverlap < 1 {
+		usageFatal("-adapter-min-overlap must be at least 1")
+	}
+
+	if args.TrimQual < 0 {
+		usageFatal("-trim-qual must not be negative")
+	}
+
+	if args.TrimPolyX != "" {
+		for _, base := range strings.Split(args.TrimPolyX, ",") {
+			if len(base) != 1 || !strings.ContainsAny(base, "ACGTNacgtn") {
+				usageFatalf("Invalid -trim-polyx %s: it should be bases separated by commas, such as A,G\n", args.TrimPolyX)
+			}
+		}
+		if args.TrimPolyXMin < 1 {
+			usageFatal("-trim-polyx-min must be at least 1")
+		}
+	}
+
+	if args.TrimWindow < 1 {
+		usageFatal("-trim-window must be at least 1")
+	}
+
+	if args.Trim < 0 {
+		usageFatal("-trim must not be negative")
+	}
+
+	if args.MaxLen > 0 && args.MaxLen < args.MinLen {
+		usageFatal("-max-length must be at least -min-length")
+	}
+
+	if args.CountOnly && (args.OutPrefix != "" || args.RejectedPrefix != "" || args.Singletons != "" || args.Tab || args.Fasta) {
+		usageFatal("-count-only writes no output, so can't be combined with -out, -rejected, -singletons or -out-format")
+	}
+	if args.CountOnly && args.QCReport != "" {
+		usageFatal("-count-only writes no reads, so has none for -qc-report")
+	}
+	if args.CountOnly && args.Quiet {
+		usageFatal("-count-only only logs the counts, so can't be combined with -quiet")
+	}
+
+	if args.OutTemplate != "" {
+		if args.OutPrefix == "" && args.RejectedPrefix == "" && args.Singletons == "" {
+			usageFatal("-out-template needs -out, -rejected or -singletons")
+		}
+		if args.Out1 != "" {
+			usageFatal("-out-template can't be combined with -out1 and -out2")
+		}
+		if (args.RejectedPrefix != "" || args.Singletons != "") && !strings.Contains(args.OutTemplate, "{prefix}") {
+			usageFatal("-out-template needs a {prefix} to keep the -out, -rejected and -singletons files apart")
+		}
+	}
+
+	if args.Rename != "" && !strings.Contains(args.Rename, "{n}") {
+		usageFatal("-rename needs an {n}, to give each read a different name")
+	}
+
+	if args.ChunkSize < 0 {
+		usageFatal("-chunk-size must not be negative")
+	}
+	if args.ChunkSize > 0 && (args.Out1 != "" || args.OutPrefix == "" && args.RejectedPrefix == "" && args.Singletons == "") {
+		usageFatal("-chunk-size needs -out, -rejected or -singletons, rather than stdout or -out1")
+	}
+
+	if args.Out2 != "" && args.Out1 == "" {
+		usageFatal("-out2 needs -out1")
+	}
+
+	if args.Out1 != "" && (args.Tab || args.CountOnly) {
+		usageFatal("-out1 and -out2 can't be combined with -tab or -count-only")
+	}
+
+	// Open the inputs, of every lane
+	var inputs []fqfilter.AmbiReader
+	var laneReaders []*fqfilter.PairedReader
+	numOutputs := len(fq)
+	for _, lane := range lanes {
+		laneInputs, readers := openInputs(lane, fasta, args.Strict, args.Threads)
+		for i := range laneInputs {
+			defer laneInputs[i].Close()
+		}
+		// With threads to spare, each input is parsed on its own goroutine,
+		// while this one filters and writes. Deferred, the readers stop
+		// before their inputs are closed.
+		if args.Threads > 1 {
+			for i := range readers {
+				ahead := fqfilter.NewReadAhead(readers[i], readAheadBatch)
+				defer ahead.Stop()
+				readers[i] = ahead
+			}
+		}
+		inputs = append(inputs, laneInputs...)
+		// Interleaved input holds both mates in the one file
+		var paired *fqfilter.PairedReader
+		if args.Interleaved {
+			paired = fqfilter.NewInterleavedReader(lane[0], readers[0])
+		} else {
+			paired = fqfilter.NewPairedReader(lane, readers)
+		}
+		paired.CheckNames = !args.NoCheckPairs
+		laneReaders = append(laneReaders, paired)
+	}
+	if args.Interleaved && args.Deinterleave {
+		numOutputs = 2
+	} else if !args.Interleaved && args.OutInterleaved {
+		// A single output takes each record's mates one after another
+		numOutputs = 1
+	}
+	numMates := laneReaders[0].Mates()
+	// The range of each mate's qualities, as read, to check the encoding
+	qualRanges := make([]fqfilter.QualityRange, numMates)
+	encodingWarned := false
+	// Tabular and BAM output have every mate in the one file
+	if args.Tab || args.OutFormat == "ubam" {
+		numOutputs = numMates
+	}
+	// The reads kept, for -qc-report
+	var cycles []*fqfilter.CycleStats
+	if args.QCReport != "" {
+		for i := 0; i < numMates; i++ {
+			cycles = append(cycles, &fqfilter.CycleStats{Offset: args.QualOffset})
+		}
+	}
+
+	// Outputs for inputs named like reads_R1.fq.gz and reads_I1.fq.gz are
+	// named the same way, rather than numbered
+	opts := outputOptions()
+	if !args.Interleaved {
+		opts.MateNames = fqfilter.MateNamesFromFiles(fq)
+	}
+
+	// With -per-lane, each lane's outputs are opened as the one before is
+	// finished, so keep them all for the summary
+	var output, rejected, singletons *fqfilter.Output
+	var allOutputs, allRejected, allSingletons []*fqfilter.Output
+	names := laneNames(lanes)
+	openOutputs := func(lane, written int) {
+		var err error
+		opts := opts
+		if args.PerLane {
+			opts.Vars = map[string]string{"lane": names[lane]}
+		}
+		prefix := func(p string) string {
+			if !args.PerLane || p == "" || args.OutTemplate != "" {
+				return p
+			}
+			return p + "_" + names[lane]
+		}
+		// Only the selected reads are renamed, numbered on from the lanes
+		// before
+		keptOpts := opts
+		keptOpts.Rename = args.Rename
+		keptOpts.RenameFrom = written
+		keptOpts.Cycles = cycles
+		// Lanes are limited as they are read, chunks as they are written
+		if !args.PerLane {
+			keptOpts.ChunkLimit = args.LimitPerOutput
+		}
+		if args.Out1 != "" {
+			filenames := []string{args.Out1}
+			if args.Out2 != "" {
+				filenames = append(filenames, args.Out2)
+			}
+			if len(filenames) != numOutputs {
+				usageFatalf("Got %d explicit output files for %d outputs\n", len(filenames), numOutputs)
+			}
+			output, err = fqfilter.OpenOutputFiles(filenames, keptOpts)
+			if err != nil {
+				fatal(err)
+			}
+		} else if !args.CountOnly {
+			output, err = fqfilter.OpenOutput(prefix(args.OutPrefix), numOutputs, keptOpts)
+			if err != nil {
+				fatal(err)
+			}
+		}
+		allOutputs = append(allOutputs, output)
+
+		if args.RejectedPrefix != "" {
+			rejected, err = fqfilter.OpenOutput(prefix(args.RejectedPrefix), numOutputs, opts)
+			if err != nil {
+				fatal(err)
+			}
+			allRejected = append(allRejected, rejected)
+		}
+
+		if args.Singletons != "" {
+			if numMates < 2 {
+				usageFatal("-singletons needs paired inputs")
+			}
+			singletons, err = fqfilter.OpenOutput(prefix(args.Singletons), numMates, opts)
+			if err != nil {
+				fatal(err)
+			}
+			allSingletons = append(allSingletons, singletons)
+		}
+		for _, o := range []*fqfilter.Output{output, rejected, singletons} {
+			if fns := o.Filenames(); len(fns) > 0 {
+				debugf("writing %s\n", strings.Join(fns, ", "))
+			}
+		}
+	}
+	// Closing flushes the buffered output, so check it worked
+	closeOutputs := func() {
+		if err := output.Close(); err != nil {
+			fatalf("Failed to close output: %v\n", err)
+		}
+		if err := rejected.Close(); err != nil {
+			fatalf("Failed to close rejected output: %v\n", err)
+		}
+		if err := singletons.Close(); err != nil {
+			fatalf("Failed to close singletons output: %v\n", err)
+		}
+	}
+	openOutputs(0, 0)
+
+	// Read in the lists of reads, or in -sorted mode just open the one list
+	norm := normalizer()
+	var filter fqfilter.NameSet
+	var sorted *fqfilter.SortedReads
+	var wanted *fqfilter.RecordSet
+	if !byName {
+		// Leave the filter nil, to select everything
+	} else if byIndex {
+		wanted = fqfilter.NewRecordSet()
+		for _, fn := range args.ReadsFilenames {
+			n, err := fqfilter.LoadRecordNumbers(wanted, fn)
+			if err != nil {
+				fatalf("Failed to load %s: %v\n", fn, err)
+			}
+			if !args.Quiet {
+				log.Printf("read %d lines from %s\n", n, fn)
+			}
+		}
+		if !args.Quiet {
+			log.Printf("loaded %d record numbers, up to %d\n", wanted.Len(), wanted.Max())
+		}
+		if wanted.Len() == 0 && !args.Invert && !args.AllowEmpty {
+			warnf("no record numbers were loaded, so no reads will be selected (use -allow-empty if this is intended)\n")
+		}
+	} else if args.Sorted {
+		reads := fqfilter.AmbiReader{}
+		readsFn := args.ReadsFilenames[0]
+		if readsFn == "stdin" {
+			readsFn = ""
+		}
+		if err := reads.Open(readsFn); err != nil {
+			fatalf("Failed to open %s: %v\n", args.ReadsFilenames[0], err)
+		}
+		defer reads.Close()
+		sorted = fqfilter.NewSortedReads(reads, norm)
+		filter = sorted
+	} else {
+		filter = newNameSet()
+		// For an intersection or subtraction each list gets its own set
+		var lists []fqfilter.NameSet
+		flags := fqfilter.BamFlags{Primary: args.BamPrimary, Require: args.BamRequire, Exclude: args.BamExclude}
+		if args.BamMapped {
+			flags.Exclude |= fqfilter.FlagUnmapped
+		}
+		if args.BamUnmapped {
+			flags.Require |= fqfilter.FlagUnmapped
+		}
+		if args.BamProperPair {
+			flags.Require |= fqfilter.FlagProperPair
+		}
+		for _, fn := range args.ReadsFilenames {
+			set := filter
+			if setOp != fqfilter.SetUnion {
+				set = newNameSet()
+				lists = append(lists, set)
+			}
+			format := readsFormat(fn)
+			n, err := fqfilter.LoadReads(set, fn, format, norm, flags, maxLineBytes)
+			if err != nil {
+				fatalf("Failed to load %s: %v\n", fn, err)
+			}
+			if !args.Quiet && format == fqfilter.ReadsNames {
+				log.Printf("read %d lines from %s\n", n, fn)
+			} else if !args.Quiet {
+				log.Printf("read %d %s records from %s\n", n, format, fn)
+			}
+			if !args.Quiet && setOp != fqfilter.SetUnion {
+				log.Printf("%s holds %d unique names\n", fn, set.Len())
+			}
+		}
+		if setOp != fqfilter.SetUnion {
+			filter = &fqfilter.CombinedSet{Op: setOp, Sets: lists}
+		}
+		for _, fn := range args.ReadsBAM {
+			n, err := fqfilter.LoadNamesBAM(filter, fn, norm, flags)
+			if err != nil {
+				fatalf("Failed to load %s: %v\n", fn, err)
+			}
+			if !args.Quiet {
+				log.Printf("read %d alignments from %s\n", n, fn)
+			}
+		}
+		for _, name := range args.Names {
+			if err := filter.Add(norm.Entry(name)); err != nil {
+				usageFatalf("Invalid -name %s: %v\n", name, err)
+			}
+		}
+		if !args.Quiet && setOp == fqfilter.SetUnion {
+			log.Printf("loaded %d unique names from %d reads files\n", filter.Len(), len(args.ReadsFilenames)+len(args.ReadsBAM))
+		}
+		if filter.Len() == 0 && !args.Invert && !args.AllowEmpty {
+			warnf("no read names were loaded, so no reads will be selected (use -allow-empty if this is intended)\n")
+		}
+	}
+
+	rng := rand.New(rand.NewSource(args.Seed))
+	selector := fqfilter.Filter{
+		Names:          filter,
+		Records:        wanted,
+		Normalizer:     norm,
+		HeaderRegexps:  headerRegexps,
+		Invert:         args.Invert,
+		MinLen:         args.MinLen,
+		MaxLen:         args.MaxLen,
+		Quality:        quality,
+		PairPolicy:     pairPolicy,
+		LimitN:         args.MaxN >= 0,
+		MaxN:           args.MaxN,
+		MinComplexity:  args.MinComplexity,
+		MinGC:          args.MinGC,
+		MaxGC:          args.MaxGC,
+		Expr:           expr,
+		ContainsInvert: args.ContainsInvert,
+		Fraction:       args.Fraction,
+		Every:          args.Every,
+		EveryOffset:    args.EveryOffset,
+		Rand:           rng,
+		Trim:           args.Trim,
+	}
+	if args.BarcodeWhitelist != "" {
+		pos, err := fqfilter.ParseBarcodePos(args.BarcodePos)
+		if err != nil {
+			fatal(err)
+		}
+		selector.Barcodes = fqfilter.NewBarcodeSet(args.BarcodeMismatch)
+		selector.BarcodePos = pos
+		selector.TagBarcode = args.BarcodeCorrect
+		n, err := fqfilter.LoadBarcodes(selector.Barcodes, args.BarcodeWhitelist)
+		if err != nil {
+			fatalf("Failed to read %s: %v\n", args.BarcodeWhitelist, err)
+		}
+		if n == 0 {
+			fatalf("%s holds no barcodes\n", args.BarcodeWhitelist)
+		}
+		if pos.Len != len(selector.Barcodes.Barcode(0)) {
+			usageFatalf("-barcode-pos %s is %d bases long, but the whitelisted barcodes are %d\n", args.BarcodePos, pos.Len, len(selector.Barcodes.Barcode(0)))
+		}
+	}
+	if args.RevComp != "" {
+		selector.RevComp = revcompMates(args.RevComp, numMates)
+	}
+	if args.UMILen > 0 {
+		if args.UMIRead > numMates {
+			usageFatal("-umi-read 2 needs a second mate")
+		}
+		selector.UMI = &fqfilter.UMIExtractor{Length: args.UMILen, Mate: args.UMIRead - 1}
+	}
+	if cropping() {
+		if numMates < 2 && (args.Crop2 > 0 || args.HeadCrop2 > 0) {
+			usageFatal("-crop2 and -headcrop2 need a second mate")
+		}
+		selector.Trimmers = append(selector.Trimmers, cropTrimmer(numMates))
+	}
+	var adapters *fqfilter.AdapterTrimmer
+	if len(args.Adapters) > 0 {
+		adapters = fqfilter.NewAdapterTrimmer(args.Adapters)
+		adapters.ErrorRate = args.AdapterErrorRate
+		adapters.MinOverlap = args.AdapterOverlap
+		selector.Trimmers = append(selector.Trimmers, adapters)
+	}
+	var polyX *fqfilter.PolyXTrimmer
+	if args.TrimPolyX != "" {
+		polyX = &fqfilter.PolyXTrimmer{Bases: strings.ReplaceAll(args.TrimPolyX, ",", ""), MinLen: args.TrimPolyXMin}
+		selector.Trimmers = append(selector.Trimmers, polyX)
+	}
+	var qualityTrim *fqfilter.QualityTrimmer
+	if args.TrimQual > 0 {
+		qualityTrim = &fqfilter.QualityTrimmer{Threshold: args.TrimQual, Window: args.TrimWindow, Offset: args.QualOffset}
+		selector.Trimmers = append(selector.Trimmers, qualityTrim)
+	}
+	if len(args.SeqMatch) > 0 {
+		mate := map[string]int{"1": 0, "2": 1, "either": -1}[args.SeqMatchMate]
+		if mate >= numMates {
+			usageFatal("-seq-match-mate 2 needs a second mate")
+		}
+		selector.Motif = motif
+		selector.MotifMate = mate
+	}
+	if len(args.Contains) > 0 {
+		selector.Contains = fqfilter.NewSeqMatcher(args.Contains)
+	}
+	if args.Contaminants != "" {
+		kmers, err := fqfilter.LoadKmers(args.Contaminants, args.ContaminantK, maxLineBytes)
+		if err != nil {
+			fatalf("Failed to read %s: %v\n", args.Contaminants, err)
+		}
+		if kmers.Len() == 0 {
+			fatalf("%s holds no sequences %d bases long\n", args.Contaminants, args.ContaminantK)
+		}
+		if !args.Quiet {
+			log.Printf("loaded %d %d-mers from %s\n", kmers.Len(), args.ContaminantK, args.Contaminants)
+		}
+		selector.Contaminants = kmers
+		selector.MaxContaminantHits = args.ContaminantHits
+	}
+	for _, spec := range args.Plugins {
+		custom, err := loadPlugin(spec)
+		if err != nil {
+			fatalf("Failed to load -plugin %s: %v\n", spec, err)
+		}
+		selector.Custom = append(selector.Custom, custom)
+	}
+	var collapser *fqfilter.UMICollapser
+	if collapseUMIs {
+		collapser = fqfilter.NewUMICollapser(args.DedupStartLen)
+		collapser.Offset = args.QualOffset
+	} else if args.Dedup {
+		selector.Seen = fqfilter.NewSeenNames(args.SetMode != "exact")
+		selector.DedupBy = dedupBy
+	}
+	var reservoir *fqfilter.Reservoir
+	if args.Sample > 0 {
+		reservoir = fqfilter.NewReservoir(args.Sample, rng)
+	}
+
+	var stats RunStats
+	mates := make([]fqfilter.Record, numMates)
+	var progress *Progress
+	if args.Progress {
+		progress = StartProgress(args.ProgressInterval, inputs)
+	}
+	// The reads of the lanes before this one
+	laneStart := 0
+
+	// Once the reads are read, or a signal cuts them short, finish writes out
+	// what is held back and closes the outputs, then logs the counts
+	var interrupt *Interrupt
+	// Whether the loop ended on an input that couldn't be parsed
+	malformed := false
+	finish := func(err error, interrupted bool) {
+		if progress != nil {
+			progress.Stop()
+		}
+		// What remains of a sorted list can't be checked if the reads weren't
+		// all read
+		if err == nil && sorted != nil && !interrupted {
+			err = sorted.Finish()
+		}
+		if err == nil && reservoir != nil {
+			stats.SampledOut += reservoir.Dropped()
+			stats.BasesIncluded = reservoir.Bases()
+			stats.Included, err = reservoir.WriteTo(output)
+		}
+		if err == nil && collapser != nil {
+			stats.Duplicates = collapser.Collapsed()
+			stats.BasesIncluded = collapser.Bases()
+			stats.Included, err = collapser.WriteTo(output)
+		}
+		if err != nil && malformed {
+			inputFatal(err)
+		} else if err != nil {
+			fatal(err)
+		}
+		if reservoir != nil && stats.Included < args.Sample {
+			warnf("only %d reads were selected, fewer than the %d asked for by -sample-n, so all of them were kept\n", stats.Included, args.Sample)
+		}
+
+		closeOutputs()
+
+		if converter != nil {
+			if _, sure := qualRanges[0].Offset(); !sure && !encodingWarned {
+				warnf("the qualities could be Phred+33 or Phred+64, so they were taken to be Phred+%d, as -qual-offset says\n", inputOffset)
+			}
+			if converter.Clamped > 0 && !args.Quiet {
+				log.Printf("quality scores below 0 raised to 0: %d\n", converter.Clamped)
+			}
+		}
+
+		if adapters != nil {
+			stats.AdapterTrimmed = adapters.Trimmed
+		}
+		if qualityTrim != nil {
+			stats.QualityTrimmed = qualityTrim.Trimmed
+		}
+		if polyX != nil {
+			stats.PolyXTrimmed = polyX.Trimmed
+			stats.PolyXBasesTrimmed = polyX.TrimmedBases
+		}
+		if n := (stats.Included + stats.Excluded) * numMates; n > 0 {
+			stats.MeanLength = float64(stats.BasesIncluded+stats.BasesExcluded) / float64(n)
+		}
+
+		if !args.Quiet {
+			log.Println("included:", stats.Included)
+			log.Println("excluded:", stats.Excluded)
+			log.Println("bases included:", stats.BasesIncluded)
+			log.Println("bases excluded:", stats.BasesExcluded)
+			log.Printf("mean length: %.1f\n", stats.MeanLength)
+			if len(args.Adapters) > 0 {
+				log.Println("mates adapter trimmed:", stats.AdapterTrimmed)
+			}
+			if args.TrimPolyX != "" {
+				log.Println("mates polyX trimmed:", stats.PolyXTrimmed)
+				log.Println("polyX bases trimmed:", stats.PolyXBasesTrimmed)
+			}
+			if args.TrimQual > 0 {
+				log.Println("mates quality trimmed:", stats.QualityTrimmed)
+			}
+			if args.BarcodeWhitelist != "" {
+				log.Println("barcode filtered:", stats.BarcodeFiltered)
+			}
+			if args.MinLen > 0 || args.MaxLen > 0 {
+				log.Println("length filtered:", stats.LengthFiltered)
+			}
+			if quality.Enabled() {
+				log.Println("quality filtered:", stats.QualityFiltered)
+			}
+			if args.MaxN >= 0 {
+				log.Println("N filtered:", stats.NFiltered)
+			}
+			if args.MinComplexity > 0 {
+				log.Println("complexity filtered:", stats.ComplexityFiltered)
+			}
+			if args.MinGC > 0 || args.MaxGC > 0 {
+				log.Println("GC filtered:", stats.GCFiltered)
+			}
+			if expr != nil {
+				log.Println("expression filtered:", stats.ExpressionFiltered)
+			}
+			if motif != nil {
+				log.Println("motif filtered:", stats.MotifFiltered)
+			}
+			if args.Contaminants != "" {
+				log.Println("contaminant filtered:", stats.ContaminantFiltered)
+			}
+			if len(args.Plugins) > 0 {
+				log.Println("plugin filtered:", stats.PluginFiltered)
+			}
+			if args.Dedup {
+				log.Println("duplicates:", stats.Duplicates)
+			}
+			if singletons != nil {
+				log.Println("singletons:", stats.Singletons)
+			}
+			if args.Fraction > 0 || args.Sample > 0 || args.Every > 0 {
+				log.Println("sampled out:", stats.SampledOut)
+			}
+			if args.Skip > 0 {
+				log.Println("skipped:", stats.Skipped)
+			}
+			if args.LimitPerOutput > 0 && !args.PerLane {
+				log.Println("over -limit-per-output:", stats.OverLimit)
+			}
+		}
+
+		// Names that didn't match the reads read so far may yet match the rest
+		if args.Unmatched != "" && !interrupted {
+			if err := writeUnmatched(args.Unmatched, filter); err != nil {
+				fatalf("Failed to write %s: %v\n", args.Unmatched, err)
+			}
+		}
+
+		stats.Total = stats.Included + stats.Excluded + stats.LengthFiltered + stats.QualityFiltered + stats.NFiltered + stats.ComplexityFiltered + stats.GCFiltered + stats.ExpressionFiltered + stats.MotifFiltered + stats.ContentFiltered + stats.ContaminantFiltered + stats.PluginFiltered + stats.BarcodeFiltered + stats.SampledOut + stats.Duplicates + stats.OverLimit
+		if filter != nil {
+			stats.ReadsInFilter = filter.Len()
+			stats.ReadsInFilterMatched = filter.Matched()
+		} else if wanted != nil {
+			stats.ReadsInFilter = wanted.Len()
+			stats.ReadsInFilterMatched = wanted.Matched()
+		}
+		stats.ElapsedMs = time.Since(start).Milliseconds()
+		debugf("finished in %.1fs\n", time.Since(start).Seconds())
+
+		if args.StatsJSON != "" {
+			if err := writeJSON(args.StatsJSON, stats); err != nil {
+				fatalf("Failed to write %s: %v\n", args.StatsJSON, err)
+			}
+		}
+
+		if args.QCReport != "" {
+			if err := writeQCReport(args.QCReport, cycles); err != nil {
+				fatalf("Failed to write the -qc-report: %v\n", err)
+			}
+		}
+
+		if args.SummaryJSON != "" {
+			summary := RunSummary{
+				Outputs:     outputFilenames(allOutputs),
+				Rejected:    outputFilenames(allRejected),
+				Singletons:  outputFilenames(allSingletons),
+				Options:     make(map[string]string),
+				Counts:      stats,
+				Started:     start,
+				WallSeconds: time.Since(start).Seconds(),
+			}
+			for _, lane := range lanes {
+				summary.Inputs = append(summary.Inputs, lane...)
+			}
+			for _, o := range allOutputs {
+				records, bases := o.Written()
+				summary.RecordsWritten += records
+				summary.BasesWritten += bases
+			}
+			filterFlags.Visit(func(f *flag.Flag) { summary.Options[f.Name] = f.Value.String() })
+			if err := writeJSON(args.SummaryJSON, summary); err != nil {
+				fatalf("Failed to write %s: %v\n", args.SummaryJSON, err)
+			}
+		}
+
+		if interrupted {
+			log.Printf("interrupted after %d reads; the outputs hold the reads up to then\n", stats.Total+stats.Skipped)
+			os.Exit(interrupt.ExitCode())
+		}
+		if args.FailIfEmpty && stats.Included == 0 {
+			log.Println("no reads were included")
+			os.Exit(exitEmpty)
+		}
+	}
+
+	// The main loop holds mu except while it waits for a read, so a signal
+	// that comes while it's stuck on a slow input can finish from the
+	// signal's goroutine instead
+	var mu sync.Mutex
+	mu.Lock()
+	interrupt = CatchInterrupts(func() {
+		mu.Lock()
+		finish(nil, true)
+	})
+
+	// Iterate over the inputs in sync
+	err := func() error {
+		for lane, paired := range laneReaders {
+			if lane > 0 {
+				laneStart += laneReaders[lane-1].Records()
+				if args.PerLane {
+					closeOutputs()
+					openOutputs(lane, stats.Included)
+				}
+			}
+			laneIncluded := stats.Included
+			for {
+				if progress != nil {
+					progress.Update(laneStart+paired.Records(), stats.Included, stats.Excluded)
+				}
+				if interrupt.Caught() {
+					return nil
+				}
+				mu.Unlock()
+				err := paired.Read(mates)
+				mu.Lock()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					malformed = true
+					return err
+				}
+				records := laneStart + paired.Records()
+				if records <= args.Skip {
+					stats.Skipped++
+					continue
+				}
+				if args.Take > 0 && records > args.Skip+args.Take {
+					return nil
+				}
+				// No read past the last record number can be selected
+				if wanted != nil && !args.Invert && records > wanted.Max() {
+					return nil
+				}
+				selector.Record = records
+				bases := 0
+				for i := range mates {
+					bases += len(mates[i].Sequence)
+					if !fasta {
+						qualRanges[i].Add(mates[i].Quality)
+					}
+					if converter != nil {
+						converter.Convert(&mates[i])
+					}
+				}
+				if !encodingWarned && !fasta {
+					encodingWarned = checkEncoding(qualRanges, inputOffset, records)
+				}
+				res, err := selector.Apply(mates)
+				if err != nil {
+					return err
+				}
+				if explain(records) {
+					log.Printf("explain: read %d header %q looked up as %q found=%v selected=%v\n", records, mates[0].Header, res.Name, res.Found, res.Selected())
+				}
+				if singletons != nil && res.MateFiltered() {
+					for i := range mates {
+						if selector.MatePasses(&mates[i]) {
+							stats.Singletons++
+							if err := singletons.WriteMate(i, &mates[i]); err != nil {
+								return err
+							}
+						}
+					}
+				}
+				// res.Name is the name as it was matched, which may be
+				// lowercased or stripped of its mate, so write the read
+				// under its own
+				name := norm.ReadName(mates[0].Header)
+				switch res.Decision {
+				case fqfilter.LengthFiltered:
+					stats.LengthFiltered++
+				case fqfilter.QualityFiltered:
+					stats.QualityFiltered++
+				case fqfilter.NFiltered:
+					stats.NFiltered++
+				case fqfilter.ComplexityFiltered:
+					stats.ComplexityFiltered++
+				case fqfilter.GCFiltered:
+					stats.GCFiltered++
+				case fqfilter.ExpressionFiltered:
+					stats.ExpressionFiltered++
+				case fqfilter.MotifFiltered:
+					stats.MotifFiltered++
+				case fqfilter.ContentFiltered:
+					stats.ContentFiltered++
+				case fqfilter.ContaminantFiltered:
+					stats.ContaminantFiltered++
+				case fqfilter.CustomFiltered:
+					stats.PluginFiltered++
+				case fqfilter.BarcodeFiltered:
+					stats.BarcodeFiltered++
+				case fqfilter.SampledOut:
+					stats.SampledOut++
+				case fqfilter.Duplicate:
+					stats.Duplicates++
+				case fqfilter.Included:
+					if reservoir != nil {
+						reservoir.Add(name, mates, bases)
+						break
+					}
+					if collapser != nil {
+						collapser.Add(name, mates, bases)
+						break
+					}
+					if output.Full() {
+						output.Skip()
+						stats.OverLimit++
+						break
+					}
+					stats.Included++
+					stats.BasesIncluded += bases
+					if err := output.Write(name, mates); err != nil {
+						return err
+					}
+				case fqfilter.Excluded:
+					stats.Excluded++
+					stats.BasesExcluded += bases
+					if rejected != nil {
+						if err := rejected.Write(name, mates); err != nil {
+							return err
+						}
+					}
+				}
+				// Each limit is checked once the read is written, with all
+				// its mates
+				if args.Limit > 0 && stats.Included >= args.Limit {
+					if !args.Quiet {
+						log.Println("reached limit")
+					}
+					return nil
+				}
+				if args.LimitExcluded > 0 && stats.Excluded >= args.LimitExcluded {
+					if !args.Quiet {
+						log.Println("reached -limit-excluded")
+					}
+					return nil
+				}
+				if args.PerLane && args.LimitPerOutput > 0 && stats.Included-laneIncluded >= args.LimitPerOutput {
+					if !args.Quiet {
+						log.Printf("reached -limit-per-output for lane %s\n", names[lane])
+					}
+					break
+				}
+			}
+		}
+		return nil
+	}()
+	finish(err, interrupt.Stop())
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/kbullaugheysas/fqfilter"
+)
+
+/* The index command writes the name and offset of every read in a FASTQ
+ * file to a file alongside it, which fetch uses to pull out a few reads */
+
+type IndexArgs struct {
+	Out   string
+	Quiet bool
+}
+
+var indexArgs = IndexArgs{}
+
+var indexFlags = flag.NewFlagSet("index", flag.ExitOnError)
+
+func init() {
+	indexFlags.StringVar(&indexArgs.Out, "out", "", "file to write the index to, for a single input (default = the input with .fqi added)")
+	indexFlags.BoolVar(&indexArgs.Quiet, "quiet", false, "don't log the number of reads indexed to stderr")
+	addLogFlags(indexFlags)
+
+	indexFlags.Usage = func() {
+		log.Println("usage: fqfilter index [options] reads.fq.gz ...")
+		log.Println("Inputs must be plain text or compressed with bgzip (BGZF).")
+		indexFlags.PrintDefaults()
+	}
+}
+
+func runIndex(argv []string) {
+	indexFlags.Parse(argv)
+	fq := indexFlags.Args()
+	if len(fq) == 0 {
+		usageFatal("Must specify at least one fastq file")
+	}
+	if indexArgs.Out != "" && len(fq) > 1 {
+		usageFatal("-out can only be given with a single input")
+	}
+	for _, fn := range fq {
+		out := indexArgs.Out
+		if out == "" {
+			out = fqfilter.IndexFilename(fn)
+		}
+		fp, err := os.Create(out)
+		if err != nil {
+			fatalf("Failed to open %s for writing: %v\n", out, err)
+		}
+		records, err := fqfilter.BuildIndex(fn, fp)
+		if err != nil {
+			fp.Close()
+			os.Remove(out)
+			fatalf("Failed to index %s: %v\n", fn, err)
+		}
+		if err := fp.Close(); err != nil {
+			fatalf("Failed to write %s: %v\n", out, err)
+		}
+		if !indexArgs.Quiet {
+			log.Printf("%s: indexed %d reads in %s\n", fn, records, out)
+		}
+	}
+}
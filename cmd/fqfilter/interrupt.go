@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+/* How long the main loop has to notice a signal before it is taken to be
+ * stuck waiting on a slow input */
+const interruptGrace = 2 * time.Second
+
+/* Catches SIGINT and SIGTERM while reads are being filtered, so that the main
+ * loop can stop at the next read and close its outputs properly, rather than
+ * leave gzip files cut off part way through a block. If the loop doesn't see
+ * the signal in time, as when it is waiting on a pipe, the stuck function
+ * given is called from the signal's goroutine instead. A second signal, once
+ * the first has been seen, takes its default action and ends the program at
+ * once. */
+type Interrupt struct {
+	ch     chan os.Signal
+	stop   chan struct{}
+	seen   chan struct{}
+	once   sync.Once
+	caught atomic.Bool
+	sig    os.Signal
+}
+
+func CatchInterrupts(stuck func()) *Interrupt {
+	i := &Interrupt{
+		ch:   make(chan os.Signal, 1),
+		stop: make(chan struct{}),
+		seen: make(chan struct{}),
+	}
+	signal.Notify(i.ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case i.sig = <-i.ch:
+			signal.Stop(i.ch)
+			i.caught.Store(true)
+			log.Printf("caught %v, finishing the outputs (send it again to quit at once)\n", i.sig)
+		case <-i.stop:
+			return
+		}
+		select {
+		case <-i.seen:
+		case <-time.After(interruptGrace):
+			stuck()
+		}
+	}()
+	return i
+}
+
+/* Whether a signal has been caught. This is cheap enough to check for every
+ * read. */
+func (i *Interrupt) Caught() bool {
+	if !i.caught.Load() {
+		return false
+	}
+	i.once.Do(func() { close(i.seen) })
+	return true
+}
+
+/* Stop catching signals, returning whether one was caught */
+func (i *Interrupt) Stop() bool {
+	signal.Stop(i.ch)
+	close(i.stop)
+	return i.Caught()
+}
+
+/* The exit status for a run cut short by a signal: 128 plus the signal's
+ * number, as shells report it, so 130 for SIGINT and 143 for SIGTERM */
+func (i *Interrupt) ExitCode() int {
+	if sig, ok := i.sig.(syscall.Signal); ok {
+		return 128 + int(sig)
+	}
+	return 1
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Everything the commands log goes to stderr, or -log-file, at one of these
+ * levels. Plain log.Print calls are info: the counts and what was loaded.
+ * Debug messages only appear with -verbose. */
+type logLevel string
+
+const (
+	levelDebug    logLevel = "debug"
+	levelInfo     logLevel = "info"
+	levelProgress logLevel = "progress"
+	levelWarn     logLevel = "warn"
+	levelError    logLevel = "error"
+)
+
+/* Where and how messages are written. Requests served at the same time log
+ * at once, hence the lock. */
+type logSink struct {
+	sync.Mutex
+	out     io.Writer
+	json    bool
+	verbose bool
+	command string
+}
+
+var logs = &logSink{out: os.Stderr, command: "filter"}
+
+/* A line as -log-format json writes it */
+type logRecord struct {
+	Time    string         `json:"time"`
+	Level   logLevel       `json:"level"`
+	Command string         `json:"command"`
+	Msg     string         `json:"msg"`
+	Fields  map[string]any `json:"fields,omitempty"`
+}
+
+func (s *logSink) emit(level logLevel, msg string, fields map[string]any) {
+	if level == levelDebug && !s.verbose {
+		return
+	}
+	// The message may already end in a newline, as errors here often do
+	msg = strings.TrimRight(msg, "\n")
+	s.Lock()
+	defer s.Unlock()
+	if s.json {
+		line, _ := json.Marshal(logRecord{time.Now().Format(time.RFC3339Nano), level, s.command, msg, fields})
+		s.out.Write(append(line, '\n'))
+		return
+	}
+	if level == levelWarn {
+		msg = "WARNING: " + msg
+	}
+	io.WriteString(s.out, msg+"\n")
+}
+
+/* The standard logger's output, at info level */
+func (s *logSink) Write(p []byte) (int, error) {
+	s.emit(levelInfo, string(p), nil)
+	return len(p), nil
+}
+
+func init() {
+	log.SetOutput(logs)
+}
+
+func debugf(format string, v ...interface{}) {
+	logs.emit(levelDebug, fmt.Sprintf(format, v...), nil)
+}
+
+func warnf(format string, v ...interface{}) {
+	logs.emit(levelWarn, fmt.Sprintf(format, v...), nil)
+}
+
+/* Like log.Fatal, but logged as an error */
+func fatal(v ...interface{}) {
+	logs.emit(levelError, fmt.Sprint(v...), nil)
+	os.Exit(exitFailure)
+}
+
+func fatalf(format string, v ...interface{}) {
+	logs.emit(levelError, fmt.Sprintf(format, v...), nil)
+	os.Exit(exitFailure)
+}
+
+/* The -log-format flag, which takes effect as soon as it is parsed */
+type logFormatFlag struct{}
+
+func (logFormatFlag) String() string {
+	if logs.json {
+		return "json"
+	}
+	return "text"
+}
+
+func (logFormatFlag) Set(v string) error {
+	switch v {
+	case "text", "json":
+		logs.json = v == "json"
+		return nil
+	}
+	return fmt.Errorf("must be text or json")
+}
+
+/* The -log-file flag, opening the file, to add to, as soon as it is parsed */
+type logFileFlag struct {
+	name string
+}
+
+func (f *logFileFlag) String() string {
+	return f.name
+}
+
+func (f *logFileFlag) Set(v string) error {
+	fp, err := os.OpenFile(v, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	f.name = v
+	logs.out = fp
+	return nil
+}
+
+var logFile logFileFlag
+
+func addLogFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&logs.verbose, "verbose", false, "also log debugging detail, such as each input and output as it is opened")
+	flags.Var(logFormatFlag{}, "log-format", "text, or json for one JSON object per line, with the time, level (debug, info, progress, warn or error), command and message")
+	flags.Var(&logFile, "log-file", "add the log to this file rather than writing it to stderr")
+}
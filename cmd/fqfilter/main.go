@@ -0,0 +1,168 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/kbullaugheysas/fqfilter"
+)
+
+/* A subcommand, run with the arguments that follow its name */
+type command struct {
+	name    string
+	summary string
+	run     func(argv []string)
+}
+
+var commands []command
+
+func init() {
+	log.SetFlags(0)
+	commands = []command{
+		{"filter", "select reads by name (the default when no command is given)", runFilter},
+		{"sample", "take a random sample of the reads: filter, needing -sample-n, -sample-fraction or -every", runSample},
+		{"trim", "trim the reads: filter, needing -adapter, -trim-qual or another trim", runTrim},
+		{"demux", "split reads into a set of files per sample by their barcodes", runDemux},
+		{"pair", "match up the mates in two files that are out of step, by name", runPair},
+		{"merge", "concatenate the inputs of several lanes into one file per mate", runMerge},
+		{"names", "write the name of every read, as a reads file for filter", runNames},
+		{"untab", "turn a table written by -tab back into FASTQ files", runUntab},
+		{"index", "write an index of the reads in a FASTQ file, for fetch", runIndex},
+		{"fetch", "pull the named reads out of indexed FASTQ files", runFetch},
+		{"serve", "load the names once and filter reads sent over HTTP", runServe},
+		{"validate", "check that FASTQ files are well formed and their mates in step", runValidate},
+		{"stats", "summarize the reads, lengths, qualities and composition of each input", runStats},
+		{"help", "list the commands, or show the options for one", runHelp},
+	}
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+func printCommands() {
+	log.Println("commands:")
+	for _, c := range commands {
+		log.Printf("  %-8s %s\n", c.name, c.summary)
+	}
+}
+
+func runHelp(argv []string) {
+	if len(argv) == 0 {
+		log.Println("usage: fqfilter <command> [options] ...")
+		printCommands()
+		return
+	}
+	c := findCommand(argv[0])
+	if c == nil || c.name == "help" {
+		usageFatalf("Unknown command %s\n", argv[0])
+	}
+	c.run([]string{"-h"})
+}
+
+/* Exit statuses, so that scripts and workflow engines can tell what went
+ * wrong without reading the log */
+const (
+	// An input or output couldn't be read or written, or anything else
+	exitFailure = 1
+	// The arguments are wrong, as when the flag package rejects them
+	exitUsage = 2
+	// An input isn't valid FASTQ or FASTA, or is cut short
+	exitMalformed = 3
+	// No reads were included, with -fail-if-empty
+	exitEmpty = 4
+)
+
+/* Like fatal, but for bad arguments */
+func usageFatal(v ...interface{}) {
+	logs.emit(levelError, fmt.Sprint(v...), nil)
+	os.Exit(exitUsage)
+}
+
+func usageFatalf(format string, v ...interface{}) {
+	logs.emit(levelError, fmt.Sprintf(format, v...), nil)
+	os.Exit(exitUsage)
+}
+
+/* Like fatal, but for an input that can't be parsed */
+func inputFatal(v ...interface{}) {
+	logs.emit(levelError, fmt.Sprint(v...), nil)
+	os.Exit(exitMalformed)
+}
+
+func inputFatalf(format string, v ...interface{}) {
+	logs.emit(levelError, fmt.Sprintf(format, v...), nil)
+	os.Exit(exitMalformed)
+}
+
+/* The longest line an input may have, shared by every command that reads
+ * FASTQ or FASTA inputs */
+var maxLineBytes = fqfilter.DefaultMaxLineBytes
+
+func addMaxLineFlag(flags *flag.FlagSet) {
+	flags.IntVar(&maxLineBytes, "max-line-bytes", fqfilter.DefaultMaxLineBytes, "the longest line an input may have, such as the sequence of a long nanopore read, or 0 for no limit")
+}
+
+/* Whether commands may overwrite files that already exist */
+var force bool
+
+func addForceFlag(flags *flag.FlagSet) {
+	flags.BoolVar(&force, "force", false, "overwrite output files that already exist, rather than stopping with an error")
+}
+
+/* Write each output file under a temporary name, moving it into place once
+ * it is complete, and keep existing files unless -force is given */
+func safeWrites(opts fqfilter.WriteOptions) fqfilter.WriteOptions {
+	opts.Atomic = true
+	opts.NoClobber = !force
+	return opts
+}
+
+/* Open each input as FASTQ, or FASTA, decompressing with the given number of
+ * threads. An input of - is stdin, such as interleaved pairs from a pipe.
+ * The caller closes the inputs. */
+func openInputs(fns []string, fasta, strict bool, threads int) ([]fqfilter.AmbiReader, []fqfilter.RecordReader) {
+	inputs := make([]fqfilter.AmbiReader, len(fns))
+	readers := make([]fqfilter.RecordReader, len(fns))
+	for i, fn := range fns {
+		src := fn
+		if src == "-" {
+			src = ""
+		}
+		if err := inputs[i].OpenWith(src, fqfilter.ReadOptions{Threads: threads}); err != nil {
+			fatalf("Failed to open %s: %v\n", fn, err)
+		}
+		debugf("reading %s\n", fn)
+		if fasta {
+			r := fqfilter.NewFastaReader(inputs[i])
+			r.SetMaxLineBytes(maxLineBytes)
+			readers[i] = r
+		} else {
+			r := fqfilter.NewFastqReader(inputs[i])
+			r.Strict = strict
+			r.SetMaxLineBytes(maxLineBytes)
+			readers[i] = r
+		}
+	}
+	return inputs, readers
+}
+
+/* The first argument picks the command. Anything else, including an input
+ * file or a flag, runs filter, so older invocations keep working. */
+func main() {
+	if len(os.Args) > 1 {
+		if c := findCommand(os.Args[1]); c != nil {
+			logs.command = c.name
+			c.run(os.Args[2:])
+			return
+		}
+	}
+	runFilter(os.Args[1:])
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/kbullaugheysas/fqfilter"
+)
+
+/* The merge command concatenates the FASTQ files of several lanes, one
+ * output per mate, checking that each lane's mates stay in step */
+
+type MergeArgs struct {
+	OutPrefix    string
+	GzipLevel    int
+	Threads      int
+	NoCheckPairs bool
+	Quiet        bool
+}
+
+var mergeArgs = MergeArgs{}
+
+var mergeFlags = flag.NewFlagSet("merge", flag.ExitOnError)
+
+func init() {
+	mergeFlags.StringVar(&mergeArgs.OutPrefix, "out", "", "write the merged mates to <out>_1.fq.gz, <out>_2.fq.gz and so on, or <out>_R1.fq.gz for inputs named like that (required)")
+	mergeFlags.IntVar(&mergeArgs.GzipLevel, "gzip-level", gzip.DefaultCompression, "gzip compression level for output files (1-9, or 0 for uncompressed output)")
+	mergeFlags.IntVar(&mergeArgs.Threads, "threads", 1, "number of goroutines decompressing each compressed input and compressing each compressed output file")
+	addMaxLineFlag(mergeFlags)
+	mergeFlags.BoolVar(&mergeArgs.NoCheckPairs, "no-check-pairs", false, "don't check that the mates of each read have the same name, only that each lane's inputs hold the same number of records")
+	mergeFlags.BoolVar(&mergeArgs.Quiet, "quiet", false, "don't log the counts to stderr")
+	addForceFlag(mergeFlags)
+	addLogFlags(mergeFlags)
+
+	mergeFlags.Usage = func() {
+		log.Println("usage: fqfilter merge -out prefix [options] L001_R1.fq.gz,L001_R2.fq.gz L002_R1.fq.gz,L002_R2.fq.gz ...")
+		mergeFlags.PrintDefaults()
+	}
+}
+
+func runMerge(argv []string) {
+	mergeFlags.Parse(argv)
+	if mergeFlags.NArg() == 0 {
+		usageFatal("Must specify at least one lane of fastq files")
+	}
+	if mergeArgs.OutPrefix == "" {
+		usageFatal("Must provide an -out prefix")
+	}
+	if mergeArgs.Threads < 1 {
+		usageFatal("-threads must be at least 1")
+	}
+	// Each argument is a lane, with its mates separated by commas
+	var lanes [][]string
+	for _, arg := range mergeFlags.Args() {
+		lane := strings.Split(arg, ",")
+		if len(lanes) > 0 && len(lane) != len(lanes[0]) {
+			usageFatalf("%s has %d inputs, but %s has %d\n", arg, len(lane), mergeFlags.Arg(0), len(lanes[0]))
+		}
+		lanes = append(lanes, lane)
+	}
+
+	opts := fqfilter.OutputOptions{
+		MateNames: fqfilter.MateNamesFromFiles(lanes[0]),
+		WriteOptions: safeWrites(fqfilter.WriteOptions{
+			Level:      mergeArgs.GzipLevel,
+			Threads:    mergeArgs.Threads,
+			BufferSize: fqfilter.DefaultBufferSize,
+		}),
+	}
+	output, err := fqfilter.OpenOutput(mergeArgs.OutPrefix, len(lanes[0]), opts)
+	if err != nil {
+		fatal(err)
+	}
+
+	// One lane is read at a time, so only its files are open
+	total := 0
+	mates := make([]fqfilter.Record, len(lanes[0]))
+	for _, lane := range lanes {
+		inputs, readers := openInputs(lane, false, false, mergeArgs.Threads)
+		paired := fqfilter.NewPairedReader(lane, readers)
+		paired.CheckNames = !mergeArgs.NoCheckPairs
+		for {
+			if err := paired.Read(mates); err == io.EOF {
+				break
+			} else if err != nil {
+				inputFatal(err)
+			}
+			if err := output.Write(mates[0].Header, mates); err != nil {
+				fatal(err)
+			}
+		}
+		for i := range inputs {
+			inputs[i].Close()
+		}
+		total += paired.Records()
+		if !mergeArgs.Quiet {
+			log.Printf("%s: %d\n", strings.Join(lane, ","), paired.Records())
+		}
+	}
+
+	if err := output.Close(); err != nil {
+		fatalf("Failed to close output: %v\n", err)
+	}
+	if !mergeArgs.Quiet {
+		log.Println("reads:", total)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"io"
+	"log"
+
+	"github.com/kbullaugheysas/fqfilter"
+)
+
+/* The names command writes the name of every read in its inputs, one per
+ * line, as a reads file for -reads */
+
+type NamesArgs struct {
+	Out             string
+	ShortName       bool
+	StripMate       bool
+	StripMateSuffix bool
+	Unique          bool
+	InFormat        string
+	Threads         int
+}
+
+var namesArgs = NamesArgs{}
+
+var namesFlags = flag.NewFlagSet("names", flag.ExitOnError)
+
+func init() {
+	namesFlags.StringVar(&namesArgs.Out, "out", "-", "file to write the names to, compressed if it ends in .gz or .zst (- for stdout)")
+	namesFlags.BoolVar(&namesArgs.ShortName, "short-name", false, "write just the first space-separated word of each read name")
+	namesFlags.BoolVar(&namesArgs.StripMate, "strip-mate", false, "remove a trailing /1 or /2, or an Illumina 1:N:0: style tag, from read names (after -short-name)")
+	namesFlags.BoolVar(&namesArgs.StripMateSuffix, "strip-mate-suffix", false, "like -strip-mate, but also remove a trailing .1 or .2 (not for SRA names like SRR001.1, where it numbers the spot)")
+	namesFlags.BoolVar(&namesArgs.Unique, "unique", false, "write each name only once, such as when listing both mates of a pair with -strip-mate")
+	namesFlags.StringVar(&namesArgs.InFormat, "in-format", "auto", "the format of the inputs: fastq, fasta, or auto to treat .fa, .fasta and .fna files as FASTA")
+	namesFlags.IntVar(&namesArgs.Threads, "threads", 1, "number of goroutines decompressing each compressed input and compressing a compressed output")
+	addMaxLineFlag(namesFlags)
+	addForceFlag(namesFlags)
+	addLogFlags(namesFlags)
+
+	namesFlags.Usage = func() {
+		log.Println("usage: fqfilter names [options] reads.fq.gz ...")
+		namesFlags.PrintDefaults()
+	}
+}
+
+func runNames(argv []string) {
+	namesFlags.Parse(argv)
+	fq := namesFlags.Args()
+	if len(fq) == 0 {
+		usageFatal("Must specify at least one fastq file")
+	}
+	if namesArgs.InFormat != "auto" && namesArgs.InFormat != "fastq" && namesArgs.InFormat != "fasta" {
+		usageFatal("-in-format must be auto, fastq or fasta")
+	}
+	if namesArgs.Threads < 1 {
+		usageFatal("-threads must be at least 1")
+	}
+
+	var out fqfilter.AmbiWriter
+	opts := safeWrites(fqfilter.WriteOptions{Level: gzip.DefaultCompression, Threads: namesArgs.Threads})
+	if err := out.OpenWith(namesArgs.Out, opts); err != nil {
+		fatalf("Failed to open %s for writing: %v\n", namesArgs.Out, err)
+	}
+
+	norm := fqfilter.Normalizer{
+		ShortName:    namesArgs.ShortName,
+		StripMate:    namesArgs.StripMate || namesArgs.StripMateSuffix,
+		StripMateDot: namesArgs.StripMateSuffix,
+	}
+	seen := make(map[string]bool)
+	var rec fqfilter.Record
+	for _, fn := range fq {
+		fasta := namesArgs.InFormat == "fasta" || namesArgs.InFormat == "auto" && fqfilter.DetectReadsFormat(fn) == fqfilter.ReadsFasta
+		inputs, readers := openInputs([]string{fn}, fasta, false, namesArgs.Threads)
+		for {
+			if err := readers[0].Read(&rec); err == io.EOF {
+				break
+			} else if err != nil {
+				inputFatalf("%s: %v\n", fn, err)
+			}
+			name := norm.Name(rec.Header)
+			if namesArgs.Unique {
+				if seen[name] {
+					continue
+				}
+				seen[name] = true
+			}
+			if _, err := out.WriteString(name + "\n"); err != nil {
+				fatalf("Failed to write %s: %v\n", namesArgs.Out, err)
+			}
+		}
+		inputs[0].Close()
+	}
+	if err := out.Close(); err != nil {
+		fatalf("Failed to write %s: %v\n", namesArgs.Out, err)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"io"
+	"log"
+
+	"github.com/kbullaugheysas/fqfilter"
+)
+
+/* The pair command puts the mates in two FASTQ files back in step, matching
+ * them by name, and writes the mates that have no partner separately */
+
+type PairArgs struct {
+	OutPrefix  string
+	Singletons string
+	GzipLevel  int
+	Threads    int
+	Quiet      bool
+}
+
+var pairArgs = PairArgs{}
+
+var pairFlags = flag.NewFlagSet("pair", flag.ExitOnError)
+
+func init() {
+	pairFlags.StringVar(&pairArgs.OutPrefix, "out", "", "write the matched mates to <out>_1.fq.gz and <out>_2.fq.gz (required)")
+	pairFlags.StringVar(&pairArgs.Singletons, "singletons", "", "write the mates with no partner to <singletons>_1.fq.gz and <singletons>_2.fq.gz (default <out>_singletons)")
+	pairFlags.IntVar(&pairArgs.GzipLevel, "gzip-level", gzip.DefaultCompression, "gzip compression level for output files (1-9, or 0 for uncompressed output)")
+	pairFlags.IntVar(&pairArgs.Threads, "threads", 1, "number of goroutines decompressing each compressed input and compressing each compressed output file")
+	addMaxLineFlag(pairFlags)
+	pairFlags.BoolVar(&pairArgs.Quiet, "quiet", false, "don't log the counts to stderr")
+	addForceFlag(pairFlags)
+	addLogFlags(pairFlags)
+
+	pairFlags.Usage = func() {
+		log.Println("usage: fqfilter pair -out prefix [options] reads_1.fq.gz reads_2.fq.gz")
+		pairFlags.PrintDefaults()
+	}
+}
+
+func runPair(argv []string) {
+	pairFlags.Parse(argv)
+	fq := pairFlags.Args()
+	if len(fq) != 2 {
+		usageFatal("Must specify two fastq files")
+	}
+	if pairArgs.OutPrefix == "" {
+		usageFatal("Must provide an -out prefix")
+	}
+	if pairArgs.Threads < 1 {
+		usageFatal("-threads must be at least 1")
+	}
+	if pairArgs.Singletons == "" {
+		pairArgs.Singletons = pairArgs.OutPrefix + "_singletons"
+	}
+
+	inputs, readers := openInputs(fq, false, false, pairArgs.Threads)
+	for i := range inputs {
+		defer inputs[i].Close()
+	}
+	opts := fqfilter.OutputOptions{
+		WriteOptions: safeWrites(fqfilter.WriteOptions{
+			Level:      pairArgs.GzipLevel,
+			Threads:    pairArgs.Threads,
+			BufferSize: fqfilter.DefaultBufferSize,
+		}),
+	}
+	output, err := fqfilter.OpenOutput(pairArgs.OutPrefix, 2, opts)
+	if err != nil {
+		fatal(err)
+	}
+	singletons, err := fqfilter.OpenOutput(pairArgs.Singletons, 2, opts)
+	if err != nil {
+		fatal(err)
+	}
+
+	// Take a record from each input in turn, until both have ended
+	repairer := fqfilter.NewRepairer()
+	pair := make([]fqfilter.Record, 2)
+	done := []bool{false, false}
+	pairs := 0
+	for !done[0] || !done[1] {
+		for i, r := range readers {
+			if done[i] {
+				continue
+			}
+			var rec fqfilter.Record
+			if err := r.Read(&rec); err == io.EOF {
+				done[i] = true
+				continue
+			} else if err != nil {
+				inputFatalf("%s: %v\n", fq[i], err)
+			}
+			if repairer.Add(i, rec, pair) {
+				pairs++
+				if err := output.Write(pair[0].Header, pair); err != nil {
+					fatal(err)
+				}
+			}
+		}
+	}
+
+	orphans := []int{0, 0}
+	for i := range orphans {
+		for _, rec := range repairer.Orphans(i) {
+			orphans[i]++
+			if err := singletons.WriteMate(i, &rec); err != nil {
+				fatal(err)
+			}
+		}
+	}
+
+	if err := output.Close(); err != nil {
+		fatalf("Failed to close output: %v\n", err)
+	}
+	if err := singletons.Close(); err != nil {
+		fatalf("Failed to close singletons output: %v\n", err)
+	}
+
+	if !pairArgs.Quiet {
+		log.Println("pairs:", pairs)
+		log.Printf("singletons in %s: %d\n", fq[0], orphans[0])
+		log.Printf("singletons in %s: %d\n", fq[1], orphans[1])
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"plugin"
+	"strings"
+
+	"github.com/kbullaugheysas/fqfilter"
+)
+
+/* Load a -plugin, given as file.so or file.so=arg, and make its filter. The
+ * plugin must be built with go build -buildmode=plugin against the same
+ * fqfilter as this binary, and export NewFilter as a
+ * fqfilter.PluginConstructor. */
+func loadPlugin(spec string) (fqfilter.RecordFilter, error) {
+	fn, arg, _ := strings.Cut(spec, "=")
+	p, err := plugin.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup("NewFilter")
+	if err != nil {
+		return nil, err
+	}
+	newFilter, ok := sym.(fqfilter.PluginConstructor)
+	if !ok {
+		return nil, fmt.Errorf("%s exports NewFilter, but not as a func(string) (fqfilter.RecordFilter, error)", fn)
+	}
+	return newFilter(arg)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kbullaugheysas/fqfilter"
+)
+
+/* Periodically logs how far the main loop has got. The loop publishes its
+ * counts with Update and the ticker goroutine reads them. The throughput, and
+ * when the inputs are files of known size, the percent done and an ETA, come
+ * from the (compressed) bytes read from the inputs. */
+type Progress struct {
+	records  atomic.Int64
+	included atomic.Int64
+	excluded atomic.Int64
+	inputs   []fqfilter.AmbiReader
+	size     int64
+	start    time.Time
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func StartProgress(interval time.Duration, inputs []fqfilter.AmbiReader) *Progress {
+	p := &Progress{inputs: inputs, start: time.Now(), done: make(chan struct{})}
+	for i := range inputs {
+		if inputs[i].Size() == 0 {
+			// Without the size of every input we can't say how far we are
+			p.size = 0
+			break
+		}
+		p.size += inputs[i].Size()
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.report()
+			case <-p.done:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+func (p *Progress) Update(records, included, excluded int) {
+	p.records.Store(int64(records))
+	p.included.Store(int64(included))
+	p.excluded.Store(int64(excluded))
+}
+
+func (p *Progress) report() {
+	processed := p.records.Load()
+	included := p.included.Load()
+	excluded := p.excluded.Load()
+	elapsed := time.Since(p.start).Seconds()
+	var read int64
+	for i := range p.inputs {
+		read += p.inputs[i].BytesRead()
+	}
+	rate := float64(processed) / elapsed
+	mbRate := float64(read) / 1e6 / elapsed
+	msg := fmt.Sprintf("processed %d reads (included %d, excluded %d), %.0f reads/s, %.1f MB/s", processed, included, excluded, rate, mbRate)
+	if p.size > 0 && read > 0 {
+		done := float64(read) / float64(p.size)
+		eta := time.Duration(elapsed * (1 - done) / done * float64(time.Second))
+		msg += fmt.Sprintf(", %.1f%% done, ETA %v", 100*done, eta.Round(time.Second))
+	}
+	logs.emit(levelProgress, msg, map[string]any{"processed": processed, "included": included, "excluded": excluded, "bytes_read": read, "elapsed_seconds": elapsed})
+}
+
+/* Stop the ticker and wait for the goroutine to exit, so nothing is logged
+ * after the final summary */
+func (p *Progress) Stop() {
+	close(p.done)
+	p.wg.Wait()
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"strings"
+
+	"github.com/kbullaugheysas/fqfilter"
+)
+
+/* The -qc-report files: the table of each mate's cycles, and a page plotting
+ * them, much as FastQC plots the per base quality and sequence content */
+func writeQCReport(prefix string, mates []*fqfilter.CycleStats) error {
+	fp, err := os.Create(prefix + ".qc.tsv")
+	if err != nil {
+		return err
+	}
+	if err := fqfilter.WriteCycleTable(fp, mates); err != nil {
+		fp.Close()
+		return err
+	}
+	if err := fp.Close(); err != nil {
+		return err
+	}
+	var page strings.Builder
+	fmt.Fprintf(&page, "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>fqfilter QC: %s</title></head>\n<body style=\"font-family: sans-serif\">\n", html.EscapeString(prefix))
+	for m, s := range mates {
+		summary := s.Summary()
+		fmt.Fprintf(&page, "<h2>Mate %d</h2>\n", m+1)
+		if len(summary) == 0 {
+			page.WriteString("<p>No reads were kept.</p>\n")
+			continue
+		}
+		page.WriteString("<h3>Quality by cycle</h3>\n")
+		page.WriteString("<p>The band spans the lower to upper quartile, the black line is the median and the red line the mean.</p>\n")
+		qualityPlot(&page, summary)
+		page.WriteString("<h3>Base composition by cycle</h3>\n")
+		page.WriteString("<p>The percentage of <span style=\"color:#2a2\">A</span>, <span style=\"color:#22c\">C</span>, <span style=\"color:#000\">G</span>, <span style=\"color:#c22\">T</span> and <span style=\"color:#999\">N</span> at each position.</p>\n")
+		compositionPlot(&page, summary)
+	}
+	page.WriteString("</body></html>\n")
+	return os.WriteFile(prefix+".qc.html", []byte(page.String()), 0666)
+}
+
+const (
+	plotWidth  = 800
+	plotHeight = 300
+	plotMargin = 40
+)
+
+/* An SVG plot with a y axis from 0 to top, into which draw adds the lines,
+ * given where each cycle and value go */
+func svgPlot(page *strings.Builder, cycles int, top float64, label string, draw func(x func(int) float64, y func(float64) float64)) {
+	x := func(cycle int) float64 {
+		return plotMargin + float64(cycle-1)*(plotWidth-2*plotMargin)/float64(max(cycles-1, 1))
+	}
+	y := func(v float64) float64 {
+		return plotHeight - plotMargin - v*(plotHeight-2*plotMargin)/top
+	}
+	fmt.Fprintf(page, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" font-size=\"11\">\n", plotWidth, plotHeight)
+	fmt.Fprintf(page, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"none\" stroke=\"#ccc\"/>\n", plotMargin, plotMargin, plotWidth-2*plotMargin, plotHeight-2*plotMargin)
+	for _, v := range []float64{0, top / 4, top / 2, 3 * top / 4, top} {
+		fmt.Fprintf(page, "<text x=\"%d\" y=\"%.1f\" text-anchor=\"end\">%g</text>\n", plotMargin-4, y(v)+4, v)
+	}
+	for _, c := range []int{1, (cycles + 1) / 2, cycles} {
+		fmt.Fprintf(page, "<text x=\"%.1f\" y=\"%d\" text-anchor=\"middle\">%d</text>\n", x(c), plotHeight-plotMargin+14, c)
+	}
+	fmt.Fprintf(page, "<text x=\"%d\" y=\"%d\" text-anchor=\"middle\">cycle</text>\n", plotWidth/2, plotHeight-6)
+	fmt.Fprintf(page, "<text x=\"12\" y=\"%d\" transform=\"rotate(-90 12 %d)\" text-anchor=\"middle\">%s</text>\n", plotHeight/2, plotHeight/2, label)
+	draw(x, y)
+	page.WriteString("</svg>\n")
+}
+
+/* A polyline through the value at each cycle */
+func svgLine(page *strings.Builder, color string, points []string) {
+	fmt.Fprintf(page, "<polyline fill=\"none\" stroke=\"%s\" stroke-width=\"1.5\" points=\"%s\"/>\n", color, strings.Join(points, " "))
+}
+
+func qualityPlot(page *strings.Builder, summary []fqfilter.CycleSummary) {
+	top := 10.0
+	for _, c := range summary {
+		for top < float64(c.UpperQuart) {
+			top += 10
+		}
+	}
+	svgPlot(page, len(summary), top, "quality", func(x func(int) float64, y func(float64) float64) {
+		var band, median, mean []string
+		point := func(c int, v float64) string {
+			return fmt.Sprintf("%.1f,%.1f", x(c), y(v))
+		}
+		for _, c := range summary {
+			band = append(band, point(c.Cycle, float64(c.UpperQuart)))
+			median = append(median, point(c.Cycle, float64(c.Median)))
+			mean = append(mean, point(c.Cycle, c.MeanQuality))
+		}
+		for i := len(summary) - 1; i >= 0; i-- {
+			band = append(band, point(summary[i].Cycle, float64(summary[i].LowerQuart)))
+		}
+		fmt.Fprintf(page, "<polygon fill=\"#fd6\" stroke=\"none\" points=\"%s\"/>\n", strings.Join(band, " "))
+		svgLine(page, "#000", median)
+		svgLine(page, "#c22", mean)
+	})
+}
+
+func compositionPlot(page *strings.Builder, summary []fqfilter.CycleSummary) {
+	svgPlot(page, len(summary), 100, "% of bases", func(x func(int) float64, y func(float64) float64) {
+		lines := make([][]string, 5)
+		for _, c := range summary {
+			for i, v := range []float64{c.A, c.C, c.G, c.T, c.N} {
+				lines[i] = append(lines[i], fmt.Sprintf("%.1f,%.1f", x(c.Cycle), y(v)))
+			}
+		}
+		for i, color := range []string{"#2a2", "#22c", "#000", "#c22", "#999"} {
+			svgLine(page, color, lines[i])
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import "github.com/kbullaugheysas/fqfilter"
+
+/* Where each operation a -config step can ask for comes in the single pass
+ * filter makes over the reads, as Filter.Apply orders them. Steps have to be
+ * listed in this order, since they can't be run in any other. Options that
+ * aren't here, like -out or -qual-offset, can go anywhere. */
+var stepOrder = map[string]int{
+	"skip": 1, "take": 1, "limit-records": 1, "range": 1,
+	"reads": 2, "reads-bam": 2, "bam": 2, "name": 2, "name-regex": 2, "invert": 2, "barcode-whitelist": 2,
+	"umi-len": 3,
+	"crop":    4, "crop1": 4, "crop2": 4, "headcrop": 4, "headcrop1": 4, "headcrop2": 4,
+	"adapter":    5,
+	"trim-polyx": 6,
+	"trim-qual":  7,
+	"min-length": 8, "min-len": 8, "max-length": 8, "max-len": 8,
+	"min-mean-qual": 9, "min-base-qual": 9, "max-low-qual-frac": 9,
+	"max-n":          10,
+	"min-complexity": 11,
+	"min-gc":         12, "max-gc": 12,
+	"filter":          13,
+	"seq-match":       14,
+	"contains":        15,
+	"contaminants":    16,
+	"plugin":          17,
+	"sample-fraction": 18, "fraction": 18, "every": 18,
+	"dedup":    19,
+	"sample-n": 20, "sample": 20, "trim": 20,
+	"revcomp": 21,
+	"limit":   22, "limit-excluded": 22, "limit-per-output": 22,
+}
+
+/* Set the filter options from a -config recipe, except any given on the
+ * command line, which win. Returns the inputs to use: the arguments, or
+ * failing those, the recipe's inputs setting. */
+func applyRecipe(fn string, given map[string]bool, inputs []string) []string {
+	recipe, err := fqfilter.LoadRecipe(fn)
+	if err != nil {
+		usageFatalf("Failed to read -config %s: %v\n", fn, err)
+	}
+	set := func(s fqfilter.RecipeSetting) {
+		if s.Key == "inputs" {
+			if len(inputs) == 0 {
+				inputs = s.Values
+			}
+			return
+		}
+		f := filterFlags.Lookup(s.Key)
+		if f == nil || s.Key == "config" {
+			usageFatalf("%s line %d: %s is not a filter option\n", fn, s.Line, s.Key)
+		}
+		if given[s.Key] {
+			return
+		}
+		for _, v := range s.Values {
+			if err := filterFlags.Set(s.Key, v); err != nil {
+				usageFatalf("%s line %d: invalid %s %s: %v\n", fn, s.Line, s.Key, v, err)
+			}
+		}
+	}
+	for _, s := range recipe.Settings {
+		set(s)
+	}
+	// The latest operation of the steps so far, which the next must not
+	// come before
+	last, lastKey, lastStep := 0, "", 0
+	for i, step := range recipe.Steps {
+		if len(step) == 0 {
+			usageFatalf("%s: step %d is empty\n", fn, i+1)
+		}
+		for _, s := range step {
+			if order := stepOrder[s.Key]; order > 0 {
+				if order < last && lastStep != i+1 {
+					usageFatalf("%s line %d: step %d (%s) has to come before step %d (%s), since that is the order filter runs them in\n", fn, s.Line, i+1, s.Key, lastStep, lastKey)
+				}
+				if order > last {
+					last, lastKey, lastStep = order, s.Key, i+1
+				}
+			}
+			set(s)
+		}
+	}
+	return inputs
+}
@@ -0,0 +1,21 @@
+package main
+
+/* The sample and trim commands are filter under another name, so that
+ * fqfilter sample -sample-n 1000 R1.fq R2.fq says what it does. They take
+ * all of filter's options, and fail unless one that samples, or trims, is
+ * given. Without a name list, every read is sampled or trimmed. */
+func runSample(argv []string) {
+	filterWith(argv, func() {
+		if args.Sample == 0 && args.Fraction == 0 && args.Every == 0 {
+			usageFatal("sample needs -sample-n, -sample-fraction or -every")
+		}
+	})
+}
+
+func runTrim(argv []string) {
+	filterWith(argv, func() {
+		if len(args.Adapters) == 0 && args.TrimQual == 0 && args.TrimPolyX == "" && !cropping() && args.Trim == 0 {
+			usageFatal("trim needs -adapter, -trim-qual, -trim-polyx, -crop, -headcrop or -trim")
+		}
+	})
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kbullaugheysas/fqfilter"
+)
+
+/* The serve command loads the names once and then filters reads sent to it
+ * over HTTP, so jobs against the same big list don't each wait for it to
+ * load. POST FASTQ (compressed or not) to /filter and the selected reads come
+ * back, with the counts in the Fqfilter-Included and Fqfilter-Excluded
+ * trailers. The query can ask for invert=1, interleaved=1 (the body holds
+ * the mates of each read one after another) and gzip=1. GET /status reports
+ * on the names and the reads filtered so far. */
+
+type ServeArgs struct {
+	Listen          string
+	ReadsFilenames  StringList
+	ReadsFormat     string
+	ShortName       bool
+	StripMate       bool
+	StripMateSuffix bool
+	IgnoreCase      bool
+	HashSet         bool
+	Quiet           bool
+}
+
+var serveArgs = ServeArgs{}
+
+var serveFlags = flag.NewFlagSet("serve", flag.ExitOnError)
+
+func init() {
+	serveFlags.StringVar(&serveArgs.Listen, "listen", "localhost:8080", "the address to listen on; give :8080 to listen beyond this machine")
+	serveFlags.Var(&serveArgs.ReadsFilenames, "reads", "file of read names to match, or of reads or alignments whose names to match (may be repeated, required)")
+	serveFlags.StringVar(&serveArgs.ReadsFormat, "reads-format", "auto", "what the -reads files hold: names, fastq, fasta, sam or bam, or auto to go by each file's suffix")
+	serveFlags.BoolVar(&serveArgs.ShortName, "short-name", false, "match only the first space-separated word of each read name")
+	serveFlags.BoolVar(&serveArgs.StripMate, "strip-mate", false, "remove a trailing /1 or /2, or an Illumina 1:N:0: style tag, from read names before matching")
+	serveFlags.BoolVar(&serveArgs.StripMateSuffix, "strip-mate-suffix", false, "like -strip-mate, but also remove a trailing .1 or .2 (not for SRA names like SRR001.1, where it numbers the spot)")
+	serveFlags.BoolVar(&serveArgs.IgnoreCase, "ignore-case", false, "match read names regardless of case")
+	serveFlags.BoolVar(&serveArgs.HashSet, "hash-set", false, "keep 8-byte hashes of the names rather than the names themselves, to save memory with a huge list (a false match is very unlikely, but possible)")
+	addMaxLineFlag(serveFlags)
+	serveFlags.BoolVar(&serveArgs.Quiet, "quiet", false, "don't log each request to stderr")
+	addLogFlags(serveFlags)
+
+	serveFlags.Usage = func() {
+		log.Println("usage: fqfilter serve -reads names.txt [options]")
+		log.Println("Then, for example: curl --data-binary @reads.fq.gz 'http://localhost:8080/filter?gzip=1' > kept.fq.gz")
+		serveFlags.PrintDefaults()
+	}
+}
+
+/* A NameSet shared by requests served at the same time. Contains notes the
+ * names that match, so even lookups take the lock. */
+type lockedSet struct {
+	sync.Mutex
+	set fqfilter.NameSet
+}
+
+func (s *lockedSet) Add(entry string) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.set.Add(entry)
+}
+
+func (s *lockedSet) Contains(name string) bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.set.Contains(name)
+}
+
+func (s *lockedSet) Len() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.set.Len()
+}
+
+func (s *lockedSet) Matched() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.set.Matched()
+}
+
+func (s *lockedSet) Unmatched() ([]string, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.set.Unmatched()
+}
+
+/* What GET /status reports */
+type serveStatus struct {
+	Names    int     `json:"names"`
+	Matched  int     `json:"names_matched"`
+	Requests int64   `json:"requests"`
+	Reads    int64   `json:"reads"`
+	Included int64   `json:"included"`
+	Uptime   float64 `json:"uptime_seconds"`
+}
+
+type server struct {
+	names    *lockedSet
+	norm     fqfilter.Normalizer
+	start    time.Time
+	requests atomic.Int64
+	reads    atomic.Int64
+	included atomic.Int64
+}
+
+func runServe(argv []string) {
+	serveFlags.Parse(argv)
+	if serveFlags.NArg() > 0 {
+		usageFatal("serve takes no arguments; the reads to filter are sent to it")
+	}
+	if len(serveArgs.ReadsFilenames) == 0 {
+		usageFatal("Must provide -reads <file>")
+	}
+	var set fqfilter.NameSet = make(fqfilter.ExactSet)
+	if serveArgs.HashSet {
+		set = &fqfilter.HashSet{}
+	}
+	srv := &server{
+		names: &lockedSet{set: set},
+		norm: fqfilter.Normalizer{
+			ShortName:    serveArgs.ShortName,
+			StripMate:    serveArgs.StripMate || serveArgs.StripMateSuffix,
+			StripMateDot: serveArgs.StripMateSuffix,
+			IgnoreCase:   serveArgs.IgnoreCase,
+		},
+	}
+	for _, fn := range serveArgs.ReadsFilenames {
+		format := fqfilter.ReadsFormat(serveArgs.ReadsFormat)
+		if serveArgs.ReadsFormat == "auto" {
+			format = fqfilter.DetectReadsFormat(fn)
+		}
+		n, err := fqfilter.LoadReads(set, fn, format, srv.norm, fqfilter.BamFlags{}, maxLineBytes)
+		if err != nil {
+			fatalf("Failed to load %s: %v\n", fn, err)
+		}
+		log.Printf("read %d names from %s\n", n, fn)
+	}
+	log.Printf("loaded %d unique names; listening on %s\n", set.Len(), serveArgs.Listen)
+
+	srv.start = time.Now()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/filter", srv.filter)
+	mux.HandleFunc("/status", srv.status)
+	fatal(http.ListenAndServe(serveArgs.Listen, mux))
+}
+
+func (s *server) status(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(serveStatus{
+		Names:    s.names.Len(),
+		Matched:  s.names.Matched(),
+		Requests: s.requests.Load(),
+		Reads:    s.reads.Load(),
+		Included: s.included.Load(),
+		Uptime:   time.Since(s.start).Seconds(),
+	})
+}
+
+/* Filter the reads in the request body, streaming the selected ones back */
+func (s *server) filter(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST the reads to filter", http.StatusMethodNotAllowed)
+		return
+	}
+	s.requests.Add(1)
+	start := time.Now()
+	query := r.URL.Query()
+	var input fqfilter.AmbiReader
+	if err := input.OpenReader(r.Body, fqfilter.ReadOptions{Threads: 1}); err != nil {
+		http.Error(w, "Failed to read the reads: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer input.Close()
+	fastq := fqfilter.NewFastqReader(input)
+	fastq.SetMaxLineBytes(maxLineBytes)
+	paired := fqfilter.NewPairedReader([]string{"request"}, []fqfilter.RecordReader{fastq})
+	if query.Get("interleaved") == "1" {
+		paired = fqfilter.NewInterleavedReader("request", fastq)
+	}
+	selector := fqfilter.Filter{Names: s.names, Normalizer: s.norm, Invert: query.Get("invert") == "1"}
+
+	w.Header().Set("Trailer", "Fqfilter-Included, Fqfilter-Excluded, Fqfilter-Error")
+	w.Header().Set("Content-Type", "text/plain")
+	var out io.Writer = w
+	var gz *gzip.Writer
+	if query.Get("gzip") == "1" {
+		w.Header().Set("Content-Type", "application/gzip")
+		gz = gzip.NewWriter(w)
+		out = gz
+	}
+	bw := bufio.NewWriterSize(out, fqfilter.DefaultBufferSize)
+	mates := make([]fqfilter.Record, paired.Mates())
+	included, excluded := 0, 0
+	var readErr error
+	for {
+		if err := paired.Read(mates); err == io.EOF {
+			break
+		} else if err != nil {
+			readErr = err
+			break
+		}
+		res, err := selector.Apply(mates)
+		if err != nil {
+			readErr = err
+			break
+		}
+		if res.Decision != fqfilter.Included {
+			excluded++
+			continue
+		}
+		included++
+		for i := range mates {
+			rec := &mates[i]
+			for _, part := range []string{"@", rec.Header, "\n", rec.Sequence, "\n", rec.Plus, "\n", rec.Quality, "\n"} {
+				bw.WriteString(part)
+			}
+		}
+	}
+	err := bw.Flush()
+	if gz != nil && err == nil {
+		err = gz.Close()
+	}
+	if readErr == nil {
+		readErr = err
+	}
+	s.reads.Add(int64(included + excluded))
+	s.included.Add(int64(included))
+	w.Header().Set("Fqfilter-Included", strconv.Itoa(included))
+	w.Header().Set("Fqfilter-Excluded", strconv.Itoa(excluded))
+	if readErr != nil {
+		w.Header().Set("Fqfilter-Error", strings.TrimSpace(readErr.Error()))
+	}
+	if !serveArgs.Quiet {
+		if readErr != nil {
+			log.Printf("%s: failed after %d reads: %s\n", r.RemoteAddr, included+excluded, strings.TrimSpace(readErr.Error()))
+		} else {
+			log.Printf("%s: included %d of %d reads in %.1fs\n", r.RemoteAddr, included, included+excluded, time.Since(start).Seconds())
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/kbullaugheysas/fqfilter"
+)
+
+/* The stats command summarizes the reads in each input: counts, lengths,
+ * qualities and composition */
+
+type StatsArgs struct {
+	JSON       string
+	Histogram  bool
+	InFormat   string
+	QualOffset int
+	Threads    int
+}
+
+var statsArgs = StatsArgs{}
+
+var statsFlags = flag.NewFlagSet("stats", flag.ExitOnError)
+
+func init() {
+	statsFlags.StringVar(&statsArgs.JSON, "json", "", "also write the summaries as JSON to this file (- for stdout, in place of the table)")
+	statsFlags.BoolVar(&statsArgs.Histogram, "histogram", false, "also print the number of reads of each length")
+	statsFlags.StringVar(&statsArgs.InFormat, "in-format", "auto", "the format of the inputs: fastq, fasta, or auto to treat .fa, .fasta and .fna files as FASTA")
+	statsFlags.IntVar(&statsArgs.QualOffset, "qual-offset", fqfilter.DefaultQualOffset, "the ASCII offset of quality scores (33, or 64 for old Illumina files)")
+	statsFlags.IntVar(&statsArgs.Threads, "threads", 1, "number of goroutines decompressing each compressed input")
+	addMaxLineFlag(statsFlags)
+	addLogFlags(statsFlags)
+
+	statsFlags.Usage = func() {
+		log.Println("usage: fqfilter stats [options] reads.fq.gz ...")
+		statsFlags.PrintDefaults()
+	}
+}
+
+/* The summary of one input, as written by -json */
+type FileStats struct {
+	File          string                 `json:"file"`
+	Reads         int                    `json:"reads"`
+	Bases         int                    `json:"bases"`
+	MinLength     int                    `json:"min_length"`
+	MeanLength    float64                `json:"mean_length"`
+	MaxLength     int                    `json:"max_length"`
+	MeanQuality   float64                `json:"mean_quality"`
+	MedianQuality int                    `json:"median_quality"`
+	GCPercent     float64                `json:"gc_percent"`
+	NPercent      float64                `json:"n_percent"`
+	Lengths       []fqfilter.LengthCount `json:"lengths"`
+}
+
+/* Read one input through, summarizing it */
+func fileStats(fn string, reader fqfilter.RecordReader) FileStats {
+	s := fqfilter.NewReadStats(statsArgs.QualOffset)
+	var rec fqfilter.Record
+	for {
+		if err := reader.Read(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			inputFatalf("%s: %v\n", fn, err)
+		}
+		s.Add(&rec)
+	}
+	return FileStats{
+		File:          fn,
+		Reads:         s.Reads,
+		Bases:         s.Bases,
+		MinLength:     s.MinLength,
+		MeanLength:    s.MeanLength(),
+		MaxLength:     s.MaxLength,
+		MeanQuality:   s.MeanQuality(),
+		MedianQuality: s.MedianQuality(),
+		GCPercent:     s.GCPercent(),
+		NPercent:      s.NPercent(),
+		Lengths:       s.Histogram(),
+	}
+}
+
+func printStats(w io.Writer, all []FileStats) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "file\treads\tbases\tmin len\tmean len\tmax len\tmean qual\tmedian qual\tGC %\tN %\t")
+	for _, s := range all {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%.1f\t%d\t%.1f\t%d\t%.1f\t%.2f\t\n", s.File, s.Reads, s.Bases, s.MinLength, s.MeanLength, s.MaxLength, s.MeanQuality, s.MedianQuality, s.GCPercent, s.NPercent)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if !statsArgs.Histogram {
+		return nil
+	}
+	for _, s := range all {
+		fmt.Fprintf(w, "\n%s\n", s.File)
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "length\treads\t")
+		for _, h := range s.Lengths {
+			fmt.Fprintf(tw, "%d\t%d\t\n", h.Length, h.Reads)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeStatsJSON(fn string, all []FileStats) error {
+	w := io.Writer(os.Stdout)
+	if fn != "-" {
+		fp, err := os.Create(fn)
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		w = fp
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(all)
+}
+
+func runStats(argv []string) {
+	statsFlags.Parse(argv)
+	fq := statsFlags.Args()
+	if len(fq) == 0 {
+		usageFatal("Must specify at least one fastq file")
+	}
+	if statsArgs.InFormat != "auto" && statsArgs.InFormat != "fastq" && statsArgs.InFormat != "fasta" {
+		usageFatal("-in-format must be auto, fastq or fasta")
+	}
+	if statsArgs.QualOffset <= 0 {
+		usageFatal("-qual-offset must be positive")
+	}
+
+	var all []FileStats
+	for _, fn := range fq {
+		fasta := statsArgs.InFormat == "fasta" || statsArgs.InFormat == "auto" && fqfilter.DetectReadsFormat(fn) == fqfilter.ReadsFasta
+		inputs, readers := openInputs([]string{fn}, fasta, false, statsArgs.Threads)
+		all = append(all, fileStats(fn, readers[0]))
+		inputs[0].Close()
+	}
+
+	if statsArgs.JSON != "" {
+		if err := writeStatsJSON(statsArgs.JSON, all); err != nil {
+			fatalf("Failed to write %s: %v\n", statsArgs.JSON, err)
+		}
+	}
+	if statsArgs.JSON != "-" {
+		if err := printStats(os.Stdout, all); err != nil {
+			fatal(err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"io"
+	"log"
+	"strconv"
+
+	"github.com/kbullaugheysas/fqfilter"
+)
+
+/* The untab command turns the tabular output of -tab back into FASTQ, one
+ * file per mate, so a table edited in between can be used as reads again */
+
+type UntabArgs struct {
+	OutPrefix  string
+	GzipLevel  int
+	FillQual   string
+	MateSuffix bool
+	Threads    int
+	Quiet      bool
+}
+
+var untabArgs = UntabArgs{}
+
+var untabFlags = flag.NewFlagSet("untab", flag.ExitOnError)
+
+func init() {
+	untabFlags.StringVar(&untabArgs.OutPrefix, "out", "", "write the mates to <out>_1.fq.gz, <out>_2.fq.gz and so on, or <out>_R1.fq.gz for a table with seq_R1 columns (default: interleaved to stdout)")
+	untabFlags.IntVar(&untabArgs.GzipLevel, "gzip-level", gzip.DefaultCompression, "gzip compression level for output files (1-9, or 0 for uncompressed output)")
+	untabFlags.StringVar(&untabArgs.FillQual, "fill-qual", "I", "the quality character to give every base of a table without qualities")
+	untabFlags.BoolVar(&untabArgs.MateSuffix, "mate-suffix", false, "end each mate's name with /1, /2 and so on, as some tools want")
+	untabFlags.IntVar(&untabArgs.Threads, "threads", 1, "number of goroutines decompressing a compressed input and compressing each compressed output file")
+	addMaxLineFlag(untabFlags)
+	untabFlags.BoolVar(&untabArgs.Quiet, "quiet", false, "don't log the number of reads to stderr")
+	addForceFlag(untabFlags)
+	addLogFlags(untabFlags)
+
+	untabFlags.Usage = func() {
+		log.Println("usage: fqfilter untab [options] reads.tsv.gz")
+		log.Println("The table is laid out as -tab writes it: a name, then each mate's sequence, each followed by its quality with -tab-qual.")
+		untabFlags.PrintDefaults()
+	}
+}
+
+func runUntab(argv []string) {
+	untabFlags.Parse(argv)
+	if untabFlags.NArg() != 1 {
+		usageFatal("Must specify one table to read")
+	}
+	if len(untabArgs.FillQual) != 1 || untabArgs.FillQual[0] < '!' || untabArgs.FillQual[0] > '~' {
+		usageFatal("-fill-qual must be a single printable character")
+	}
+	if untabArgs.Threads < 1 {
+		usageFatal("-threads must be at least 1")
+	}
+	fn := untabFlags.Arg(0)
+	src := fn
+	if src == "-" {
+		src = ""
+	}
+	var input fqfilter.AmbiReader
+	if err := input.OpenWith(src, fqfilter.ReadOptions{Threads: untabArgs.Threads}); err != nil {
+		fatalf("Failed to open %s: %v\n", fn, err)
+	}
+	defer input.Close()
+	table := fqfilter.NewTabReader(input)
+	table.FillQuality = untabArgs.FillQual[0]
+	table.SetMaxLineBytes(maxLineBytes)
+	if err := table.Start(); err != nil {
+		inputFatalf("Failed to read %s: %v", fn, err)
+	}
+	if !table.Qualities() && !untabArgs.Quiet {
+		log.Printf("%s has no qualities, so every base is given %s\n", fn, untabArgs.FillQual)
+	}
+
+	opts := fqfilter.OutputOptions{
+		MateNames: table.MateNames(),
+		WriteOptions: safeWrites(fqfilter.WriteOptions{
+			Level:      untabArgs.GzipLevel,
+			Threads:    untabArgs.Threads,
+			BufferSize: fqfilter.DefaultBufferSize,
+		}),
+	}
+	output, err := fqfilter.OpenOutput(untabArgs.OutPrefix, table.Mates(), opts)
+	if err != nil {
+		fatal(err)
+	}
+
+	reads := 0
+	mates := make([]fqfilter.Record, table.Mates())
+	for {
+		if err := table.Read(mates); err == io.EOF {
+			break
+		} else if err != nil {
+			inputFatalf("Failed to read %s: %v", fn, err)
+		}
+		if untabArgs.MateSuffix && len(mates) > 1 {
+			for i := range mates {
+				mates[i].Header += "/" + strconv.Itoa(i+1)
+			}
+		}
+		if err := output.Write(mates[0].Header, mates); err != nil {
+			fatal(err)
+		}
+		reads++
+	}
+
+	if err := output.Close(); err != nil {
+		fatalf("Failed to close output: %v\n", err)
+	}
+	if !untabArgs.Quiet {
+		log.Println("reads:", reads)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/kbullaugheysas/fqfilter"
+)
+
+/* The validate command checks that FASTQ files are well formed, and that the
+ * mates of paired files stay in step, reporting the first problems found
+ * with where they are */
+
+type ValidateArgs struct {
+	MaxProblems  int
+	QualOffset   int
+	NoCheckPairs bool
+	Threads      int
+	Quiet        bool
+}
+
+var validateArgs = ValidateArgs{}
+
+var validateFlags = flag.NewFlagSet("validate", flag.ExitOnError)
+
+func init() {
+	validateFlags.IntVar(&validateArgs.MaxProblems, "max-problems", 10, "report at most this many problems (all of them are still counted)")
+	validateFlags.IntVar(&validateArgs.QualOffset, "qual-offset", 0, "the ASCII offset qualities must be at or above (33 or 64), or 0 to allow any printable character and report the encoding each file seems to use")
+	validateFlags.BoolVar(&validateArgs.NoCheckPairs, "no-check-pairs", false, "don't check that the mates of each read have the same name, only that the inputs hold the same number of records")
+	validateFlags.IntVar(&validateArgs.Threads, "threads", 1, "number of goroutines decompressing each compressed input")
+	addMaxLineFlag(validateFlags)
+	validateFlags.BoolVar(&validateArgs.Quiet, "quiet", false, "don't log the number of records and the quality encoding of each input to stderr")
+	addLogFlags(validateFlags)
+
+	validateFlags.Usage = func() {
+		log.Println("usage: fqfilter validate [options] reads_1.fq.gz [reads_2.fq.gz ...]")
+		log.Println("Problems are written to stdout, and the exit status is 3 if there are any.")
+		validateFlags.PrintDefaults()
+	}
+}
+
+/* Counts the problems found, reporting the first few */
+type problemLog struct {
+	max   int
+	count int
+}
+
+func (p *problemLog) add(fn string, line int, format string, v ...interface{}) {
+	p.count++
+	if p.count > p.max {
+		return
+	}
+	where := fn
+	if line > 0 {
+		where = fmt.Sprintf("%s:%d", fn, line)
+	}
+	fmt.Printf("%s: %s\n", where, fmt.Sprintf(format, v...))
+}
+
+func runValidate(argv []string) {
+	validateFlags.Parse(argv)
+	fq := validateFlags.Args()
+	if len(fq) == 0 {
+		usageFatal("Must specify at least one fastq file")
+	}
+	if validateArgs.QualOffset != 0 && validateArgs.QualOffset != 33 && validateArgs.QualOffset != 64 {
+		usageFatal("-qual-offset must be 33, 64 or 0")
+	}
+	if validateArgs.Threads < 1 {
+		usageFatal("-threads must be at least 1")
+	}
+
+	inputs, readers := openInputs(fq, false, false, validateArgs.Threads)
+	fastqs := make([]*fqfilter.FastqReader, len(fq))
+	for i := range readers {
+		fastqs[i] = readers[i].(*fqfilter.FastqReader)
+	}
+	problems := problemLog{max: validateArgs.MaxProblems}
+	validator := fqfilter.Validator{QualOffset: validateArgs.QualOffset}
+	records := make([]int, len(fq))
+	ranges := make([]fqfilter.QualityRange, len(fq))
+	mates := make([]fqfilter.Record, len(fq))
+	// An input is done once it ends, or has a problem the reader can't get
+	// past. The mates are compared for as long as they are all in step.
+	done := make([]bool, len(fq))
+	inStep := true
+	for remaining := len(fq); remaining > 0; {
+		read := 0
+		for i, fastq := range fastqs {
+			if done[i] {
+				continue
+			}
+			err := fastq.Read(&mates[i])
+			if err == io.EOF {
+				done[i] = true
+				remaining--
+				continue
+			} else if err != nil {
+				problems.add(fq[i], fastq.Line(), "record %d: %s; the rest of the file can't be checked", records[i]+1, strings.TrimSpace(err.Error()))
+				done[i] = true
+				remaining--
+				inStep = false
+				continue
+			}
+			records[i]++
+			read++
+			line := fastq.Line() - 3
+			for _, problem := range validator.Check(&mates[i]) {
+				problems.add(fq[i], line, "record %d: %s", records[i], problem)
+			}
+			ranges[i].Add(mates[i].Quality)
+		}
+		if !inStep || len(fq) < 2 {
+			continue
+		}
+		if read != 0 && read != len(fq) {
+			for i := range fq {
+				if done[i] {
+					problems.add(fq[i], fastqs[i].Line(), "ends after %d records, but the other inputs have more", records[i])
+				}
+			}
+			inStep = false
+			continue
+		}
+		if read == len(fq) && !validateArgs.NoCheckPairs {
+			for i := 1; i < len(fq); i++ {
+				if !fqfilter.SameFragment(mates[0].Header, mates[i].Header) {
+					problems.add(fq[i], fastqs[i].Line()-3, "record %d: %s doesn't match its mate %s in %s", records[i], mates[i].Header, mates[0].Header, fq[0])
+				}
+			}
+		}
+	}
+	for i := range inputs {
+		inputs[i].Close()
+	}
+
+	// Each input's qualities should be encoded the same way
+	offset := 0
+	for i, fn := range fq {
+		guess, sure := ranges[i].Offset()
+		if !validateArgs.Quiet {
+			encoding := fmt.Sprintf("Phred+%d", guess)
+			if !sure {
+				encoding += " (or possibly Phred+64)"
+			}
+			log.Printf("%s: %d records, qualities %s\n", fn, records[i], encoding)
+		}
+		if !sure {
+			continue
+		}
+		if offset != 0 && guess != offset {
+			problems.add(fn, 0, "qualities appear to be Phred+%d, but an earlier input's are Phred+%d", guess, offset)
+		}
+		offset = guess
+	}
+
+	if problems.count > problems.max {
+		fmt.Printf("... and %d more problems\n", problems.count-problems.max)
+	}
+	if problems.count > 0 {
+		log.Printf("%d problems found\n", problems.count)
+		os.Exit(exitMalformed)
+	}
+	if !validateArgs.Quiet {
+		log.Println("no problems found")
+	}
+}
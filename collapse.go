@@ -0,0 +1,112 @@
+package fqfilter
+
+import (
+	"sort"
+	"strings"
+)
+
+/* A read standing for a group of UMI duplicates */
+type collapsedRead struct {
+	index int
+	name  string
+	bases int
+	score int
+	mates []Record
+}
+
+/* Collapses reads that share a UMI, taken from the read name by
+ * UMIFromName, and the first StartLen bases of each mate (the whole sequence
+ * if StartLen is 0), keeping the read of each group with the highest mean
+ * quality. As the best read may come last, one read per group is held in
+ * memory until WriteTo. */
+type UMICollapser struct {
+	StartLen int
+	// The character that stands for a quality of 0, DefaultQualOffset if unset
+	Offset int
+	seen   int
+	groups map[string]int
+	reads  []collapsedRead
+}
+
+func NewUMICollapser(startLen int) *UMICollapser {
+	return &UMICollapser{StartLen: startLen, groups: make(map[string]int)}
+}
+
+/* The group a read belongs to */
+func (c *UMICollapser) key(header string, mates []Record) string {
+	var key strings.Builder
+	key.WriteString(UMIFromName(header))
+	for i := range mates {
+		seq := mates[i].Sequence
+		if c.StartLen > 0 && len(seq) > c.StartLen {
+			seq = seq[:c.StartLen]
+		}
+		key.WriteByte('\n')
+		key.WriteString(seq)
+	}
+	return key.String()
+}
+
+/* The mean quality of all the mates, scaled to stay an integer */
+func (c *UMICollapser) score(mates []Record) int {
+	offset := c.Offset
+	if offset == 0 {
+		offset = DefaultQualOffset
+	}
+	sum, n := 0, 0
+	for i := range mates {
+		for j := 0; j < len(mates[i].Quality); j++ {
+			sum += int(mates[i].Quality[j]) - offset
+		}
+		n += len(mates[i].Quality)
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum * 1000 / n
+}
+
+/* Offer a read, and its untrimmed length. It replaces the read held for its
+ * group if its mean quality is higher. The mates are copied since the caller
+ * reuses its slice. */
+func (c *UMICollapser) Add(name string, mates []Record, bases int) {
+	c.seen++
+	key := c.key(mates[0].Header, mates)
+	score := c.score(mates)
+	i, ok := c.groups[key]
+	if !ok {
+		c.groups[key] = len(c.reads)
+		c.reads = append(c.reads, collapsedRead{index: c.seen, name: name, bases: bases, score: score, mates: append([]Record(nil), mates...)})
+		return
+	}
+	if score > c.reads[i].score {
+		read := &c.reads[i]
+		read.name, read.bases, read.score = name, bases, score
+		read.mates = append(read.mates[:0], mates...)
+	}
+}
+
+/* The number of reads offered that were collapsed into another */
+func (c *UMICollapser) Collapsed() int {
+	return c.seen - len(c.reads)
+}
+
+/* The total untrimmed length of the reads kept */
+func (c *UMICollapser) Bases() int {
+	n := 0
+	for _, read := range c.reads {
+		n += read.bases
+	}
+	return n
+}
+
+/* Write a read for each group, in the order the groups first appeared */
+func (c *UMICollapser) WriteTo(output *Output) (int, error) {
+	sort.Slice(c.reads, func(i, j int) bool { return c.reads[i].index < c.reads[j].index })
+	for _, read := range c.reads {
+		if err := output.Write(read.name, read.mates); err != nil {
+			return 0, err
+		}
+	}
+	return len(c.reads), nil
+}
@@ -0,0 +1,90 @@
+package fqfilter
+
+import (
+	"math"
+)
+
+/* The number of ambiguous bases, N or n, in a sequence */
+func CountN(seq string) int {
+	n := 0
+	for i := 0; i < len(seq); i++ {
+		if seq[i] == 'N' || seq[i] == 'n' {
+			n++
+		}
+	}
+	return n
+}
+
+/* Whether a mate has no more N bases than MaxN allows */
+func (f *Filter) nOK(rec *Record) bool {
+	n := CountN(rec.Sequence)
+	if f.MaxN < 1 {
+		return float64(n) <= f.MaxN*float64(len(rec.Sequence))
+	}
+	return float64(n) <= f.MaxN
+}
+
+/* How varied a sequence is, from 0 for a homopolymer to 1 when every
+ * trinucleotide in it is equally common: the Shannon entropy of its
+ * trinucleotides, over the most any sequence of its length could have. Low
+ * complexity reads, like poly-A tails and short tandem repeats, score low. A
+ * sequence of three bases or fewer scores 1. */
+func Complexity(seq string) float64 {
+	kmers := len(seq) - 2
+	if kmers <= 1 {
+		return 1
+	}
+	counts := make(map[string]int)
+	for i := 0; i < kmers; i++ {
+		counts[seq[i:i+3]]++
+	}
+	entropy := 0.0
+	for _, c := range counts {
+		p := float64(c) / float64(kmers)
+		entropy -= p * math.Log(p)
+	}
+	return entropy / math.Log(math.Min(64, float64(kmers)))
+}
+
+func (f *Filter) complexityOK(rec *Record) bool {
+	return Complexity(rec.Sequence) >= f.MinComplexity
+}
+
+/* The number of G and C bases in a sequence, and of bases that are
+ * A, C, G or T, whichever the case */
+func countGC(seq string) (gc, acgt int) {
+	for i := 0; i < len(seq); i++ {
+		switch seq[i] {
+		case 'G', 'C', 'g', 'c':
+			gc++
+			acgt++
+		case 'A', 'T', 'a', 't':
+			acgt++
+		}
+	}
+	return gc, acgt
+}
+
+/* The percentage of G and C among the A, C, G and T bases of all the mates
+ * together, so a pair is judged on its mean. A read with no such bases
+ * counts as 0%. */
+func GCPercent(mates []Record) float64 {
+	gc, acgt := 0, 0
+	for i := range mates {
+		g, n := countGC(mates[i].Sequence)
+		gc += g
+		acgt += n
+	}
+	if acgt == 0 {
+		return 0
+	}
+	return 100 * float64(gc) / float64(acgt)
+}
+
+func (f *Filter) gcOK(mates []Record) bool {
+	pct := GCPercent(mates)
+	if pct < f.MinGC {
+		return false
+	}
+	return f.MaxGC == 0 || pct <= f.MaxGC
+}
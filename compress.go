@@ -0,0 +1,178 @@
+package fqfilter
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	bzip2Magic = []byte("BZh")
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+/* Wrap r to decompress it, going by the filename's suffix or, if that isn't
+ * one we know, the first few bytes. Returns the decompressor to close, which
+ * is nil for plain text, and the reader to read from. */
+func newDecompressor(fn string, r io.Reader, opts ReadOptions) (io.ReadCloser, io.Reader, error) {
+	if strings.HasSuffix(fn, ".gz") {
+		gz, err := newGzipReader(r, opts)
+		return gz, gz, err
+	}
+	if strings.HasSuffix(fn, ".zst") {
+		zr, err := newZstdReader(r, opts)
+		return zr, zr, err
+	}
+	if strings.HasSuffix(fn, ".bz2") {
+		bz := io.NopCloser(bzip2.NewReader(r))
+		return bz, bz, nil
+	}
+	if strings.HasSuffix(fn, ".xz") {
+		xr, err := newXzReader(r)
+		return xr, xr, err
+	}
+	br := bufio.NewReader(r)
+	// A short or empty file is plain text, and its error comes on reading
+	magic, _ := br.Peek(len(xzMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := newGzipReader(br, opts)
+		return gz, gz, err
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := newZstdReader(br, opts)
+		return zr, zr, err
+	case bytes.HasPrefix(magic, bzip2Magic):
+		bz := io.NopCloser(bzip2.NewReader(br))
+		return bz, bz, nil
+	case bytes.HasPrefix(magic, xzMagic):
+		xr, err := newXzReader(br)
+		return xr, xr, err
+	}
+	return nil, br, nil
+}
+
+func newGzipReader(r io.Reader, opts ReadOptions) (io.ReadCloser, error) {
+	if opts.Threads <= 1 {
+		return gzip.NewReader(r)
+	}
+	return pgzip.NewReaderN(r, 1<<20, 2*opts.Threads)
+}
+
+/* zstd.Decoder's Close returns nothing, so adapt it to io.ReadCloser */
+type zstdReader struct {
+	*zstd.Decoder
+}
+
+func (z zstdReader) Close() error {
+	z.Decoder.Close()
+	return nil
+}
+
+func newZstdReader(r io.Reader, opts ReadOptions) (io.ReadCloser, error) {
+	threads := opts.Threads
+	if threads < 1 {
+		threads = 1
+	}
+	dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(threads))
+	if err != nil {
+		return nil, err
+	}
+	return zstdReader{dec}, nil
+}
+
+/* There's no xz decoder in the standard library, so xz files are piped
+ * through the xz command. Its exit status is checked at the end of the
+ * stream, so a corrupt file isn't mistaken for a short one. */
+type xzReader struct {
+	cmd    *exec.Cmd
+	out    io.ReadCloser
+	stderr bytes.Buffer
+	done   bool
+}
+
+func newXzReader(r io.Reader) (io.ReadCloser, error) {
+	x := &xzReader{cmd: exec.Command("xz", "-dc")}
+	x.cmd.Stdin = r
+	x.cmd.Stderr = &x.stderr
+	var err error
+	if x.out, err = x.cmd.StdoutPipe(); err != nil {
+		return nil, err
+	}
+	if err := x.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("Reading .xz files needs the xz command: %v", err)
+	}
+	return x, nil
+}
+
+func (x *xzReader) Read(b []byte) (int, error) {
+	n, err := x.out.Read(b)
+	if err == io.EOF && !x.done {
+		x.done = true
+		if werr := x.cmd.Wait(); werr != nil {
+			return n, fmt.Errorf("xz: %v: %s", werr, strings.TrimSpace(x.stderr.String()))
+		}
+	}
+	return n, err
+}
+
+/* Stop xz if the stream wasn't read to the end */
+func (x *xzReader) Close() error {
+	if x.done {
+		return nil
+	}
+	x.done = true
+	x.cmd.Process.Kill()
+	x.cmd.Wait()
+	return nil
+}
+
+/* Compress to w in the format named by the filename's suffix, .gz or .zst */
+func newCompressor(fn string, w io.Writer, opts WriteOptions) (io.WriteCloser, error) {
+	if strings.HasSuffix(fn, ".zst") {
+		return newZstdWriter(w, opts)
+	}
+	if opts.BGZF {
+		return NewBGZFWriter(w, opts.Level)
+	}
+	return newGzipWriter(w, opts)
+}
+
+func newGzipWriter(w io.Writer, opts WriteOptions) (io.WriteCloser, error) {
+	if opts.Threads <= 1 {
+		return gzip.NewWriterLevel(w, opts.Level)
+	}
+	gz, err := pgzip.NewWriterLevel(w, opts.Level)
+	if err != nil {
+		return nil, err
+	}
+	if err := gz.SetConcurrency(1<<20, opts.Threads); err != nil {
+		return nil, err
+	}
+	return gz, nil
+}
+
+/* Zstandard's levels run from 1 to 22, which the encoder maps onto its own
+ * handful of speeds */
+const DefaultZstdLevel = 3
+
+func newZstdWriter(w io.Writer, opts WriteOptions) (io.WriteCloser, error) {
+	level := opts.ZstdLevel
+	if level == 0 {
+		level = DefaultZstdLevel
+	}
+	threads := opts.Threads
+	if threads < 1 {
+		threads = 1
+	}
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)), zstd.WithEncoderConcurrency(threads))
+}
@@ -0,0 +1,67 @@
+package fqfilter
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math/rand"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var benchFastq = sync.OnceValue(func() []byte {
+	return []byte(randomFastq(20000, 150))
+})
+
+/* n reads of random bases and qualities, much as a sequencer writes them */
+func randomFastq(n, length int) string {
+	rng := rand.New(rand.NewSource(1))
+	seq := make([]byte, length)
+	qual := make([]byte, length)
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		for j := range seq {
+			seq[j] = "ACGT"[rng.Intn(4)]
+			qual[j] = byte('#' + rng.Intn(40))
+		}
+		fmt.Fprintf(&b, "@read%d 1:N:0:ACGT\n%s\n+\n%s\n", i, seq, qual)
+	}
+	return b.String()
+}
+
+/* About how much each benchmark compresses, in one stream, so that it
+ * measures sustained throughput rather than starting up */
+const benchStreamBytes = 1 << 30
+
+/* Compress benchFastq again and again, to about benchStreamBytes */
+func benchmarkGzipWrite(b *testing.B, threads int) {
+	data := benchFastq()
+	blocks := (benchStreamBytes + len(data) - 1) / len(data)
+	b.SetBytes(int64(blocks * len(data)))
+	for i := 0; i < b.N; i++ {
+		w, err := newGzipWriter(io.Discard, WriteOptions{Level: gzip.DefaultCompression, Threads: threads})
+		if err != nil {
+			b.Fatal(err)
+		}
+		for j := 0; j < blocks; j++ {
+			if _, err := w.Write(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+/* compress/gzip, which a single thread uses */
+func BenchmarkGzipWrite(b *testing.B) {
+	benchmarkGzipWrite(b, 1)
+}
+
+/* pgzip, compressing blocks on every CPU */
+func BenchmarkPgzipWrite(b *testing.B) {
+	benchmarkGzipWrite(b, max(runtime.NumCPU(), 2))
+}
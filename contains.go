@@ -0,0 +1,91 @@
+package fqfilter
+
+import (
+	"strings"
+)
+
+/* Finds whether a sequence contains any of a set of subsequences, such as
+ * adapters or contaminants. Matching ignores case. A single pattern uses
+ * strings.Contains; several are combined into an Aho-Corasick automaton so
+ * the scan stays linear in the length of the sequence. */
+type SeqMatcher struct {
+	single string
+	nodes  []acNode
+}
+
+type acNode struct {
+	next [256]int32
+	out  bool
+}
+
+func NewSeqMatcher(patterns []string) *SeqMatcher {
+	if len(patterns) == 1 {
+		return &SeqMatcher{single: strings.ToUpper(patterns[0])}
+	}
+	m := &SeqMatcher{nodes: make([]acNode, 1)}
+	// Build the trie, with -1 marking missing edges
+	for i := range m.nodes[0].next {
+		m.nodes[0].next[i] = -1
+	}
+	for _, p := range patterns {
+		u := 0
+		for _, c := range []byte(strings.ToUpper(p)) {
+			if m.nodes[u].next[c] < 0 {
+				m.nodes = append(m.nodes, acNode{})
+				v := len(m.nodes) - 1
+				for i := range m.nodes[v].next {
+					m.nodes[v].next[i] = -1
+				}
+				m.nodes[u].next[c] = int32(v)
+			}
+			u = int(m.nodes[u].next[c])
+		}
+		m.nodes[u].out = true
+	}
+	// Fill in the missing edges from the failure links, breadth first, so
+	// the search never has to backtrack
+	fail := make([]int32, len(m.nodes))
+	var queue []int32
+	for c := range m.nodes[0].next {
+		if v := m.nodes[0].next[c]; v < 0 {
+			m.nodes[0].next[c] = 0
+		} else {
+			queue = append(queue, v)
+		}
+	}
+	for len(queue) > 0 {
+		u := queue[0]
+		queue = queue[1:]
+		for c := range m.nodes[u].next {
+			v := m.nodes[u].next[c]
+			if v < 0 {
+				m.nodes[u].next[c] = m.nodes[fail[u]].next[c]
+				continue
+			}
+			fail[v] = m.nodes[fail[u]].next[c]
+			if m.nodes[fail[v]].out {
+				m.nodes[v].out = true
+			}
+			queue = append(queue, v)
+		}
+	}
+	return m
+}
+
+func (m *SeqMatcher) Match(seq string) bool {
+	if m.nodes == nil {
+		return strings.Contains(strings.ToUpper(seq), m.single)
+	}
+	u := int32(0)
+	for i := 0; i < len(seq); i++ {
+		c := seq[i]
+		if 'a' <= c && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		u = m.nodes[u].next[c]
+		if m.nodes[u].out {
+			return true
+		}
+	}
+	return false
+}
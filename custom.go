@@ -0,0 +1,21 @@
+package fqfilter
+
+/* A rule of one's own for which reads to keep, such as a site's barcode
+ * scheme or a quirk of an instrument, run by a Filter on each selected read
+ * after its own filters. Keep is given the mates of a read, already trimmed,
+ * and an error from it ends the run. */
+type RecordFilter interface {
+	Keep(mates []Record) (bool, error)
+}
+
+/* A plain function as a RecordFilter */
+type RecordFilterFunc func(mates []Record) (bool, error)
+
+func (f RecordFilterFunc) Keep(mates []Record) (bool, error) {
+	return f(mates)
+}
+
+/* What a filter plugin, built with go build -buildmode=plugin, must export as
+ * NewFilter: a function making its RecordFilter from the text given after
+ * the plugin's filename, which may be empty */
+type PluginConstructor = func(arg string) (RecordFilter, error)
@@ -0,0 +1,126 @@
+package fqfilter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+/* The highest Phred score counted on its own; higher ones are counted with
+ * it */
+const maxCycleQual = 93
+
+/* Base qualities and composition at each position (cycle) of the reads of
+ * one mate, as FastQC's per base sequence quality and content plots show */
+type CycleStats struct {
+	// The character that stands for a quality of 0, DefaultQualOffset if unset
+	Offset int
+	cycles []cycleCounts
+}
+
+type cycleCounts struct {
+	// A, C, G, T and anything else
+	bases [5]int
+	quals [maxCycleQual + 1]int
+}
+
+/* The summary of one cycle, numbered from 1. The base columns are the
+ * percentage of the reads long enough to reach the cycle. */
+type CycleSummary struct {
+	Cycle       int
+	Reads       int
+	MeanQuality float64
+	LowerQuart  int
+	Median      int
+	UpperQuart  int
+	A, C, G, T  float64
+	N           float64
+}
+
+var cycleBase = func() [256]byte {
+	var index [256]byte
+	for i := range index {
+		index[i] = 4
+	}
+	for i, b := range "ACGT" {
+		index[b] = byte(i)
+		index[b+'a'-'A'] = byte(i)
+	}
+	return index
+}()
+
+func (s *CycleStats) Add(rec *Record) {
+	for len(s.cycles) < len(rec.Sequence) {
+		s.cycles = append(s.cycles, cycleCounts{})
+	}
+	offset := s.Offset
+	if offset == 0 {
+		offset = DefaultQualOffset
+	}
+	for i := 0; i < len(rec.Sequence); i++ {
+		c := &s.cycles[i]
+		c.bases[cycleBase[rec.Sequence[i]]]++
+		if i < len(rec.Quality) {
+			c.quals[min(max(int(rec.Quality[i])-offset, 0), maxCycleQual)]++
+		}
+	}
+}
+
+/* The number of cycles: the length of the longest read */
+func (s *CycleStats) Cycles() int {
+	return len(s.cycles)
+}
+
+/* The score below or at which a fraction of the qualities fall */
+func (c *cycleCounts) quantile(n int, frac float64) int {
+	want := int(frac*float64(n-1)) + 1
+	seen := 0
+	for q, count := range c.quals {
+		seen += count
+		if seen >= want {
+			return q
+		}
+	}
+	return 0
+}
+
+func (s *CycleStats) Summary() []CycleSummary {
+	summary := make([]CycleSummary, len(s.cycles))
+	for i := range s.cycles {
+		c := &s.cycles[i]
+		reads := 0
+		for _, n := range c.bases {
+			reads += n
+		}
+		quals, sum := 0, 0
+		for q, n := range c.quals {
+			quals += n
+			sum += q * n
+		}
+		pct := func(j int) float64 {
+			return 100 * float64(c.bases[j]) / float64(max(reads, 1))
+		}
+		cs := CycleSummary{Cycle: i + 1, Reads: reads, A: pct(0), C: pct(1), G: pct(2), T: pct(3), N: pct(4)}
+		if quals > 0 {
+			cs.MeanQuality = float64(sum) / float64(quals)
+			cs.LowerQuart = c.quantile(quals, 0.25)
+			cs.Median = c.quantile(quals, 0.5)
+			cs.UpperQuart = c.quantile(quals, 0.75)
+		}
+		summary[i] = cs
+	}
+	return summary
+}
+
+/* Write the summary of each mate's cycles as a table, with a row of column
+ * names first */
+func WriteCycleTable(w io.Writer, mates []*CycleStats) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "mate\tcycle\treads\tmean_qual\tq25\tmedian_qual\tq75\tA\tC\tG\tT\tN")
+	for m, s := range mates {
+		for _, c := range s.Summary() {
+			fmt.Fprintf(bw, "%d\t%d\t%d\t%.2f\t%d\t%d\t%d\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\n", m+1, c.Cycle, c.Reads, c.MeanQuality, c.LowerQuart, c.Median, c.UpperQuart, c.A, c.C, c.G, c.T, c.N)
+		}
+	}
+	return bw.Flush()
+}
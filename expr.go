@@ -0,0 +1,425 @@
+package fqfilter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/* A filter expression, like len >= 50 && meanq >= 30 && gc < 0.6, evaluated
+ * on each mate. The variables are:
+ *
+ *   len     the length of the sequence
+ *   meanq   the mean base quality
+ *   gc      the fraction of the A, C, G and T bases that are G or C
+ *   ncount  the number of N bases
+ *   name    the first word of the header
+ *   header  the whole header
+ *   seq     the sequence
+ *   qual    the quality string
+ *
+ * Numbers combine with + - * / and compare with == != < <= > >=, strings
+ * compare with == and != and match a regular expression with =~ "re", and
+ * conditions combine with && || ! and parentheses. */
+type Expr struct {
+	src  string
+	eval func(rec *Record) bool
+	// The character that stands for a quality of 0, DefaultQualOffset if unset
+	Offset int
+}
+
+/* Parse an expression, checking that it is well formed and that the types
+ * fit together */
+func CompileExpr(src string) (*Expr, error) {
+	toks, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	e := &Expr{src: src}
+	p := &exprParser{toks: toks, expr: e}
+	t, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("Unexpected %s in expression", p.toks[p.pos].text)
+	}
+	if t.kind != kindBool {
+		return nil, fmt.Errorf("Expression %s is a %s, not a condition", src, t.kind)
+	}
+	e.eval = t.b
+	return e, nil
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+/* Whether a mate meets the condition */
+func (e *Expr) Match(rec *Record) bool {
+	return e.eval(rec)
+}
+
+func (e *Expr) offset() int {
+	if e.Offset == 0 {
+		return DefaultQualOffset
+	}
+	return e.Offset
+}
+
+type exprKind string
+
+const (
+	kindNum  exprKind = "number"
+	kindStr  exprKind = "string"
+	kindBool exprKind = "condition"
+)
+
+/* A piece of a parsed expression, with the function for its kind set */
+type exprTerm struct {
+	kind exprKind
+	n    func(rec *Record) float64
+	s    func(rec *Record) string
+	b    func(rec *Record) bool
+	// The value of a string literal, for =~
+	literal *string
+}
+
+type exprToken struct {
+	text string
+	// Set for number and string literals and for names
+	num   bool
+	str   bool
+	ident bool
+	value string
+}
+
+var exprOps = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "<", ">", "!", "+", "-", "*", "/", "(", ")"}
+
+func lexExpr(src string) ([]exprToken, error) {
+	var toks []exprToken
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(src) && src[j] != src[i] {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("Unterminated string in expression")
+			}
+			value := strings.NewReplacer(`\\`, `\`, `\"`, `"`, `\'`, `'`).Replace(src[i+1 : j])
+			toks = append(toks, exprToken{text: src[i : j+1], str: true, value: value})
+			i = j + 1
+		case unicode.IsDigit(c) || c == '.':
+			j := i
+			for j < len(src) && (unicode.IsDigit(rune(src[j])) || src[j] == '.' || src[j] == 'e' || src[j] == 'E' ||
+				(src[j] == '-' || src[j] == '+') && (src[j-1] == 'e' || src[j-1] == 'E')) {
+				j++
+			}
+			toks = append(toks, exprToken{text: src[i:j], num: true, value: src[i:j]})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(src) && (unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j])) || src[j] == '_') {
+				j++
+			}
+			toks = append(toks, exprToken{text: src[i:j], ident: true, value: src[i:j]})
+			i = j
+		default:
+			found := false
+			for _, op := range exprOps {
+				if strings.HasPrefix(src[i:], op) {
+					toks = append(toks, exprToken{text: op})
+					i += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("Unexpected %c in expression", c)
+			}
+		}
+	}
+	return toks, nil
+}
+
+type exprParser struct {
+	toks []exprToken
+	pos  int
+	expr *Expr
+}
+
+/* Consume the next token if it is the operator */
+func (p *exprParser) accept(op string) bool {
+	if p.pos < len(p.toks) && !p.toks[p.pos].str && p.toks[p.pos].text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) parseOr() (exprTerm, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.accept("||") {
+		var right exprTerm
+		if right, err = p.parseAnd(); err == nil {
+			if err = checkKinds("||", kindBool, left, right); err == nil {
+				l, r := left.b, right.b
+				left = exprTerm{kind: kindBool, b: func(rec *Record) bool { return l(rec) || r(rec) }}
+			}
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseAnd() (exprTerm, error) {
+	left, err := p.parseNot()
+	for err == nil && p.accept("&&") {
+		var right exprTerm
+		if right, err = p.parseNot(); err == nil {
+			if err = checkKinds("&&", kindBool, left, right); err == nil {
+				l, r := left.b, right.b
+				left = exprTerm{kind: kindBool, b: func(rec *Record) bool { return l(rec) && r(rec) }}
+			}
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseNot() (exprTerm, error) {
+	if !p.accept("!") {
+		return p.parseCompare()
+	}
+	t, err := p.parseNot()
+	if err != nil {
+		return t, err
+	}
+	if t.kind != kindBool {
+		return t, fmt.Errorf("! needs a condition, not a %s", t.kind)
+	}
+	b := t.b
+	return exprTerm{kind: kindBool, b: func(rec *Record) bool { return !b(rec) }}, nil
+}
+
+func (p *exprParser) parseCompare() (exprTerm, error) {
+	left, err := p.parseSum()
+	if err != nil {
+		return left, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "=~", "<", ">"} {
+		if !p.accept(op) {
+			continue
+		}
+		right, err := p.parseSum()
+		if err != nil {
+			return right, err
+		}
+		if op == "=~" {
+			return p.regexpMatch(left, right)
+		}
+		if left.kind != right.kind || left.kind == kindBool {
+			return left, fmt.Errorf("Cannot compare a %s with a %s using %s", left.kind, right.kind, op)
+		}
+		if left.kind == kindStr {
+			return compareStrings(op, left.s, right.s)
+		}
+		return compareNumbers(op, left.n, right.n), nil
+	}
+	return left, nil
+}
+
+func (p *exprParser) regexpMatch(left, right exprTerm) (exprTerm, error) {
+	if left.kind != kindStr || right.literal == nil {
+		return left, fmt.Errorf("=~ needs a string on the left and a quoted regular expression on the right")
+	}
+	re, err := regexp.Compile(*right.literal)
+	if err != nil {
+		return left, fmt.Errorf("Invalid regular expression %s: %v", *right.literal, err)
+	}
+	s := left.s
+	return exprTerm{kind: kindBool, b: func(rec *Record) bool { return re.MatchString(s(rec)) }}, nil
+}
+
+func compareStrings(op string, l, r func(*Record) string) (exprTerm, error) {
+	switch op {
+	case "==":
+		return exprTerm{kind: kindBool, b: func(rec *Record) bool { return l(rec) == r(rec) }}, nil
+	case "!=":
+		return exprTerm{kind: kindBool, b: func(rec *Record) bool { return l(rec) != r(rec) }}, nil
+	}
+	return exprTerm{}, fmt.Errorf("Strings can only be compared with == and !=, not %s", op)
+}
+
+func compareNumbers(op string, l, r func(*Record) float64) exprTerm {
+	var b func(rec *Record) bool
+	switch op {
+	case "==":
+		b = func(rec *Record) bool { return l(rec) == r(rec) }
+	case "!=":
+		b = func(rec *Record) bool { return l(rec) != r(rec) }
+	case "<":
+		b = func(rec *Record) bool { return l(rec) < r(rec) }
+	case "<=":
+		b = func(rec *Record) bool { return l(rec) <= r(rec) }
+	case ">":
+		b = func(rec *Record) bool { return l(rec) > r(rec) }
+	case ">=":
+		b = func(rec *Record) bool { return l(rec) >= r(rec) }
+	}
+	return exprTerm{kind: kindBool, b: b}
+}
+
+func (p *exprParser) parseSum() (exprTerm, error) {
+	left, err := p.parseProduct()
+	for err == nil {
+		op := "+"
+		if !p.accept("+") {
+			if !p.accept("-") {
+				break
+			}
+			op = "-"
+		}
+		var right exprTerm
+		if right, err = p.parseProduct(); err == nil {
+			left, err = arithmetic(op, left, right)
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseProduct() (exprTerm, error) {
+	left, err := p.parseUnary()
+	for err == nil {
+		op := "*"
+		if !p.accept("*") {
+			if !p.accept("/") {
+				break
+			}
+			op = "/"
+		}
+		var right exprTerm
+		if right, err = p.parseUnary(); err == nil {
+			left, err = arithmetic(op, left, right)
+		}
+	}
+	return left, err
+}
+
+func arithmetic(op string, left, right exprTerm) (exprTerm, error) {
+	if err := checkKinds(op, kindNum, left, right); err != nil {
+		return left, err
+	}
+	l, r := left.n, right.n
+	var n func(rec *Record) float64
+	switch op {
+	case "+":
+		n = func(rec *Record) float64 { return l(rec) + r(rec) }
+	case "-":
+		n = func(rec *Record) float64 { return l(rec) - r(rec) }
+	case "*":
+		n = func(rec *Record) float64 { return l(rec) * r(rec) }
+	case "/":
+		n = func(rec *Record) float64 { return l(rec) / r(rec) }
+	}
+	return exprTerm{kind: kindNum, n: n}, nil
+}
+
+func checkKinds(op string, want exprKind, left, right exprTerm) error {
+	if left.kind != want || right.kind != want {
+		return fmt.Errorf("%s needs a %s on each side", op, want)
+	}
+	return nil
+}
+
+func (p *exprParser) parseUnary() (exprTerm, error) {
+	if !p.accept("-") {
+		return p.parsePrimary()
+	}
+	t, err := p.parseUnary()
+	if err != nil {
+		return t, err
+	}
+	if t.kind != kindNum {
+		return t, fmt.Errorf("- needs a number, not a %s", t.kind)
+	}
+	n := t.n
+	return exprTerm{kind: kindNum, n: func(rec *Record) float64 { return -n(rec) }}, nil
+}
+
+func (p *exprParser) parsePrimary() (exprTerm, error) {
+	if p.pos >= len(p.toks) {
+		return exprTerm{}, fmt.Errorf("Expression ends too soon")
+	}
+	if p.accept("(") {
+		t, err := p.parseOr()
+		if err != nil {
+			return t, err
+		}
+		if !p.accept(")") {
+			return t, fmt.Errorf("Missing ) in expression")
+		}
+		return t, nil
+	}
+	tok := p.toks[p.pos]
+	p.pos++
+	switch {
+	case tok.num:
+		v, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return exprTerm{}, fmt.Errorf("Invalid number %s in expression", tok.text)
+		}
+		return exprTerm{kind: kindNum, n: func(*Record) float64 { return v }}, nil
+	case tok.str:
+		v := tok.value
+		return exprTerm{kind: kindStr, s: func(*Record) string { return v }, literal: &v}, nil
+	case tok.ident:
+		return p.variable(tok.value)
+	}
+	return exprTerm{}, fmt.Errorf("Unexpected %s in expression", tok.text)
+}
+
+func (p *exprParser) variable(name string) (exprTerm, error) {
+	e := p.expr
+	switch name {
+	case "true", "false":
+		v := name == "true"
+		return exprTerm{kind: kindBool, b: func(*Record) bool { return v }}, nil
+	case "len":
+		return exprTerm{kind: kindNum, n: func(rec *Record) float64 { return float64(len(rec.Sequence)) }}, nil
+	case "meanq":
+		return exprTerm{kind: kindNum, n: func(rec *Record) float64 { return meanQuality(rec.Quality, e.offset()) }}, nil
+	case "gc":
+		return exprTerm{kind: kindNum, n: func(rec *Record) float64 {
+			gc, acgt := countGC(rec.Sequence)
+			if acgt == 0 {
+				return 0
+			}
+			return float64(gc) / float64(acgt)
+		}}, nil
+	case "ncount":
+		return exprTerm{kind: kindNum, n: func(rec *Record) float64 { return float64(CountN(rec.Sequence)) }}, nil
+	case "name":
+		return exprTerm{kind: kindStr, s: func(rec *Record) string {
+			if i := strings.IndexAny(rec.Header, " \t"); i >= 0 {
+				return rec.Header[:i]
+			}
+			return rec.Header
+		}}, nil
+	case "header":
+		return exprTerm{kind: kindStr, s: func(rec *Record) string { return rec.Header }}, nil
+	case "seq":
+		return exprTerm{kind: kindStr, s: func(rec *Record) string { return rec.Sequence }}, nil
+	case "qual":
+		return exprTerm{kind: kindStr, s: func(rec *Record) string { return rec.Quality }}, nil
+	}
+	return exprTerm{}, fmt.Errorf("Unknown variable %s in expression", name)
+}
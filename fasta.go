@@ -0,0 +1,89 @@
+package fqfilter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+/* Reads records from a FASTA stream, where a sequence can run over any number
+ * of lines. The records have no quality, and blank lines are skipped. */
+type FastaReader struct {
+	scanner *bufio.Scanner
+	line    int
+	next    string
+	started bool
+	done    bool
+	maxLine int
+}
+
+func NewFastaReader(r io.Reader) *FastaReader {
+	f := &FastaReader{scanner: bufio.NewScanner(r)}
+	f.SetMaxLineBytes(DefaultMaxLineBytes)
+	return f
+}
+
+/* Set the longest line allowed, as for FastqReader */
+func (f *FastaReader) SetMaxLineBytes(n int) {
+	f.maxLine = setMaxLine(f.scanner, n)
+}
+
+/* The scanner's error, naming the record for a line that is too long */
+func (f *FastaReader) err(header string) error {
+	err := f.scanner.Err()
+	if err == bufio.ErrTooLong {
+		return lineTooLong(f.line+1, f.maxLine, []byte(header))
+	}
+	return err
+}
+
+/* Move on to the next non-blank line */
+func (f *FastaReader) scan() bool {
+	for f.scanner.Scan() {
+		f.line++
+		text := strings.TrimSuffix(f.scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		f.next = text
+		return true
+	}
+	f.done = true
+	return false
+}
+
+/* Read the next record into rec, returning io.EOF after the last one. The
+ * header line of the following record is held back for the next call. */
+func (f *FastaReader) Read(rec *Record) error {
+	if !f.started {
+		f.started = true
+		f.scan()
+	}
+	if f.done {
+		if err := f.err(""); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	if !strings.HasPrefix(f.next, ">") {
+		return fmt.Errorf("Line %d should be a FASTA header line, got: %s\n", f.line, f.next)
+	}
+	rec.Header = f.next[1:]
+	rec.Plus = ""
+	rec.Quality = ""
+	var seq strings.Builder
+	for f.scan() && !strings.HasPrefix(f.next, ">") {
+		seq.WriteString(f.next)
+	}
+	rec.Sequence = seq.String()
+	if f.done {
+		return f.err(rec.Header)
+	}
+	return nil
+}
+
+/* The number of lines read so far */
+func (f *FastaReader) Line() int {
+	return f.line
+}
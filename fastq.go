@@ -0,0 +1,138 @@
+package fqfilter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+)
+
+/* A single FASTQ record. The header is stored without its leading @ */
+type Record struct {
+	Header   string
+	Sequence string
+	Plus     string
+	Quality  string
+}
+
+/* Cut the sequence and quality down to at most n bases. Reads that are
+ * already short enough are left alone. */
+func (r *Record) Trim(n int) {
+	if len(r.Sequence) > n {
+		r.Sequence = r.Sequence[:n]
+	}
+	if len(r.Quality) > n {
+		r.Quality = r.Quality[:n]
+	}
+}
+
+/* Remove the first n bases of the sequence and quality. Reads no longer
+ * than that are left empty. */
+func (r *Record) Clip(n int) {
+	r.Sequence = r.Sequence[min(n, len(r.Sequence)):]
+	r.Quality = r.Quality[min(n, len(r.Quality)):]
+}
+
+/* Reads one record at a time, returning io.EOF after the last */
+type RecordReader interface {
+	Read(rec *Record) error
+}
+
+/* Reads whole records from a FASTQ stream. The + separator line is always
+ * checked; Strict also requires the quality to be as long as the sequence.
+ * The lines of a record are gathered in a buffer that is reused, and become
+ * one string that the fields share, so each record costs one allocation
+ * rather than one for every line. */
+type FastqReader struct {
+	Strict  bool
+	scanner *bufio.Scanner
+	line    int
+	buf     []byte
+	maxLine int
+}
+
+/* The longest line readers take unless told otherwise. The buffer grows to
+ * fit long reads up to this, but a file without line breaks, say, can't take
+ * all the memory. */
+const DefaultMaxLineBytes = 10 * 1024 * 1024
+
+func NewFastqReader(r io.Reader) *FastqReader {
+	f := &FastqReader{scanner: bufio.NewScanner(r)}
+	f.SetMaxLineBytes(DefaultMaxLineBytes)
+	return f
+}
+
+/* Set the longest line allowed, or with 0, let lines be any length. This
+ * must be called before the first Read. */
+func (f *FastqReader) SetMaxLineBytes(n int) {
+	f.maxLine = setMaxLine(f.scanner, n)
+}
+
+/* Size a scanner's buffer for lines of at most n bytes, or any length for 0,
+ * returning the limit */
+func setMaxLine(scanner *bufio.Scanner, n int) int {
+	if n <= 0 {
+		n = math.MaxInt
+	}
+	/* Start with a large buffer for long sequences */
+	scanner.Buffer(make([]byte, 0, min(n, 1024*1024)), n)
+	return n
+}
+
+/* The error for a line longer than the limit, naming the record it is in if
+ * the header has been read */
+func lineTooLong(line, limit int, header []byte) error {
+	if len(header) > 0 {
+		return fmt.Errorf("Line %d, in the record for %s, is longer than the limit of %d bytes; raise it with -max-line-bytes, or 0 for none\n", line, header, limit)
+	}
+	return fmt.Errorf("Line %d is longer than the limit of %d bytes; raise it with -max-line-bytes, or 0 for none\n", line, limit)
+}
+
+/* Read the next record into rec. Returns io.EOF if the input ends cleanly
+ * between records, or an error if it ends part way through one. */
+func (f *FastqReader) Read(rec *Record) error {
+	var ends [4]int
+	f.buf = f.buf[:0]
+	for j := 0; j < 4; j++ {
+		if !f.scanner.Scan() {
+			if err := f.scanner.Err(); err == bufio.ErrTooLong {
+				var header []byte
+				if j > 0 {
+					header = f.buf[1:ends[0]]
+				}
+				return lineTooLong(f.line+1, f.maxLine, header)
+			} else if err != nil {
+				return err
+			}
+			if j == 0 {
+				return io.EOF
+			}
+			return fmt.Errorf("File ends part way through a record, after line %d\n", f.line)
+		}
+		f.line++
+		// Drop the carriage return from files with CRLF line endings
+		f.buf = append(f.buf, bytes.TrimSuffix(f.scanner.Bytes(), []byte("\r"))...)
+		ends[j] = len(f.buf)
+	}
+	if ends[0] == 0 || f.buf[0] != '@' {
+		return fmt.Errorf("Line %d should be a header line, got: %s\n", f.line-3, f.buf[:ends[0]])
+	}
+	if ends[2] == ends[1] || f.buf[ends[1]] != '+' {
+		return fmt.Errorf("Line %d should be a + separator line, got: %s\n", f.line-1, f.buf[ends[1]:ends[2]])
+	}
+	if f.Strict && ends[3]-ends[2] != ends[1]-ends[0] {
+		return fmt.Errorf("Quality on line %d has length %d but the sequence has length %d\n", f.line, ends[3]-ends[2], ends[1]-ends[0])
+	}
+	record := string(f.buf)
+	rec.Header = record[1:ends[0]]
+	rec.Sequence = record[ends[0]:ends[1]]
+	rec.Plus = record[ends[1]:ends[2]]
+	rec.Quality = record[ends[2]:]
+	return nil
+}
+
+/* The number of lines read so far */
+func (f *FastqReader) Line() int {
+	return f.line
+}
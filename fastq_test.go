@@ -0,0 +1,51 @@
+package fqfilter
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFastqCRLF(t *testing.T) {
+	input := "@read1 1:N:0:ACGT\r\nACGTN\r\n+\r\nIIII#\r\n@read2\r\nGG\r\n+read2\r\nII\r\n"
+	want := []Record{
+		{Header: "read1 1:N:0:ACGT", Sequence: "ACGTN", Plus: "+", Quality: "IIII#"},
+		{Header: "read2", Sequence: "GG", Plus: "+read2", Quality: "II"},
+	}
+	fq := NewFastqReader(strings.NewReader(input))
+	for i, w := range want {
+		var rec Record
+		if err := fq.Read(&rec); err != nil {
+			t.Fatalf("record %d: %v", i+1, err)
+		}
+		if rec != w {
+			t.Errorf("record %d = %+v, want %+v", i+1, rec, w)
+		}
+	}
+	var rec Record
+	if err := fq.Read(&rec); err != io.EOF {
+		t.Errorf("after the last record got %v, want io.EOF", err)
+	}
+}
+
+/* Parsing, with the allocations each read costs */
+func BenchmarkFastqRead(b *testing.B) {
+	data := benchFastq()
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	reads := 0
+	for i := 0; i < b.N; i++ {
+		fq := NewFastqReader(bytes.NewReader(data))
+		var rec Record
+		for {
+			if err := fq.Read(&rec); err == io.EOF {
+				break
+			} else if err != nil {
+				b.Fatal(err)
+			}
+			reads++
+		}
+	}
+	b.ReportMetric(float64(reads)/float64(b.N), "reads/op")
+}
@@ -1,298 +1,371 @@
-package main
+/* Package fqfilter selects reads from FASTQ files by name, against a list of
+ * names, prefixes or regular expressions, with optional trimming and filters
+ * on length, quality, content and duplicates. The fqfilter command in cmd/fqfilter is a thin
+ * wrapper around it. */
+package fqfilter
 
 import (
-	"bufio"
-	"compress/gzip"
-	"flag"
-	"fmt"
-	"io"
-	"log"
-	"os"
-	"strings"
+	"math/rand"
+	"regexp"
 )
 
-/* This program takes on one or two (in the case of paried end data) fq files
- * and returns a subset of the reads */
+/* What a Filter decided about a read */
+type Decision int
 
-type Args struct {
-	Invert        bool
-	ReadsFilename string
-	OutPrefix     string
-	Limit         int
-	Tab           bool
-	ShortName     bool
-}
+const (
+	// Not selected by name
+	Excluded Decision = iota
+	// Selected and passed every filter
+	Included
+	// Selected, but a mate is too short or too long
+	LengthFiltered
+	// Selected, but the base qualities are too low
+	QualityFiltered
+	// Selected, but a mate has too many N bases
+	NFiltered
+	// Selected, but a mate's sequence is too repetitive
+	ComplexityFiltered
+	// Selected, but the GC content is out of bounds
+	GCFiltered
+	// Selected, but dropped by the Contains screen
+	ContentFiltered
+	// Selected, but shares too many k-mers with Contaminants
+	ContaminantFiltered
+	// Selected, but turned down by one of Custom
+	CustomFiltered
+	// Selected, but failed Expr
+	ExpressionFiltered
+	// Selected, but the motif was not found
+	MotifFiltered
+	// Selected, but the barcode matches none in the whitelist
+	BarcodeFiltered
+	// Selected, but not picked by Fraction or Every
+	SampledOut
+	// Selected, but the name was already seen
+	Duplicate
+)
 
-var args = Args{}
+/* The outcome for one read, with the normalized name it was looked up as and
+ * whether that was found in the set */
+type Result struct {
+	Name     string
+	Found    bool
+	Decision Decision
+}
 
-func init() {
-	log.SetFlags(0)
-	flag.BoolVar(&args.Invert, "invert", false, "return reads NOT in the file")
-	flag.BoolVar(&args.Tab, "tab", false, "print sequence as tabular output (readName, read1, read2)")
-	flag.BoolVar(&args.ShortName, "short-name", false, "use just the first space-separated word of the read name")
-	flag.StringVar(&args.ReadsFilename, "reads", "", "filename of reads to match")
-	flag.StringVar(&args.OutPrefix, "out", "", "output filename prefix (default = stdout)")
-	flag.IntVar(&args.Limit, "limit", 0, "output only the first LIMIT matches")
+/* Whether the read was selected by name, whether or not a later filter then
+ * dropped it */
+func (r Result) Selected() bool {
+	return r.Decision != Excluded
+}
 
-	flag.Usage = func() {
-		log.Println("usage: fqfilter [options] unaligned_1.fq.gz unaligned_2.fq.gz")
-		flag.PrintDefaults()
+/* Whether the read was dropped by a filter that judges each mate on its own,
+ * so that one mate may have passed */
+func (r Result) MateFiltered() bool {
+	switch r.Decision {
+	case LengthFiltered, QualityFiltered, NFiltered, ComplexityFiltered, ExpressionFiltered:
+		return true
 	}
+	return false
 }
 
-/* Provide an ambidexterous interface to files to read that may be gzipped */
-type AmbiReader struct {
-	fp *os.File
-	gz *gzip.Reader
-	r  io.Reader
+/* Which of a read's mates must pass a per-mate filter for the read to */
+type PairPolicy int
+
+const (
+	// Every mate must pass, so one failing mate drops the read
+	PairBoth PairPolicy = iota
+	// One passing mate is enough
+	PairEither
+)
+
+/* What makes two reads duplicates */
+type DedupKey int
+
+const (
+	// The same normalized name
+	DedupName DedupKey = iota
+	// The same sequence in every mate
+	DedupSequence
+)
+
+/* Decides which reads are selected: those whose name is in the set and whose
+ * header matches one of HeaderRegexps (or, with Invert, the others), and with
+ * a whitelisted barcode. Then, after any UMI is extracted and the Trimmers
+ * have run, they must pass the length, quality, N, complexity, GC,
+ * expression, motif, content, sampling and duplicate filters in that order.
+ * A zero field turns its filter off, and a nil Names selects every read. */
+type Filter struct {
+	Names      NameSet
+	Normalizer Normalizer
+	// Matched against the whole first header, as it is in the input
+	HeaderRegexps []*regexp.Regexp
+	// Only select the reads at these places in the inputs, where Record is
+	// the place of the read Apply is given, counting from 1
+	Records *RecordSet
+	Record  int
+	Invert  bool
+	// Only keep reads whose first mate has a barcode at BarcodePos within
+	// Barcodes.MaxMismatches of one in Barcodes. With TagBarcode, the
+	// whitelisted barcode it matched is added to the names as _BARCODE.
+	Barcodes   *BarcodeSet
+	BarcodePos BarcodePos
+	TagBarcode bool
+	// Move a UMI from the sequence to the names of a selected read
+	UMI *UMIExtractor
+	// Run in order on each mate of a selected read
+	Trimmers []Trimmer
+	// Bounds on the length of each mate's sequence, applied according to
+	// PairPolicy
+	MinLen int
+	MaxLen int
+	// Quality filters, also applied according to PairPolicy
+	Quality    QualityFilter
+	PairPolicy PairPolicy
+	// With LimitN, allow at most MaxN ambiguous (N) bases in each mate: a
+	// count or, below 1, a fraction of its bases
+	LimitN bool
+	MaxN   float64
+	// The lowest Complexity allowed for each mate
+	MinComplexity float64
+	// Bounds on the GC percentage of the whole read, over all its mates
+	MinGC float64
+	MaxGC float64
+	// A condition each mate must meet, as PairPolicy asks
+	Expr *Expr
+	// Keep only reads where the mate MotifMate (counting from 0), or with
+	// MotifMate of -1 any mate, contains a motif
+	Motif     *MotifMatcher
+	MotifMate int
+	// Drop reads where any mate matches, or with ContainsInvert, where none do
+	Contains       *SeqMatcher
+	ContainsInvert bool
+	// Drop reads with more than MaxContaminantHits k-mers, over all their
+	// mates, in Contaminants
+	Contaminants       *KmerSet
+	MaxContaminantHits int
+	// Rules of the caller's own, each of which must keep a read
+	Custom    []RecordFilter
+	customErr error
+	// Keep each read with this probability, drawn from Rand
+	Fraction float64
+	Rand     *rand.Rand
+	// Keep every Every'th read that gets this far, starting from the
+	// EveryOffset'th (counting from 0)
+	Every       int
+	EveryOffset int
+	everySeen   int
+	// The keys, by DedupBy, of the reads included so far, to drop duplicates
+	Seen    *SeenNames
+	DedupBy DedupKey
+	// Cut included reads down to at most this many bases
+	Trim int
+	// Reverse complement the mates of included reads marked here, after
+	// any Trim
+	RevComp []bool
 }
 
-func (a AmbiReader) Read(b []byte) (n int, err error) {
-	return a.r.Read(b)
+/* An error that a NameSet hits part way through, as SortedReads does */
+type erringSet interface {
+	Err() error
 }
 
-func (a *AmbiReader) Open(fn string) error {
-	if a.r != nil {
-		return fmt.Errorf("AmbiReader already open")
+/* Decide on one read, made up of a record from each mate. Selected reads are
+ * trimmed in place, and included ones marked as seen. */
+func (f *Filter) Apply(mates []Record) (Result, error) {
+	res := Result{Name: f.Normalizer.Name(mates[0].Header)}
+	// The name is looked up last, and the record noted as seen only once it
+	// has matched too, so that a read turned down by its header or place
+	// doesn't count its name or record number as matched
+	res.Found = f.headerMatches(mates[0].Header) && (f.Records == nil || f.Records.Has(f.Record)) && (f.Names == nil || f.Names.Contains(res.Name))
+	if res.Found && f.Records != nil {
+		f.Records.Contains(f.Record)
 	}
-	var err error
-	// If no filename is given, then read from stdin
-	if fn == "" {
-		a.r = os.Stdin
-		return nil
+	if s, ok := f.Names.(erringSet); ok && s.Err() != nil {
+		return res, s.Err()
 	}
-	a.fp, err = os.Open(fn)
-	if err != nil {
-		return err
+	if res.Found == f.Invert {
+		res.Decision = Excluded
+		return res, nil
 	}
-	if strings.HasSuffix(fn, ".gz") {
-		a.gz, err = gzip.NewReader(a.fp)
-		if err != nil {
-			return err
+	if f.Barcodes != nil {
+		i, _ := f.Barcodes.Match(f.BarcodePos.Extract(mates[0].Sequence))
+		if i < 0 {
+			res.Decision = BarcodeFiltered
+			return res, nil
+		}
+		if f.TagBarcode {
+			for j := range mates {
+				mates[j].Header = AddToName(mates[j].Header, "_"+f.Barcodes.Barcode(i))
+			}
 		}
-		a.r = a.gz
-	} else {
-		a.r = a.fp
 	}
-	return nil
-}
-
-func (a *AmbiReader) Close() error {
-	if a.gz != nil {
-		if err := a.gz.Close(); err != nil {
-			return err
+	if f.UMI != nil {
+		f.UMI.Extract(mates)
+	}
+	for _, t := range f.Trimmers {
+		for i := range mates {
+			t.TrimMate(&mates[i], i)
 		}
 	}
-	if err := a.fp.Close(); err != nil {
-		return err
+	key := ""
+	if f.Seen != nil {
+		key = f.dedupKey(res.Name, mates)
 	}
-	return nil
-}
-
-/* Provide an ambidexterous interface to files to write that may be gzipped */
-type AmbiWriter struct {
-	fp *os.File
-	gz *gzip.Writer
-	r  io.Writer
-}
-
-func (a AmbiWriter) Write(b []byte) (n int, err error) {
-	return a.r.Write(b)
+	switch {
+	case !f.matesPass(mates, f.lengthOK):
+		res.Decision = LengthFiltered
+	case f.Quality.Enabled() && !f.matesPass(mates, f.Quality.Pass):
+		res.Decision = QualityFiltered
+	case f.LimitN && !f.matesPass(mates, f.nOK):
+		res.Decision = NFiltered
+	case f.MinComplexity > 0 && !f.matesPass(mates, f.complexityOK):
+		res.Decision = ComplexityFiltered
+	case (f.MinGC > 0 || f.MaxGC > 0) && !f.gcOK(mates):
+		res.Decision = GCFiltered
+	case f.Expr != nil && !f.matesPass(mates, f.Expr.Match):
+		res.Decision = ExpressionFiltered
+	case f.Motif != nil && !f.motifFound(mates):
+		res.Decision = MotifFiltered
+	case f.Contains != nil && anyMateMatches(f.Contains, mates) != f.ContainsInvert:
+		res.Decision = ContentFiltered
+	case f.Contaminants != nil && f.contaminantHits(mates) > f.MaxContaminantHits:
+		res.Decision = ContaminantFiltered
+	case len(f.Custom) > 0 && !f.customKeep(mates):
+		res.Decision = CustomFiltered
+	case f.Fraction > 0 && f.Rand.Float64() >= f.Fraction:
+		res.Decision = SampledOut
+	case f.Every > 0 && !f.takeEvery():
+		res.Decision = SampledOut
+	case f.Seen != nil && f.Seen.Seen(key):
+		res.Decision = Duplicate
+	default:
+		res.Decision = Included
+		if f.Trim > 0 {
+			for i := range mates {
+				mates[i].Trim(f.Trim)
+			}
+		}
+		for i := range mates {
+			if i < len(f.RevComp) && f.RevComp[i] {
+				mates[i].ReverseComplement()
+			}
+		}
+		if f.Seen != nil {
+			f.Seen.Mark(key)
+		}
+	}
+	return res, f.customErr
 }
 
-func (a *AmbiWriter) Close() error {
-	if a.gz != nil {
-		if err := a.gz.Close(); err != nil {
-			return err
+/* Whether every one of Custom keeps the read. An error turns it down, and is
+ * kept for Apply to return. */
+func (f *Filter) customKeep(mates []Record) bool {
+	for _, c := range f.Custom {
+		keep, err := c.Keep(mates)
+		if err != nil {
+			f.customErr = err
+			return false
+		}
+		if !keep {
+			return false
 		}
 	}
-	if err := a.fp.Close(); err != nil {
-		return err
-	}
-	return nil
+	return true
 }
 
-func (a *AmbiWriter) Open(fn string) error {
-	if a.r != nil {
-		return fmt.Errorf("AmbiWriter already open")
-	}
-	var err error
-	// If no filename is given, then read from stdin
-	if fn == "" {
-		a.r = os.Stdout
-		return nil
+/* The number of the read's k-mers in Contaminants, over all its mates */
+func (f *Filter) contaminantHits(mates []Record) int {
+	hits := 0
+	for i := range mates {
+		hits += f.Contaminants.Hits(mates[i].Sequence)
 	}
-	a.fp, err = os.Create(fn)
-	if err != nil {
-		return err
-	}
-	if strings.HasSuffix(fn, ".gz") {
-		a.gz = gzip.NewWriter(a.fp)
-		a.r = a.gz
-	} else {
-		a.r = a.fp
-	}
-	return nil
+	return hits
 }
 
-func (a *AmbiWriter) Stdout() {
-	a.r = os.Stdout
+/* Count a read towards Every, returning whether it is one to keep */
+func (f *Filter) takeEvery() bool {
+	i := f.everySeen
+	f.everySeen++
+	return i%f.Every == f.EveryOffset
 }
 
-func main() {
-	flag.Parse()
-	fq := flag.Args()
-
-	if args.ReadsFilename == "" {
-		log.Fatal("Must provide -reads <file> argument")
+/* The key a read is deduplicated on. Mates' sequences are joined with a
+ * character that can't appear in one. */
+func (f *Filter) dedupKey(name string, mates []Record) string {
+	if f.DedupBy == DedupName {
+		return name
 	}
-
-	if len(fq) == 0 {
-		log.Fatal("Must specify at least one fastq file")
+	key := mates[0].Sequence
+	for i := 1; i < len(mates); i++ {
+		key += "\n" + mates[i].Sequence
 	}
+	return key
+}
 
-	// Open the inputs
-	inputs := make([]AmbiReader, len(fq))
-	for i, fn := range fq {
-		if err := inputs[i].Open(fn); err != nil {
-			log.Fatalf("Failed to open %s: %v\n", fn, err)
+/* Whether a header matches any of HeaderRegexps, or there are none */
+func (f *Filter) headerMatches(header string) bool {
+	for _, re := range f.HeaderRegexps {
+		if re.MatchString(header) {
+			return true
 		}
-		defer inputs[i].Close()
 	}
+	return len(f.HeaderRegexps) == 0
+}
 
-	var outputs []AmbiWriter
+/* Whether a mate passes every filter that judges mates on their own: the
+ * length, quality, N, complexity and expression filters. After a read is
+ * MateFiltered, these are the mates that could be kept as singletons. */
+func (f *Filter) MatePasses(rec *Record) bool {
+	return f.lengthOK(rec) &&
+		(!f.Quality.Enabled() || f.Quality.Pass(rec)) &&
+		(!f.LimitN || f.nOK(rec)) &&
+		(f.MinComplexity == 0 || f.complexityOK(rec)) &&
+		(f.Expr == nil || f.Expr.Match(rec))
+}
 
-	if args.Tab {
-		if args.OutPrefix != "" {
-			log.Fatal("Tabular output only supports writing to stdout")
+/* Whether the mates pass a per-mate test, as PairPolicy asks */
+func (f *Filter) matesPass(mates []Record, pass func(rec *Record) bool) bool {
+	for i := range mates {
+		ok := pass(&mates[i])
+		if ok && f.PairPolicy == PairEither {
+			return true
 		}
-	} else {
-		// Prepare the output writers
-
-		outputs = make([]AmbiWriter, len(fq))
-		for i := 0; i < len(fq); i++ {
-			if args.OutPrefix == "" {
-				outputs[i].Stdout()
-			} else {
-				var fn string
-				if len(fq) == 1 {
-					fn = fmt.Sprintf("%s.fq.gz", args.OutPrefix)
-				} else {
-					fn = fmt.Sprintf("%s_%d.fq.gz", args.OutPrefix, i+1)
-				}
-				if err := outputs[i].Open(fn); err != nil {
-					log.Fatalf("Failed to open %s for writing: %v\n", fn, err)
-				}
-				defer outputs[i].Close()
-			}
+		if !ok && f.PairPolicy == PairBoth {
+			return false
 		}
 	}
+	return f.PairPolicy == PairBoth
+}
 
-	// Read in the list of reads
-	reads := AmbiReader{}
-	readsFn := args.ReadsFilename
-	if readsFn == "stdin" {
-		readsFn = ""
+/* Whether a mate's sequence length is within MinLen and MaxLen */
+func (f *Filter) lengthOK(rec *Record) bool {
+	n := len(rec.Sequence)
+	if f.MinLen > 0 && n < f.MinLen {
+		return false
 	}
-	if err := reads.Open(readsFn); err != nil {
-		log.Fatalf("Failed to open %s: %v\n", args.ReadsFilename, err)
+	if f.MaxLen > 0 && n > f.MaxLen {
+		return false
 	}
-	defer reads.Close()
+	return true
+}
 
-	filter := make(map[string]bool)
-	scanner := bufio.NewScanner(reads)
-	for scanner.Scan() {
-		name := scanner.Text()
-		if args.ShortName {
-			name = strings.Fields(name)[0]
+/* Whether the mate MotifMate, or any if it is -1, contains a motif */
+func (f *Filter) motifFound(mates []Record) bool {
+	for i := range mates {
+		if (f.MotifMate < 0 || f.MotifMate == i) && f.Motif.Match(mates[i].Sequence) {
+			return true
 		}
-		filter[name] = true
 	}
+	return false
+}
 
-	// Iterate over the inputs in sync
-	inputScanners := make([]*bufio.Scanner, len(fq))
-	for i := 0; i < len(fq); i++ {
-		inputScanners[i] = bufio.NewScanner(inputs[i])
-		/* Make sure we have a large buffer for long sequences */
-		buf := make([]byte, 0, 1024*1024)
-		inputScanners[i].Buffer(buf, 10*1024*1024)
-	}
-	var enable bool
-	if args.Invert {
-		enable = true
-	} else {
-		enable = false
-	}
-	line_num := 0
-	included := 0
-	excluded := 0
-	var name string
-	sequences := make([]string, len(fq))
-	err := func() error {
-		for {
-			for i := 0; i < len(fq); i++ {
-				if inputScanners[i].Scan() {
-					line := inputScanners[i].Text()
-					if line_num%4 == 0 {
-						if strings.HasPrefix(line, "@") {
-							if i == 0 {
-								name = line[1:len(line)]
-								if args.ShortName {
-									name = strings.Fields(name)[0]
-								}
-								_, enable = filter[name]
-								if args.Invert {
-									enable = !enable
-								}
-							}
-						} else {
-							return fmt.Errorf("Line %d should be a header line, got: %s\n", line_num, line)
-						}
-					}
-					if line_num%4 == 1 {
-						sequences[i] = line
-						if i == 0 {
-							if enable {
-								included++
-							} else {
-								excluded++
-							}
-						}
-					}
-					if enable {
-						if args.Tab {
-							if i+1 == len(fq) && line_num%4 == 1 {
-								outputLine := name
-								for j := 0; j < len(fq); j++ {
-									outputLine = outputLine + "\t" + sequences[j]
-								}
-								fmt.Println(outputLine)
-							}
-						} else {
-							if _, err := io.WriteString(outputs[i], line+"\n"); err != nil {
-								return fmt.Errorf("Failed to write line %d to output %d: %v\n", line_num, i, err)
-							}
-						}
-					}
-				} else {
-					if i == 0 {
-						return nil
-					} else {
-						return fmt.Errorf("Expecting scanner %d to be able to scan\n", i)
-					}
-				}
-			}
-			line_num++
-			if args.Limit > 0 && included >= args.Limit {
-				log.Println("reached limit")
-				return nil
-			}
+/* Whether the sequence of any of the mates matches */
+func anyMateMatches(m *SeqMatcher, mates []Record) bool {
+	for i := range mates {
+		if m.Match(mates[i].Sequence) {
+			return true
 		}
-	}()
-	if err != nil {
-		log.Fatal(err)
 	}
-
-	log.Println("included:", included)
-	log.Println("excluded:", excluded)
+	return false
 }
@@ -0,0 +1,40 @@
+package fqfilter
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestApplyMatchedNames(t *testing.T) {
+	names := make(ExactSet)
+	for _, name := range []string{"read1", "read2", "read3"} {
+		names.Add(name)
+	}
+	records := NewRecordSet()
+	for _, n := range []int{1, 2, 3} {
+		records.Add(n)
+	}
+	f := &Filter{
+		Names:         names,
+		Normalizer:    Normalizer{ShortName: true},
+		HeaderRegexps: []*regexp.Regexp{regexp.MustCompile(`:N:`)},
+		Records:       records,
+	}
+	// read2 is turned down by its header, and read3 by its place
+	for i, header := range []string{"read1 1:N:0:A", "read2 1:Y:0:A", "read4 1:N:0:A", "read3 1:N:0:A"} {
+		f.Record = i + 1
+		if _, err := f.Apply([]Record{{Header: header, Sequence: "ACGT", Plus: "+", Quality: "IIII"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if names.Matched() != 1 {
+		t.Errorf("%d names matched, want 1", names.Matched())
+	}
+	if unmatched, _ := names.Unmatched(); !slices.Equal(unmatched, []string{"read2", "read3"}) {
+		t.Errorf("unmatched names are %v, want [read2 read3]", unmatched)
+	}
+	if records.Matched() != 1 {
+		t.Errorf("%d record numbers matched, want 1", records.Matched())
+	}
+}
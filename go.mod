@@ -0,0 +1,8 @@
+module github.com/kbullaugheysas/fqfilter
+
+go 1.25
+
+require (
+	github.com/klauspost/compress v1.20.1
+	github.com/klauspost/pgzip v1.2.6
+)
@@ -0,0 +1,269 @@
+package fqfilter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/* An index lists the name of each read in a FASTQ file with the offset of its
+ * record, so a few reads can be fetched without reading the whole file. The
+ * first line records the kind of file and its size, to catch a file that has
+ * changed since, and each line after that is a name and an offset, separated
+ * by a tab, in the order of the file. Offsets into BGZF files are virtual
+ * offsets. */
+const indexMagic = "#fqfilter-index"
+
+/* The index file to go with a FASTQ file, by default */
+func IndexFilename(fn string) string {
+	return fn + ".fqi"
+}
+
+/* A FASTQ file that can be seeked to the offset of a record: either plain
+ * text or compressed with BGZF (as bgzip does), but not other gzip files,
+ * which can only be read from the start */
+type IndexedFastq struct {
+	fp   *os.File
+	bgzf *BGZFReader
+	br   *bufio.Reader
+	// The offset of the next line in a plain file
+	offset int64
+	line   []byte
+}
+
+func OpenIndexedFastq(fn string) (*IndexedFastq, error) {
+	fp, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	var start [18]byte
+	n, err := io.ReadFull(fp, start[:])
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		fp.Close()
+		return nil, err
+	}
+	if _, err := fp.Seek(0, io.SeekStart); err != nil {
+		fp.Close()
+		return nil, err
+	}
+	f := &IndexedFastq{fp: fp}
+	switch magic := start[:n]; {
+	case isBGZF(magic):
+		f.bgzf = NewBGZFReader(fp)
+	case bytes.HasPrefix(magic, gzipMagic), bytes.HasPrefix(magic, zstdMagic), bytes.HasPrefix(magic, bzip2Magic), bytes.HasPrefix(magic, xzMagic):
+		fp.Close()
+		return nil, fmt.Errorf("The file is compressed, but not with BGZF, so it can't be indexed; recompress it with bgzip")
+	default:
+		f.br = bufio.NewReaderSize(fp, 64*1024)
+	}
+	return f, nil
+}
+
+/* Whether the file is BGZF rather than plain text */
+func (f *IndexedFastq) BGZF() bool {
+	return f.bgzf != nil
+}
+
+/* The offset of the next record */
+func (f *IndexedFastq) tell() int64 {
+	if f.bgzf != nil {
+		return f.bgzf.Tell()
+	}
+	return f.offset
+}
+
+func (f *IndexedFastq) seek(offset int64) error {
+	if f.bgzf != nil {
+		return f.bgzf.SeekVirtual(offset)
+	}
+	if _, err := f.fp.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	f.br.Reset(f.fp)
+	f.offset = offset
+	return nil
+}
+
+/* Read a line, without its line ending. The last line needn't end in one. */
+func (f *IndexedFastq) readLine() (string, error) {
+	f.line = f.line[:0]
+	if f.bgzf != nil {
+		b := f.bgzf
+		for {
+			if b.pos == len(b.data) {
+				if err := b.readBlock(); err == io.EOF && len(f.line) > 0 {
+					break
+				} else if err != nil {
+					return "", err
+				}
+				continue
+			}
+			if i := bytes.IndexByte(b.data[b.pos:], '\n'); i >= 0 {
+				f.line = append(f.line, b.data[b.pos:b.pos+i]...)
+				b.pos += i + 1
+				break
+			}
+			f.line = append(f.line, b.data[b.pos:]...)
+			b.pos = len(b.data)
+		}
+	} else {
+		for {
+			chunk, err := f.br.ReadSlice('\n')
+			f.offset += int64(len(chunk))
+			f.line = append(f.line, chunk...)
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+			if err == io.EOF && len(f.line) > 0 {
+				break
+			}
+			if err != nil {
+				return "", err
+			}
+			break
+		}
+		f.line = bytes.TrimSuffix(f.line, []byte("\n"))
+	}
+	return strings.TrimSuffix(string(f.line), "\r"), nil
+}
+
+/* Read the record at the current position, checked as FastqReader does */
+func (f *IndexedFastq) readRecord(rec *Record) error {
+	var lines [4]string
+	for j := range lines {
+		line, err := f.readLine()
+		if err == io.EOF && j > 0 {
+			return fmt.Errorf("File ends part way through a record")
+		} else if err != nil {
+			return err
+		}
+		lines[j] = line
+	}
+	if !strings.HasPrefix(lines[0], "@") {
+		return fmt.Errorf("Expected a header line, got: %s\n", lines[0])
+	}
+	if !strings.HasPrefix(lines[2], "+") {
+		return fmt.Errorf("Expected a + separator line, got: %s\n", lines[2])
+	}
+	rec.Header = lines[0][1:]
+	rec.Sequence = lines[1]
+	rec.Plus = lines[2]
+	rec.Quality = lines[3]
+	return nil
+}
+
+/* Read the record at an offset from the index */
+func (f *IndexedFastq) ReadAt(offset int64, rec *Record) error {
+	if err := f.seek(offset); err != nil {
+		return err
+	}
+	if err := f.readRecord(rec); err == io.EOF {
+		return fmt.Errorf("Offset %d is past the last record", offset)
+	} else if err != nil {
+		return fmt.Errorf("Record at offset %d: %v", offset, err)
+	}
+	return nil
+}
+
+func (f *IndexedFastq) Close() error {
+	return f.fp.Close()
+}
+
+/* The name a read is indexed under: the first word of its header */
+func indexName(header string) string {
+	if i := strings.IndexAny(header, " \t"); i >= 0 {
+		return header[:i]
+	}
+	return header
+}
+
+/* Write an index of a FASTQ file, returning the number of records */
+func BuildIndex(fn string, w io.Writer) (int, error) {
+	f, err := OpenIndexedFastq(fn)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.fp.Stat()
+	if err != nil {
+		return 0, err
+	}
+	kind := "plain"
+	if f.BGZF() {
+		kind = "bgzf"
+	}
+	bw := bufio.NewWriterSize(w, DefaultBufferSize)
+	fmt.Fprintf(bw, "%s\t%s\t%d\n", indexMagic, kind, info.Size())
+	records := 0
+	var rec Record
+	for {
+		offset := f.tell()
+		if err := f.readRecord(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return records, fmt.Errorf("Record %d: %v", records+1, err)
+		}
+		records++
+		bw.WriteString(indexName(rec.Header))
+		bw.WriteByte('\t')
+		bw.WriteString(strconv.FormatInt(offset, 10))
+		bw.WriteByte('\n')
+	}
+	return records, bw.Flush()
+}
+
+/* Look up the reads of a FASTQ file whose names, normalized, are in names,
+ * returning the offset of the first record with each name */
+func LookupIndex(fn, index string, names NameSet, norm Normalizer) (map[string]int64, error) {
+	info, err := os.Stat(fn)
+	if err != nil {
+		return nil, err
+	}
+	fp, err := os.Open(index)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	scanner := bufio.NewScanner(fp)
+	buf := make([]byte, 0, 1024*1024)
+	scanner.Buffer(buf, 10*1024*1024)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s is empty", index)
+	}
+	header := strings.Split(scanner.Text(), "\t")
+	if len(header) != 3 || header[0] != indexMagic {
+		return nil, fmt.Errorf("%s is not an fqfilter index", index)
+	}
+	if header[2] != strconv.FormatInt(info.Size(), 10) {
+		return nil, fmt.Errorf("%s has changed since %s was made; run fqfilter index again", fn, index)
+	}
+	found := make(map[string]int64)
+	lines := 1
+	for scanner.Scan() {
+		lines++
+		line := scanner.Text()
+		i := strings.LastIndexByte(line, '\t')
+		if i < 0 {
+			return nil, fmt.Errorf("%s line %d has no offset", index, lines)
+		}
+		offset, err := strconv.ParseInt(line[i+1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d has an invalid offset: %v", index, lines, err)
+		}
+		if i == 0 {
+			continue
+		}
+		name := norm.Name(line[:i])
+		if _, ok := found[name]; !ok && names.Contains(name) {
+			found[name] = offset
+		}
+	}
+	return found, scanner.Err()
+}
@@ -0,0 +1,109 @@
+package fqfilter
+
+import (
+	"fmt"
+	"io"
+)
+
+/* The longest k-mer a KmerSet holds, packed two bits a base */
+const MaxKmerLen = 32
+
+/* The k-mers of a reference, such as PhiX, adapters or a contaminant genome,
+ * to screen reads against without aligning them. K-mers are kept in their
+ * canonical form, the lesser of them and their reverse complement, so reads
+ * from either strand match. K-mers with a base other than A, C, G or T are
+ * left out. */
+type KmerSet struct {
+	K     int
+	kmers map[uint64]struct{}
+}
+
+func NewKmerSet(k int) (*KmerSet, error) {
+	if k < 1 || k > MaxKmerLen {
+		return nil, fmt.Errorf("The k-mer length must be from 1 to %d, not %d", MaxKmerLen, k)
+	}
+	return &KmerSet{K: k, kmers: make(map[uint64]struct{})}, nil
+}
+
+/* Load the k-mers of every sequence in a FASTA file, which may be compressed */
+func LoadKmers(fn string, k, maxLine int) (*KmerSet, error) {
+	s, err := NewKmerSet(k)
+	if err != nil {
+		return nil, err
+	}
+	var input AmbiReader
+	if err := input.Open(fn); err != nil {
+		return nil, err
+	}
+	defer input.Close()
+	fasta := NewFastaReader(input)
+	fasta.SetMaxLineBytes(maxLine)
+	var rec Record
+	for {
+		if err := fasta.Read(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		s.Add(rec.Sequence)
+	}
+	return s, nil
+}
+
+var kmerCode = func() [256]int8 {
+	var code [256]int8
+	for i := range code {
+		code[i] = -1
+	}
+	for i, b := range "ACGT" {
+		code[b] = int8(i)
+		code[b+'a'-'A'] = int8(i)
+	}
+	return code
+}()
+
+/* Call f with the canonical form of each k-mer in seq */
+func (s *KmerSet) each(seq string, f func(kmer uint64)) {
+	mask := uint64(1)<<(2*s.K) - 1
+	if s.K == MaxKmerLen {
+		mask = ^uint64(0)
+	}
+	shift := 2 * (s.K - 1)
+	var fwd, rev uint64
+	run := 0
+	for i := 0; i < len(seq); i++ {
+		c := kmerCode[seq[i]]
+		if c < 0 {
+			run = 0
+			continue
+		}
+		fwd = (fwd<<2 | uint64(c)) & mask
+		rev = rev>>2 | uint64(3-c)<<shift
+		if run++; run >= s.K {
+			f(min(fwd, rev))
+		}
+	}
+}
+
+/* Add the k-mers of a sequence */
+func (s *KmerSet) Add(seq string) {
+	s.each(seq, func(kmer uint64) {
+		s.kmers[kmer] = struct{}{}
+	})
+}
+
+/* The number of different k-mers */
+func (s *KmerSet) Len() int {
+	return len(s.kmers)
+}
+
+/* The number of k-mers of seq, counting each place it starts, in the set */
+func (s *KmerSet) Hits(seq string) int {
+	hits := 0
+	s.each(seq, func(kmer uint64) {
+		if _, ok := s.kmers[kmer]; ok {
+			hits++
+		}
+	})
+	return hits
+}
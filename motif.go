@@ -0,0 +1,91 @@
+package fqfilter
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+/* The bases each IUPAC code stands for */
+var iupac = map[byte]string{
+	'A': "A", 'C': "C", 'G': "G", 'T': "T", 'U': "T",
+	'R': "AG", 'Y': "CT", 'S': "CG", 'W': "AT", 'K': "GT", 'M': "AC",
+	'B': "CGT", 'D': "AGT", 'H': "ACT", 'V': "ACG", 'N': "ACGTN",
+}
+
+var complement = [256]byte{}
+
+func init() {
+	for i := range complement {
+		complement[i] = byte(i)
+	}
+	for _, pair := range []string{"AT", "CG", "RY", "KM", "BV", "DH", "at", "cg", "ry", "km", "bv", "dh"} {
+		complement[pair[0]], complement[pair[1]] = pair[1], pair[0]
+	}
+	complement['U'], complement['u'] = 'A', 'a'
+}
+
+/* The reverse complement of a sequence, which may use IUPAC codes. Other
+ * characters, like N, are left as they are. */
+func ReverseComplement(seq string) string {
+	b := make([]byte, len(seq))
+	for i := 0; i < len(seq); i++ {
+		b[len(seq)-1-i] = complement[seq[i]]
+	}
+	return string(b)
+}
+
+/* Turn a record into its other strand: reverse complement the sequence and
+ * reverse the quality to match */
+func (r *Record) ReverseComplement() {
+	r.Sequence = ReverseComplement(r.Sequence)
+	q := []byte(r.Quality)
+	slices.Reverse(q)
+	r.Quality = string(q)
+}
+
+/* Finds reads containing any of a set of motifs. A motif made only of IUPAC
+ * codes matches the bases they stand for, so ACGN matches ACGA, ACGC and so
+ * on; anything else is taken as a regular expression. Matching ignores case.
+ * With RevComp, the reverse complement of the sequence is searched too. */
+type MotifMatcher struct {
+	RevComp bool
+	re      *regexp.Regexp
+}
+
+func NewMotifMatcher(motifs []string) (*MotifMatcher, error) {
+	var alts []string
+	for _, m := range motifs {
+		alts = append(alts, motifRegexp(m))
+	}
+	re, err := regexp.Compile("(?i)(?:" + strings.Join(alts, ")|(?:") + ")")
+	if err != nil {
+		return nil, err
+	}
+	return &MotifMatcher{re: re}, nil
+}
+
+/* A motif as a regular expression */
+func motifRegexp(motif string) string {
+	var b strings.Builder
+	for i := 0; i < len(motif); i++ {
+		bases, ok := iupac[motif[i]&^0x20]
+		if !ok {
+			return motif
+		}
+		if len(bases) == 1 {
+			b.WriteString(bases)
+		} else {
+			b.WriteString("[" + bases + "]")
+		}
+	}
+	return b.String()
+}
+
+/* Whether a sequence contains one of the motifs */
+func (m *MotifMatcher) Match(seq string) bool {
+	if m.re.MatchString(seq) {
+		return true
+	}
+	return m.RevComp && m.re.MatchString(ReverseComplement(seq))
+}
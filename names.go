@@ -0,0 +1,258 @@
+package fqfilter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+/* How read names, from either the reads file or a FASTQ header, are put into
+ * the form used for matching. The zero value matches names as they are. */
+type Normalizer struct {
+	// Use just the first space-separated word of the name
+	ShortName bool
+	// Remove a trailing /1 or /2, or an Illumina 1:N:0: style tag
+	StripMate bool
+	// With StripMate, remove a trailing .1 or .2 as well. This is off by
+	// default, since in SRA names like SRR001.1 it numbers the spot.
+	StripMateDot bool
+	// Lowercase the names, for case-insensitive matching
+	IgnoreCase bool
+	// The entries are regular expressions, so keep their case
+	Regexp bool
+}
+
+/* Illumina's "1:N:0:BARCODE" comment, following the name after a space */
+var mateTag = regexp.MustCompile(`\s+[12]:[YN]:\d+:\S*$`)
+
+/* Remove the mate number from a read name, either as a /1 or /2 suffix or as
+ * the Illumina comment */
+func stripMate(name string) string {
+	if loc := mateTag.FindStringIndex(name); loc != nil {
+		name = name[:loc[0]]
+	}
+	if strings.HasSuffix(name, "/1") || strings.HasSuffix(name, "/2") {
+		name = name[:len(name)-2]
+	}
+	return name
+}
+
+/* The first space-separated word of s, as strings.Fields would give it, but
+ * without building a slice of every word for each read */
+func firstWord(s string) string {
+	start := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsSpace(r) })
+	if start < 0 {
+		return ""
+	}
+	s = s[start:]
+	if end := strings.IndexFunc(s, unicode.IsSpace); end >= 0 {
+		return s[:end]
+	}
+	return s
+}
+
+/* The name a read is written under in tabular output: its whole header, or
+ * with ShortName just the first word, as it is in the input. The rest of the
+ * normalizing is only for matching. */
+func (n Normalizer) ReadName(header string) string {
+	if n.ShortName {
+		return firstWord(header)
+	}
+	return header
+}
+
+/* Put a read name into the form used for matching */
+func (n Normalizer) Name(name string) string {
+	name = n.shorten(name)
+	if n.IgnoreCase {
+		name = strings.ToLower(name)
+	}
+	return name
+}
+
+/* Apply ShortName and then StripMate */
+func (n Normalizer) shorten(name string) string {
+	if n.ShortName {
+		name = firstWord(name)
+	}
+	if n.StripMate {
+		name = stripMate(name)
+	}
+	if n.StripMate && n.StripMateDot && (strings.HasSuffix(name, ".1") || strings.HasSuffix(name, ".2")) {
+		name = name[:len(name)-2]
+	}
+	return name
+}
+
+/* Entries for the filter are normalized like read names, except that regular
+ * expressions keep their case (RegexpSet makes them case-insensitive instead,
+ * as lowercasing would change escapes like \S) */
+func (n Normalizer) Entry(entry string) string {
+	if n.Regexp {
+		return n.shorten(entry)
+	}
+	return n.Name(entry)
+}
+
+/* What a reads file holds: a list of names, one per line, or reads or
+ * alignments whose names are extracted */
+type ReadsFormat string
+
+const (
+	ReadsNames ReadsFormat = "names"
+	ReadsFastq ReadsFormat = "fastq"
+	ReadsFasta ReadsFormat = "fasta"
+	ReadsSAM   ReadsFormat = "sam"
+	ReadsBAM   ReadsFormat = "bam"
+	// Not names at all, but the numbers of the reads to select, as a
+	// RecordSet holds them
+	ReadsIndex ReadsFormat = "index"
+)
+
+var readsSuffixes = map[string]ReadsFormat{
+	".fq":    ReadsFastq,
+	".fastq": ReadsFastq,
+	".fa":    ReadsFasta,
+	".fasta": ReadsFasta,
+	".fna":   ReadsFasta,
+	".sam":   ReadsSAM,
+	".bam":   ReadsBAM,
+}
+
+/* Guess the format of a reads file from its suffix, ignoring any compression
+ * suffix. Anything unrecognized is taken to be a list of names. */
+func DetectReadsFormat(fn string) ReadsFormat {
+	fn = remotePath(fn)
+	for _, ext := range []string{".gz", ".zst", ".bz2", ".xz"} {
+		fn = strings.TrimSuffix(fn, ext)
+	}
+	if format, ok := readsSuffixes[strings.ToLower(filepath.Ext(fn))]; ok {
+		return format
+	}
+	return ReadsNames
+}
+
+/* Add the names from a reads file in the given format to the filter,
+ * returning the number of lines (for a list of names) or records read. No
+ * line of a list of names, FASTQ or FASTA may be longer than maxLine bytes,
+ * unless it is 0. */
+func LoadReads(filter NameSet, fn string, format ReadsFormat, norm Normalizer, flags BamFlags, maxLine int) (int, error) {
+	switch format {
+	case ReadsNames:
+		return LoadNames(filter, fn, norm, maxLine)
+	case ReadsFastq:
+		return loadNamesFastq(filter, fn, norm, maxLine)
+	case ReadsFasta:
+		return loadNamesFasta(filter, fn, norm, maxLine)
+	case ReadsSAM:
+		return LoadNamesSAM(filter, fn, norm, flags)
+	case ReadsBAM:
+		return LoadNamesBAM(filter, fn, norm, flags)
+	}
+	return 0, fmt.Errorf("Unknown reads file format %s", format)
+}
+
+/* Open a reads file, where "stdin" means standard input */
+func openReads(fn string) (*AmbiReader, error) {
+	reads := &AmbiReader{}
+	readsFn := fn
+	if readsFn == "stdin" {
+		readsFn = ""
+	}
+	if err := reads.Open(readsFn); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("The reads file does not exist")
+		}
+		return nil, err
+	}
+	return reads, nil
+}
+
+/* Add the name of every record in a FASTQ file */
+func loadNamesFastq(filter NameSet, fn string, norm Normalizer, maxLine int) (int, error) {
+	reads, err := openReads(fn)
+	if err != nil {
+		return 0, err
+	}
+	defer reads.Close()
+
+	fq := NewFastqReader(reads)
+	fq.SetMaxLineBytes(maxLine)
+	records := 0
+	var rec Record
+	for {
+		if err := fq.Read(&rec); err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return records, err
+		}
+		records++
+		if err := filter.Add(norm.Entry(rec.Header)); err != nil {
+			return records, fmt.Errorf("Invalid entry in record %d: %v", records, err)
+		}
+	}
+}
+
+/* Add the name from every > header line in a FASTA file. The sequence lines,
+ * however many there are, are skipped. */
+func loadNamesFasta(filter NameSet, fn string, norm Normalizer, maxLine int) (int, error) {
+	reads, err := openReads(fn)
+	if err != nil {
+		return 0, err
+	}
+	defer reads.Close()
+
+	records := 0
+	lines := 0
+	scanner := bufio.NewScanner(reads)
+	maxLine = setMaxLine(scanner, maxLine)
+	for scanner.Scan() {
+		lines++
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if !strings.HasPrefix(line, ">") {
+			continue
+		}
+		records++
+		if err := filter.Add(norm.Entry(line[1:])); err != nil {
+			return records, fmt.Errorf("Invalid entry in record %d: %v", records, err)
+		}
+	}
+	if scanner.Err() == bufio.ErrTooLong {
+		return records, lineTooLong(lines+1, maxLine, nil)
+	}
+	return records, scanner.Err()
+}
+
+/* Add every name in a reads file to the filter, returning the number of lines
+ * read. Blank lines are skipped, and lines longer than maxLine bytes are an
+ * error, unless it is 0. A filename of "stdin" reads from standard input. */
+func LoadNames(filter NameSet, fn string, norm Normalizer, maxLine int) (int, error) {
+	reads, err := openReads(fn)
+	if err != nil {
+		return 0, err
+	}
+	defer reads.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(reads)
+	maxLine = setMaxLine(scanner, maxLine)
+	for scanner.Scan() {
+		lines++
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if err := filter.Add(norm.Entry(line)); err != nil {
+			return lines, fmt.Errorf("Invalid entry on line %d: %v", lines, err)
+		}
+	}
+	if scanner.Err() == bufio.ErrTooLong {
+		return lines, lineTooLong(lines+1, maxLine, nil)
+	}
+	return lines, scanner.Err()
+}
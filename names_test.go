@@ -0,0 +1,82 @@
+package fqfilter
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestIgnoreCase(t *testing.T) {
+	norm := Normalizer{IgnoreCase: true}
+	set := make(ExactSet)
+	if err := set.Add(norm.Entry("Read_ABC")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"read_abc", "READ_ABC", "Read_ABC"} {
+		if !set.Contains(norm.Name(name)) {
+			t.Errorf("%s doesn't match Read_ABC with IgnoreCase", name)
+		}
+	}
+	exact := make(ExactSet)
+	var plain Normalizer
+	if err := exact.Add(plain.Entry("Read_ABC")); err != nil {
+		t.Fatal(err)
+	}
+	if exact.Contains(plain.Name("read_abc")) {
+		t.Errorf("read_abc matches Read_ABC without IgnoreCase")
+	}
+}
+
+func TestReadName(t *testing.T) {
+	tests := []struct {
+		norm   Normalizer
+		header string
+		want   string
+	}{
+		{Normalizer{}, "Read1 1:N:0:ACGT", "Read1 1:N:0:ACGT"},
+		{Normalizer{IgnoreCase: true, StripMate: true}, "Read1/1", "Read1/1"},
+		{Normalizer{ShortName: true}, "Read1 1:N:0:ACGT", "Read1"},
+		{Normalizer{ShortName: true, IgnoreCase: true}, "Read1\textra", "Read1"},
+	}
+	for _, tt := range tests {
+		if got := tt.norm.ReadName(tt.header); got != tt.want {
+			t.Errorf("%+v.ReadName(%q) = %q, want %q", tt.norm, tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestLoadNamesCRLF(t *testing.T) {
+	fn := t.TempDir() + "/names.txt"
+	if err := os.WriteFile(fn, []byte("read1\r\nread2\r\n\r\nread3\r\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	set := make(ExactSet)
+	if _, err := LoadNames(set, fn, Normalizer{}, DefaultMaxLineBytes); err != nil {
+		t.Fatal(err)
+	}
+	if set.Len() != 3 {
+		t.Errorf("loaded %d names, want 3", set.Len())
+	}
+	for _, name := range []string{"read1", "read2", "read3"} {
+		if !set.Contains(name) {
+			t.Errorf("%s was not loaded, or kept its \\r", name)
+		}
+	}
+}
+
+func TestLoadNamesMaxLine(t *testing.T) {
+	fn := t.TempDir() + "/names.txt"
+	if err := os.WriteFile(fn, []byte("read1\n"+strings.Repeat("x", 100)+"\nread3\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadNames(make(ExactSet), fn, Normalizer{}, 64); err == nil || !strings.Contains(err.Error(), "Line 2 ") {
+		t.Errorf("a 100 byte line with a limit of 64 gave %v, want an error for line 2", err)
+	}
+	set := make(ExactSet)
+	if _, err := LoadNames(set, fn, Normalizer{}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if set.Len() != 3 {
+		t.Errorf("loaded %d names with no limit, want 3", set.Len())
+	}
+}
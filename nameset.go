@@ -0,0 +1,361 @@
+package fqfilter
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/* A NameSet holds the entries from the reads file and decides whether a read
+ * name matches any of them */
+type NameSet interface {
+	Add(entry string) error
+	Contains(name string) bool
+	Len() int
+	// Number of entries that matched at least one read so far
+	Matched() int
+	// The entries that haven't matched any read, in sorted order
+	Unmatched() ([]string, error)
+}
+
+/* Exact matching of read names. The value records whether the name has
+ * matched a read yet. */
+type ExactSet map[string]bool
+
+func (s ExactSet) Add(entry string) error {
+	if _, ok := s[entry]; !ok {
+		s[entry] = false
+	}
+	return nil
+}
+
+func (s ExactSet) Contains(name string) bool {
+	hit, ok := s[name]
+	if ok && !hit {
+		s[name] = true
+	}
+	return ok
+}
+
+func (s ExactSet) Len() int {
+	return len(s)
+}
+
+func (s ExactSet) Matched() int {
+	n := 0
+	for _, hit := range s {
+		if hit {
+			n++
+		}
+	}
+	return n
+}
+
+func (s ExactSet) Unmatched() ([]string, error) {
+	var names []string
+	for name, hit := range s {
+		if !hit {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+/* Width of the read name hashes stored by HashSet */
+const HashBits = 64
+
+/* Exact matching on a 64-bit FNV-1a hash of each name rather than the name
+ * itself, stored as a sorted slice (8 bytes per entry). A name that is not in
+ * the list falsely matches with probability of about n/2^64 for n entries,
+ * e.g. 5e-12 for 100 million names. With -invert such a read would be
+ * wrongly dropped. */
+type HashSet struct {
+	hashes []uint64
+	hits   []bool
+	sorted bool
+}
+
+func hashName(name string) uint64 {
+	h := fnv.New64a()
+	io.WriteString(h, name)
+	return h.Sum64()
+}
+
+func (s *HashSet) Add(entry string) error {
+	s.hashes = append(s.hashes, hashName(entry))
+	s.sorted = false
+	return nil
+}
+
+func (s *HashSet) prepare() {
+	sort.Slice(s.hashes, func(i, j int) bool { return s.hashes[i] < s.hashes[j] })
+	kept := s.hashes[:0]
+	for i, h := range s.hashes {
+		if i == 0 || h != s.hashes[i-1] {
+			kept = append(kept, h)
+		}
+	}
+	s.hashes = kept
+	s.hits = make([]bool, len(kept))
+	s.sorted = true
+}
+
+func (s *HashSet) Contains(name string) bool {
+	if !s.sorted {
+		s.prepare()
+	}
+	h := hashName(name)
+	i := sort.Search(len(s.hashes), func(i int) bool { return s.hashes[i] >= h })
+	if i < len(s.hashes) && s.hashes[i] == h {
+		s.hits[i] = true
+		return true
+	}
+	return false
+}
+
+func (s *HashSet) Len() int {
+	if !s.sorted {
+		s.prepare()
+	}
+	return len(s.hashes)
+}
+
+func (s *HashSet) Matched() int {
+	return countHits(s.hits)
+}
+
+func (s *HashSet) Unmatched() ([]string, error) {
+	return nil, fmt.Errorf("names are not kept with -set-mode hash")
+}
+
+func countHits(hits []bool) int {
+	n := 0
+	for _, hit := range hits {
+		if hit {
+			n++
+		}
+	}
+	return n
+}
+
+/* The names of reads already written, for -dedup. This grows with the output,
+ * so it can keep just the hashes of the names instead. */
+type SeenNames struct {
+	names  map[string]bool
+	hashes map[uint64]bool
+}
+
+func NewSeenNames(hashed bool) *SeenNames {
+	if hashed {
+		return &SeenNames{hashes: make(map[uint64]bool)}
+	}
+	return &SeenNames{names: make(map[string]bool)}
+}
+
+func (s *SeenNames) Seen(name string) bool {
+	if s.hashes != nil {
+		return s.hashes[hashName(name)]
+	}
+	return s.names[name]
+}
+
+func (s *SeenNames) Mark(name string) {
+	if s.hashes != nil {
+		s.hashes[hashName(name)] = true
+	} else {
+		s.names[name] = true
+	}
+}
+
+/* Match read names that start with any of the entries. The entries are sorted
+ * and reduced to a prefix-free list on first lookup, after which the only
+ * candidate for a name is the greatest entry that sorts before it. */
+type PrefixSet struct {
+	prefixes []string
+	hits     []bool
+	sorted   bool
+}
+
+func (s *PrefixSet) Add(entry string) error {
+	s.prefixes = append(s.prefixes, entry)
+	s.sorted = false
+	return nil
+}
+
+func (s *PrefixSet) prepare() {
+	sort.Strings(s.prefixes)
+	kept := s.prefixes[:0]
+	for _, p := range s.prefixes {
+		if len(kept) > 0 && strings.HasPrefix(p, kept[len(kept)-1]) {
+			continue
+		}
+		kept = append(kept, p)
+	}
+	s.prefixes = kept
+	s.hits = make([]bool, len(kept))
+	s.sorted = true
+}
+
+func (s *PrefixSet) Contains(name string) bool {
+	if !s.sorted {
+		s.prepare()
+	}
+	i := sort.SearchStrings(s.prefixes, name)
+	if i < len(s.prefixes) && s.prefixes[i] == name {
+		s.hits[i] = true
+		return true
+	}
+	if i > 0 && strings.HasPrefix(name, s.prefixes[i-1]) {
+		s.hits[i-1] = true
+		return true
+	}
+	return false
+}
+
+/* The number of distinct prefixes, not counting those made redundant by a
+ * shorter prefix */
+func (s *PrefixSet) Len() int {
+	if !s.sorted {
+		s.prepare()
+	}
+	return len(s.prefixes)
+}
+
+func (s *PrefixSet) Matched() int {
+	return countHits(s.hits)
+}
+
+/* Prefixes made redundant by a shorter one are not reported */
+func (s *PrefixSet) Unmatched() ([]string, error) {
+	if !s.sorted {
+		s.prepare()
+	}
+	var names []string
+	for i, p := range s.prefixes {
+		if !s.hits[i] {
+			names = append(names, p)
+		}
+	}
+	return names, nil
+}
+
+/* Match read names against a list of regular expressions, compiled as they
+ * are added. Only the first expression that matches is credited with the hit. */
+type RegexpSet struct {
+	IgnoreCase bool
+	patterns   []*regexp.Regexp
+	hits       []bool
+}
+
+func (s *RegexpSet) Add(entry string) error {
+	if s.IgnoreCase {
+		entry = "(?i)" + entry
+	}
+	re, err := regexp.Compile(entry)
+	if err != nil {
+		return err
+	}
+	s.patterns = append(s.patterns, re)
+	s.hits = append(s.hits, false)
+	return nil
+}
+
+func (s *RegexpSet) Contains(name string) bool {
+	for i, re := range s.patterns {
+		if re.MatchString(name) {
+			s.hits[i] = true
+			return true
+		}
+	}
+	return false
+}
+
+func (s *RegexpSet) Len() int {
+	return len(s.patterns)
+}
+
+func (s *RegexpSet) Matched() int {
+	return countHits(s.hits)
+}
+
+func (s *RegexpSet) Unmatched() ([]string, error) {
+	var names []string
+	for i, re := range s.patterns {
+		if !s.hits[i] {
+			names = append(names, re.String())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+/* How a CombinedSet joins its lists */
+type SetOp int
+
+const (
+	// A name in any of the lists
+	SetUnion SetOp = iota
+	// A name in every list
+	SetIntersect
+	// A name in the first list but none of the others
+	SetSubtract
+)
+
+/* Matches names against several lists at once, each loaded into its own set.
+ * Len, Matched and Unmatched describe the first list, which for a subtraction
+ * is the one the names are taken from; a hit there counts even if a later
+ * list rules the name out. */
+type CombinedSet struct {
+	Op   SetOp
+	Sets []NameSet
+}
+
+func (s *CombinedSet) Add(entry string) error {
+	return fmt.Errorf("Add names to one of the combined sets instead")
+}
+
+func (s *CombinedSet) Contains(name string) bool {
+	switch s.Op {
+	case SetIntersect:
+		for _, set := range s.Sets {
+			if !set.Contains(name) {
+				return false
+			}
+		}
+		return true
+	case SetSubtract:
+		if !s.Sets[0].Contains(name) {
+			return false
+		}
+		for _, set := range s.Sets[1:] {
+			if set.Contains(name) {
+				return false
+			}
+		}
+		return true
+	}
+	found := false
+	for _, set := range s.Sets {
+		// Every list that holds the name is credited with the hit
+		if set.Contains(name) {
+			found = true
+		}
+	}
+	return found
+}
+
+func (s *CombinedSet) Len() int {
+	return s.Sets[0].Len()
+}
+
+func (s *CombinedSet) Matched() int {
+	return s.Sets[0].Matched()
+}
+
+func (s *CombinedSet) Unmatched() ([]string, error) {
+	return s.Sets[0].Unmatched()
+}
@@ -0,0 +1,789 @@
+package fqfilter
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* The layout of written reads */
+type Format int
+
+const (
+	// Four line FASTQ records
+	FormatFastq Format = iota
+	// Header and sequence lines only
+	FormatFasta
+	// The read name then one sequence column per mate, in a single file
+	FormatTab
+	// An unaligned BAM, in a single file
+	FormatBAM
+)
+
+/* How an Output lays out and compresses the reads it writes. Files named from
+ * a prefix end in .gz, or with Zstd, .zst, and are told apart by MateNames,
+ * or if that is unset, by numbering them from 1. FASTA sequences are wrapped
+ * at FastaWidth bases, if it is set. Tabular output can follow each sequence
+ * column with its qualities (TabQuality) and start with a row of column
+ * names (TabHeader).
+ *
+ * A Template replaces this naming, as in {prefix}_R{mate}.fastq.gz, where
+ * {prefix} is the prefix, {mate} the mate's number, {tag} its name in
+ * MateNames (or else its number), {chunk} the chunk's number when ChunkSize
+ * is set, and any other {name} comes from Vars. With a ChunkLimit as well,
+ * only the first ChunkLimit reads of each chunk's ChunkSize are written; the
+ * caller passes over the rest with Skip once the Output is Full.
+ *
+ * BAM output is named prefix.bam, and has reads in the read group ReadGroup,
+ * of the sample ReadGroupSample, if it is set. Its qualities are read with
+ * QualOffset, or if that is unset, DefaultQualOffset.
+ *
+ * With Rename, reads are written under new names, as in sample1_{n} where
+ * {n} counts the reads written from 1 (or from RenameFrom+1, to carry on
+ * from another output), keeping any /1 or /2 on their mates.
+ *
+ * With WriteBehind, the reads are handed over to a goroutine of the Output's
+ * own, in batches of that many, to be laid out and written. */
+type OutputOptions struct {
+	Format          Format
+	FastaWidth      int
+	Zstd            bool
+	MateNames       []string
+	TabQuality      bool
+	TabHeader       bool
+	Template        string
+	Vars            map[string]string
+	ChunkSize       int
+	ChunkLimit      int
+	Rename          string
+	RenameFrom      int
+	ReadGroup       string
+	ReadGroupSample string
+	QualOffset      int
+	// If set, each read written is added to its mate's CycleStats
+	Cycles      []*CycleStats
+	WriteBehind int
+	WriteOptions
+}
+
+/* Where reads are written: one file per input, or a single file for tabular
+ * output. An empty prefix means stdout, and a nil Output discards the reads
+ * (for -count-only). With a ChunkSize, a new set of files is started after
+ * every ChunkSize reads. */
+type Output struct {
+	format     Format
+	width      int
+	tabQual    bool
+	files      []AmbiWriter
+	tab        AmbiWriter
+	bam        *BAMWriter
+	bamFile    *os.File
+	bamFinal   string
+	filenames  []string
+	reads      int
+	bases      int
+	prefix     string
+	n          int
+	opts       OutputOptions
+	chunked    bool
+	chunk      int
+	chunkReads int
+	started    int
+	renamed    int
+	behind     *writeBehind
+}
+
+/* Open an output for n inputs, with files named from the prefix: prefix.fq.gz
+ * for a single input, otherwise prefix_1.fq.gz, prefix_2.fq.gz and so on, or
+ * with MateNames of R1 and R2, prefix_R1.fq.gz and prefix_R2.fq.gz. A prefix
+ * of kept.fq gives kept.fq, or kept_1.fq and kept_2.fq. Chunks are numbered
+ * before the extension, as in prefix_1.chunk0001.fq.gz. */
+func OpenOutput(prefix string, n int, opts OutputOptions) (*Output, error) {
+	o := &Output{format: opts.Format, width: opts.FastaWidth, tabQual: opts.TabQuality, prefix: prefix, n: n, opts: opts, renamed: opts.RenameFrom}
+	if prefix != "" && opts.ChunkSize > 0 {
+		o.chunked = true
+		o.chunk = 1
+	}
+	if err := o.open(); err != nil {
+		return nil, err
+	}
+	o.startBehind()
+	return o, nil
+}
+
+/* Open the files of the current chunk */
+func (o *Output) open() error {
+	filenames, err := outputFilenames(o.prefix, o.n, o.chunk, o.opts)
+	if err != nil {
+		return err
+	}
+	// Check them all first, so none is opened if any would be overwritten
+	if err := o.checkClobber(filenames); err != nil {
+		return err
+	}
+	if o.format == FormatTab {
+		fn := filenames[0]
+		o.tab = AmbiWriter{}
+		if err := o.tab.OpenWith(fn, o.opts.WriteOptions); err != nil {
+			return fmt.Errorf("Failed to open %s for writing: %v\n", fn, err)
+		}
+		o.filenames = append(o.filenames, fn)
+		if o.opts.TabHeader {
+			if err := writeStrings(o.tab, tabHeader(o.n, o.opts), "\n"); err != nil {
+				o.closeFiles()
+				return fmt.Errorf("Failed to write to %s: %v\n", fn, err)
+			}
+		}
+		return nil
+	}
+	if o.format == FormatBAM {
+		return o.openBAM(filenames[0])
+	}
+	o.files = make([]AmbiWriter, len(filenames))
+	for i, fn := range filenames {
+		if err := o.files[i].OpenWith(fn, o.opts.WriteOptions); err != nil {
+			o.closeFiles()
+			return fmt.Errorf("Failed to open %s for writing: %v\n", fn, err)
+		}
+	}
+	o.filenames = append(o.filenames, filenames...)
+	return nil
+}
+
+/* With NoClobber, fail if any of the files exists */
+func (o *Output) checkClobber(filenames []string) error {
+	if !o.opts.NoClobber {
+		return nil
+	}
+	for _, fn := range filenames {
+		if fn == "" || fn == "-" || strings.HasPrefix(fn, "fd://") {
+			continue
+		}
+		if err := checkClobber(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* Open a BAM file, or stdout for "" */
+func (o *Output) openBAM(fn string) error {
+	var w io.Writer = os.Stdout
+	if fn != "" {
+		fp, final, err := createTarget(fn, o.opts.WriteOptions)
+		if err != nil {
+			return fmt.Errorf("Failed to open %s for writing: %v\n", fn, err)
+		}
+		o.bamFile, o.bamFinal = fp, final
+		w = fp
+	}
+	offset := o.opts.QualOffset
+	if offset == 0 {
+		offset = DefaultQualOffset
+	}
+	bam, err := NewBAMWriter(w, o.opts.Level, o.opts.ReadGroup, o.opts.ReadGroupSample, offset)
+	if err != nil {
+		o.closeFiles()
+		return fmt.Errorf("Failed to write to %s: %v\n", fn, err)
+	}
+	o.bam = bam
+	o.filenames = append(o.filenames, fn)
+	return nil
+}
+
+/* Close this chunk's files and open the next */
+func (o *Output) nextChunk() error {
+	if err := o.closeFiles(); err != nil {
+		return err
+	}
+	o.chunk++
+	o.chunkReads = 0
+	return o.open()
+}
+
+/* The files to open for an output, for the given chunk (or 0 if the output
+ * isn't chunked) */
+func outputFilenames(prefix string, n, chunk int, opts OutputOptions) ([]string, error) {
+	if prefix == "" {
+		// A single writer for all the inputs keeps each record whole
+		if opts.Format == FormatTab || opts.Format == FormatBAM {
+			return []string{""}, nil
+		}
+		return []string{"-"}, nil
+	}
+	tag := ""
+	if chunk > 0 {
+		tag = fmt.Sprintf(".chunk%04d", chunk)
+	}
+	if opts.Template != "" {
+		if chunk > 0 {
+			opts.Vars = withVar(opts.Vars, "chunk", fmt.Sprintf("%04d", chunk))
+			if !strings.Contains(opts.Template, "{chunk}") {
+				return nil, fmt.Errorf("The output template %s needs a {chunk} to tell the chunks apart\n", opts.Template)
+			}
+		}
+		if opts.Format == FormatTab || opts.Format == FormatBAM {
+			fn, err := expandTemplate(opts.Template, prefix, -1, opts)
+			return []string{fn}, err
+		}
+		return templateFilenames(prefix, n, opts)
+	}
+	if opts.Format == FormatTab {
+		fn := tabFilename(prefix, opts)
+		if i := strings.LastIndex(fn, ".tsv"); i >= 0 {
+			return []string{fn[:i] + tag + fn[i:]}, nil
+		}
+		return []string{fn + tag}, nil
+	}
+	if opts.Format == FormatBAM {
+		fn := bamFilename(prefix)
+		return []string{strings.TrimSuffix(fn, ".bam") + tag + ".bam"}, nil
+	}
+	// A prefix that already ends like reads.fq keeps its extension, and so
+	// its compression, or lack of it
+	base, ext := splitReadsExt(prefix)
+	if ext == "" {
+		ext = ".fq"
+		if opts.Format == FormatFasta {
+			ext = ".fa"
+		}
+		ext += compressSuffix(opts)
+	}
+	filenames := make([]string, n)
+	for i := 0; i < n; i++ {
+		if n == 1 {
+			filenames[i] = base + tag + ext
+		} else {
+			filenames[i] = fmt.Sprintf("%s_%s%s%s", base, mateName(opts, i), tag, ext)
+		}
+	}
+	return filenames, nil
+}
+
+/* A copy of vars with one more set */
+func withVar(vars map[string]string, name, value string) map[string]string {
+	v := map[string]string{name: value}
+	for k, x := range vars {
+		if k != name {
+			v[k] = x
+		}
+	}
+	return v
+}
+
+/* Split a prefix like kept.fastq.gz into kept and .fastq.gz. Returns the
+ * prefix and an empty extension unless it ends in a FASTQ or FASTA
+ * extension, then optionally .gz or .zst. */
+func splitReadsExt(prefix string) (string, string) {
+	base, compress := prefix, ""
+	for _, c := range []string{".gz", ".zst"} {
+		if strings.HasSuffix(base, c) {
+			base, compress = strings.TrimSuffix(base, c), c
+			break
+		}
+	}
+	ext := filepath.Ext(base)
+	switch strings.ToLower(ext) {
+	case ".fq", ".fastq", ".fa", ".fasta", ".fna":
+		return strings.TrimSuffix(base, ext), ext + compress
+	}
+	return prefix, ""
+}
+
+/* Name the files for n inputs by the template, which must tell them apart */
+func templateFilenames(prefix string, n int, opts OutputOptions) ([]string, error) {
+	filenames := make([]string, n)
+	seen := make(map[string]bool)
+	for i := range filenames {
+		fn, err := expandTemplate(opts.Template, prefix, i, opts)
+		if err != nil {
+			return nil, err
+		}
+		if seen[fn] {
+			return nil, fmt.Errorf("The output template %s names every mate %s, so needs a {mate} or {tag}\n", opts.Template, fn)
+		}
+		seen[fn] = true
+		filenames[i] = fn
+	}
+	return filenames, nil
+}
+
+var placeholder = regexp.MustCompile(`\{([a-z]+)\}`)
+
+/* Fill in the placeholders of a template for mate i, where i of -1 (for
+ * tabular output) leaves {mate} and {tag} undefined */
+func expandTemplate(template, prefix string, i int, opts OutputOptions) (string, error) {
+	var err error
+	fn := placeholder.ReplaceAllStringFunc(template, func(m string) string {
+		name := m[1 : len(m)-1]
+		switch {
+		case name == "prefix":
+			return prefix
+		case name == "mate" && i >= 0:
+			return strconv.Itoa(i + 1)
+		case name == "tag" && i >= 0:
+			return mateName(opts, i)
+		}
+		if v, ok := opts.Vars[name]; ok {
+			return v
+		}
+		if err == nil {
+			err = fmt.Errorf("The output template %s has an unknown placeholder %s\n", template, m)
+		}
+		return m
+	})
+	return fn, err
+}
+
+/* Open an output with one file per input, named explicitly. Compression is
+ * chosen by each name's suffix. */
+func OpenOutputFiles(filenames []string, opts OutputOptions) (*Output, error) {
+	o := &Output{format: opts.Format, width: opts.FastaWidth, files: make([]AmbiWriter, len(filenames)), filenames: filenames, opts: opts, renamed: opts.RenameFrom}
+	if err := o.checkClobber(filenames); err != nil {
+		return nil, err
+	}
+	for i, fn := range filenames {
+		if err := o.files[i].OpenWith(fn, opts.WriteOptions); err != nil {
+			o.Close()
+			return nil, fmt.Errorf("Failed to open %s for writing: %v\n", fn, err)
+		}
+	}
+	o.startBehind()
+	return o, nil
+}
+
+/* The names of the files written, where "" or "-" is stdout */
+func (o *Output) Filenames() []string {
+	if o == nil {
+		return nil
+	}
+	return o.filenames
+}
+
+/* The number of records, counting each mate, and of bases written so far */
+func (o *Output) Written() (int, int) {
+	if o == nil {
+		return 0, 0
+	}
+	return o.reads, o.bases
+}
+
+/* Write one read: a record from each mate, under the given name. When there is
+ * a single output file all the mates go to it, one after another. */
+func (o *Output) Write(name string, mates []Record) error {
+	if o == nil {
+		return nil
+	}
+	o.started++
+	if o.behind != nil {
+		return o.behind.add(pendingRead{name: name, mates: append([]Record(nil), mates...), mate: -1})
+	}
+	return o.write(name, mates)
+}
+
+func (o *Output) write(name string, mates []Record) error {
+	for i := range mates {
+		if i < len(o.opts.Cycles) {
+			o.opts.Cycles[i].Add(&mates[i])
+		}
+	}
+	if err := o.startRead(); err != nil {
+		return err
+	}
+	if o.opts.Rename != "" {
+		name = o.nextName()
+	}
+	if o.format == FormatTab {
+		err := writeStrings(o.tab, name)
+		for j := 0; j < len(mates) && err == nil; j++ {
+			err = writeStrings(o.tab, "\t", mates[j].Sequence)
+			if err == nil && o.tabQual {
+				err = writeStrings(o.tab, "\t", mates[j].Quality)
+			}
+		}
+		if err == nil {
+			err = writeStrings(o.tab, "\n")
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to write %s to tabular output: %v\n", name, err)
+		}
+		for j := range mates {
+			o.reads++
+			o.bases += len(mates[j].Sequence)
+		}
+		return nil
+	}
+	for i := range mates {
+		rec := &mates[i]
+		if o.opts.Rename != "" {
+			renamed := *rec
+			renamed.Header = name + mateSuffix(rec.Header)
+			rec = &renamed
+		}
+		if err := o.writeMate(i, len(mates), rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* The name for the next read written, with Rename */
+func (o *Output) nextName() string {
+	o.renamed++
+	return strings.ReplaceAll(o.opts.Rename, "{n}", strconv.Itoa(o.renamed))
+}
+
+/* The /1 or /2 ending the name in a header, if it has one */
+func mateSuffix(header string) string {
+	name := header
+	if i := strings.IndexAny(header, " \t"); i >= 0 {
+		name = header[:i]
+	}
+	if strings.HasSuffix(name, "/1") || strings.HasSuffix(name, "/2") {
+		return name[len(name)-2:]
+	}
+	return ""
+}
+
+/* Write a single mate, without its partners, to the file for mate i (or to
+ * the only file). In tabular output it gets a line of its own. */
+func (o *Output) WriteMate(i int, rec *Record) error {
+	if o == nil {
+		return nil
+	}
+	o.started++
+	if o.behind != nil {
+		return o.behind.add(pendingRead{mates: []Record{*rec}, mate: i})
+	}
+	return o.writeSingle(i, rec)
+}
+
+func (o *Output) writeSingle(i int, rec *Record) error {
+	if err := o.startRead(); err != nil {
+		return err
+	}
+	if o.opts.Rename != "" {
+		renamed := *rec
+		renamed.Header = o.nextName() + mateSuffix(rec.Header)
+		rec = &renamed
+	}
+	return o.writeMate(i, 1, rec)
+}
+
+/* Count a read towards the chunk, starting the next one if this is full */
+func (o *Output) startRead() error {
+	if o.chunk == 0 {
+		return nil
+	}
+	if o.chunkReads == o.opts.ChunkSize {
+		if err := o.nextChunk(); err != nil {
+			return err
+		}
+	}
+	o.chunkReads++
+	return nil
+}
+
+/* Whether the chunk being written has had its ChunkLimit reads, so that the
+ * reads up to its ChunkSize are to be passed over with Skip. This goes by the
+ * reads given so far, which may not all be written yet. */
+func (o *Output) Full() bool {
+	return o != nil && o.chunked && o.opts.ChunkLimit > 0 && o.started%o.opts.ChunkSize >= o.opts.ChunkLimit
+}
+
+/* Count a read towards the chunk without writing it */
+func (o *Output) Skip() {
+	if o == nil || !o.chunked {
+		return
+	}
+	o.started++
+	if o.behind != nil {
+		o.behind.add(pendingRead{skip: true})
+		return
+	}
+	o.skip()
+}
+
+func (o *Output) skip() {
+	o.chunkReads++
+}
+
+/* Write mate i of a read with n mates */
+func (o *Output) writeMate(i, n int, rec *Record) error {
+	o.reads++
+	o.bases += len(rec.Sequence)
+	if o.format == FormatBAM {
+		if err := o.bam.Write(rec, i, n); err != nil {
+			return fmt.Errorf("Failed to write %s to BAM output: %v\n", rec.Header, err)
+		}
+		return nil
+	}
+	if o.format == FormatTab {
+		err := writeStrings(o.tab, rec.Header, "\t", rec.Sequence)
+		if err == nil && o.tabQual {
+			err = writeStrings(o.tab, "\t", rec.Quality)
+		}
+		if err == nil {
+			err = writeStrings(o.tab, "\n")
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to write %s to tabular output: %v\n", rec.Header, err)
+		}
+		return nil
+	}
+	f := i
+	if len(o.files) == 1 {
+		f = 0
+	}
+	var err error
+	if o.format == FormatFasta {
+		// Only the header (as >name) and sequence lines go out
+		err = writeStrings(o.files[f], ">", rec.Header, "\n")
+		if err == nil {
+			err = o.writeSequence(o.files[f], rec.Sequence)
+		}
+	} else {
+		err = writeStrings(o.files[f], "@", rec.Header, "\n", rec.Sequence, "\n", rec.Plus, "\n", rec.Quality, "\n")
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to write %s to output %d: %v\n", rec.Header, f+1, err)
+	}
+	return nil
+}
+
+/* Write a FASTA sequence, split into lines of the output's width */
+func (o *Output) writeSequence(w AmbiWriter, seq string) error {
+	for o.width > 0 && len(seq) > o.width {
+		if err := writeStrings(w, seq[:o.width], "\n"); err != nil {
+			return err
+		}
+		seq = seq[o.width:]
+	}
+	return writeStrings(w, seq, "\n")
+}
+
+/* Write the pieces of a record one after another, rather than building the
+ * whole string */
+func writeStrings(w AmbiWriter, parts ...string) error {
+	for _, part := range parts {
+		if _, err := w.WriteString(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* Flush and close the output files. This must be checked, since a failed
+ * flush loses the end of the output. With WriteBehind, the reads still
+ * waiting are written first. */
+func (o *Output) Close() error {
+	if o == nil {
+		return nil
+	}
+	if o.behind != nil {
+		err := o.behind.stop()
+		o.behind = nil
+		if err != nil {
+			o.closeFiles()
+			return err
+		}
+	}
+	return o.closeFiles()
+}
+
+func (o *Output) closeFiles() error {
+	if o.format == FormatTab {
+		return o.tab.Close()
+	}
+	if o.format == FormatBAM {
+		return o.closeBAM()
+	}
+	// Every file is closed, so each is renamed into place or removed, even
+	// once one has failed
+	var first error
+	for i := range o.files {
+		if o.files[i].r == nil {
+			continue
+		}
+		if err := o.files[i].Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (o *Output) closeBAM() error {
+	var err error
+	if o.bam != nil {
+		err = o.bam.Close()
+		o.bam = nil
+	}
+	if o.bamFile != nil {
+		if err != nil {
+			discardTarget(o.bamFile, o.bamFinal)
+		} else {
+			err = closeTarget(o.bamFile, o.bamFinal)
+		}
+		o.bamFile = nil
+	}
+	return err
+}
+
+func mateName(opts OutputOptions, i int) string {
+	if len(opts.MateNames) == 0 {
+		return strconv.Itoa(i + 1)
+	}
+	return opts.MateNames[i]
+}
+
+/* The column names of tabular output: name, then seq_1 (and qual_1) and so
+ * on, or seq_R1 and the like with MateNames */
+func tabHeader(n int, opts OutputOptions) string {
+	cols := []string{"name"}
+	for i := 0; i < n; i++ {
+		cols = append(cols, "seq_"+mateName(opts, i))
+		if opts.TabQuality {
+			cols = append(cols, "qual_"+mateName(opts, i))
+		}
+	}
+	return strings.Join(cols, "\t")
+}
+
+/* Output files are gzipped unless level 0 asks for plain text */
+func compressSuffix(opts OutputOptions) string {
+	if opts.Zstd {
+		return ".zst"
+	}
+	if opts.Level == 0 {
+		return ""
+	}
+	return ".gz"
+}
+
+/* Tabular output goes to a single file. The prefix is used as-is if it already
+ * names a .tsv, .gz or .zst file, otherwise we add .tsv (and .gz or .zst if
+ * compressing) */
+func tabFilename(prefix string, opts OutputOptions) string {
+	if strings.HasSuffix(prefix, ".tsv") || strings.HasSuffix(prefix, ".gz") || strings.HasSuffix(prefix, ".zst") {
+		return prefix
+	}
+	return prefix + ".tsv" + compressSuffix(opts)
+}
+
+/* The R1, R2, I1 or I2 in an Illumina style filename, like
+ * S1_L001_R1_001.fastq.gz */
+var readTag = regexp.MustCompile(`[_.]([RI][1-4])(?:[_.]|$)`)
+
+/* Name the mates by the read tags in their filenames, so R1, R2, I1 and I2
+ * inputs are written to _R1, _R2, _I1 and _I2 outputs. Returns nil unless
+ * every input has a tag and they are all different. */
+func MateNamesFromFiles(filenames []string) []string {
+	names := make([]string, len(filenames))
+	seen := make(map[string]bool)
+	for i, fn := range filenames {
+		m := readTag.FindAllStringSubmatch(filepath.Base(fn), -1)
+		if m == nil {
+			return nil
+		}
+		// The last tag wins, as sample names may hold something like _R1 too
+		names[i] = m[len(m)-1][1]
+		if seen[names[i]] {
+			return nil
+		}
+		seen[names[i]] = true
+	}
+	return names
+}
+
+/* The 1 or 2 ending a name like reads_1.fq.gz, before its extension */
+var mateNumber = regexp.MustCompile(`[_.]([12])\.(?:fastq|fq|fasta|fa|fna)(?:\.|$)`)
+
+/* The mate tag in a filename, as in MateNamesFromFiles or else the 1 of
+ * reads_1.fq.gz, and the filename with the tag taken out, which the files
+ * of one lane share, or "" if it has no tag */
+func fileMateTag(fn string) (string, string) {
+	dir, base := filepath.Split(fn)
+	m := readTag.FindAllStringSubmatchIndex(base, -1)
+	if m == nil {
+		m = mateNumber.FindAllStringSubmatchIndex(base, -1)
+	}
+	if m == nil {
+		return "", ""
+	}
+	last := m[len(m)-1]
+	return base[last[2]:last[3]], dir + base[:last[2]] + "*" + base[last[3]:]
+}
+
+/* Where a mate tag puts its file in a lane: R1 and R2 first (or 1 and 2),
+ * then the index reads */
+func mateOrder(tag string) string {
+	if strings.HasPrefix(tag, "I") {
+		return "2" + tag
+	}
+	return "1" + tag
+}
+
+/* Group files into lanes by their names, putting the files that differ only
+ * in their R1, R2, I1 or I2 tag (or the 1 or 2 of reads_1.fq.gz) in one
+ * lane, in that order. The lanes come in the order of their first files.
+ * Files with no tags at all are a lane each, of a single mate. */
+func GroupMateFiles(filenames []string) ([][]string, error) {
+	var lanes [][]string
+	byKey := make(map[string]int)
+	untagged := ""
+	for _, fn := range filenames {
+		tag, key := fileMateTag(fn)
+		if tag == "" {
+			untagged = fn
+			lanes = append(lanes, []string{fn})
+			continue
+		}
+		i, ok := byKey[key]
+		if !ok {
+			i = len(lanes)
+			byKey[key] = i
+			lanes = append(lanes, nil)
+		}
+		lanes[i] = append(lanes[i], fn)
+	}
+	if untagged != "" && len(byKey) > 0 {
+		return nil, fmt.Errorf("%s has no R1 or R2 in its name to pair it by, unlike the other files", untagged)
+	}
+	for _, lane := range lanes {
+		sort.SliceStable(lane, func(i, j int) bool {
+			return mateOrder(laneTags(lane[i:i+1])) < mateOrder(laneTags(lane[j:j+1]))
+		})
+		tags := laneTags(lane)
+		if tags != laneTags(lanes[0]) {
+			return nil, fmt.Errorf("%s has mates %s, but %s has mates %s", strings.Join(lane, ","), tags, strings.Join(lanes[0], ","), laneTags(lanes[0]))
+		}
+		for i := 1; i < len(lane); i++ {
+			if a, b := laneTags(lane[i-1:i]), laneTags(lane[i:i+1]); a == b {
+				return nil, fmt.Errorf("%s and %s are both mate %s", lane[i-1], lane[i], a)
+			}
+		}
+	}
+	return lanes, nil
+}
+
+/* The tags of a lane's files, separated by commas */
+func laneTags(lane []string) string {
+	tags := make([]string, len(lane))
+	for i, fn := range lane {
+		tags[i], _ = fileMateTag(fn)
+	}
+	return strings.Join(tags, ",")
+}
+
+/* Whether two files look like mates of one lane, differing only in their
+ * tags, or have no tags to tell by */
+func SameLane(a, b string) bool {
+	ta, ka := fileMateTag(a)
+	tb, kb := fileMateTag(b)
+	return ta == "" || tb == "" || ka == kb && ta != tb
+}
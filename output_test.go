@@ -0,0 +1,163 @@
+package fqfilter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOutputCloseAll(t *testing.T) {
+	dir := t.TempDir()
+	o, err := OpenOutput(filepath.Join(dir, "kept"), 2, OutputOptions{WriteOptions: WriteOptions{Atomic: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mates := []Record{
+		{Header: "read1/1", Sequence: "ACGT", Plus: "+", Quality: "IIII"},
+		{Header: "read1/2", Sequence: "TTGA", Plus: "+", Quality: "IIII"},
+	}
+	if err := o.Write("read1", mates); err != nil {
+		t.Fatal(err)
+	}
+	// A directory, not empty, in the way of the first file can't be
+	// renamed over
+	fns := o.Filenames()
+	if err := os.MkdirAll(filepath.Join(fns[0], "x"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Close(); err == nil {
+		t.Errorf("Close succeeded, with %s in the way", fns[0])
+	}
+	if info, err := os.Stat(fns[1]); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("%s was not renamed into place after the first file failed: %v", fns[1], err)
+	}
+	tmp, _ := filepath.Glob(filepath.Join(dir, ".*.tmp*"))
+	if len(tmp) > 0 {
+		t.Errorf("temporary files left behind: %v", tmp)
+	}
+}
+
+func TestOutputChunkLimit(t *testing.T) {
+	dir := t.TempDir()
+	opts := OutputOptions{Template: "{prefix}.{chunk}.fq", ChunkSize: 3, ChunkLimit: 2}
+	o, err := OpenOutput(filepath.Join(dir, "kept"), 1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skipped := 0
+	for i := 0; i < 7; i++ {
+		if o.Full() {
+			o.Skip()
+			skipped++
+			continue
+		}
+		rec := []Record{{Header: "read", Sequence: "ACGT", Plus: "+", Quality: "IIII"}}
+		if err := o.Write("read", rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if skipped != 2 {
+		t.Errorf("skipped %d reads, want 2", skipped)
+	}
+	for _, c := range []struct {
+		chunk string
+		reads int
+	}{{"0001", 2}, {"0002", 2}, {"0003", 1}} {
+		data, err := os.ReadFile(filepath.Join(dir, "kept."+c.chunk+".fq"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := bytes.Count(data, []byte("\n")) / 4; n != c.reads {
+			t.Errorf("chunk %s has %d reads, want %d", c.chunk, n, c.reads)
+		}
+	}
+}
+
+/* Writing in the background gives the same files, chunked and limited
+ * alike */
+func TestOutputWriteBehind(t *testing.T) {
+	write := func(opts OutputOptions) []string {
+		dir := t.TempDir()
+		o, err := OpenOutput(filepath.Join(dir, "kept"), 2, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10; i++ {
+			if o.Full() {
+				o.Skip()
+				continue
+			}
+			name := fmt.Sprintf("read%d", i)
+			mates := []Record{
+				{Header: name + "/1", Sequence: "ACGT", Plus: "+", Quality: "IIII"},
+				{Header: name + "/2", Sequence: "TTGA", Plus: "+", Quality: "IIII"},
+			}
+			if i%4 == 3 {
+				err = o.WriteMate(1, &mates[1])
+			} else {
+				err = o.Write(name, mates)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := o.Close(); err != nil {
+			t.Fatal(err)
+		}
+		var files []string
+		for _, fn := range o.Filenames() {
+			data, err := os.ReadFile(fn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files = append(files, filepath.Base(fn)+"\n"+string(data))
+		}
+		return files
+	}
+	opts := OutputOptions{Template: "{prefix}_{mate}.{chunk}.fq", ChunkSize: 4, ChunkLimit: 3}
+	want := write(opts)
+	opts.WriteBehind = 3
+	got := write(opts)
+	if len(got) != len(want) {
+		t.Fatalf("wrote %d files in the background, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("written in the background:\n%s\nwant:\n%s", got[i], want[i])
+		}
+	}
+}
+
+/* Writing reads into the buffer, with the allocations each costs */
+func BenchmarkOutputWrite(b *testing.B) {
+	var records []Record
+	fq := NewFastqReader(bytes.NewReader(benchFastq()))
+	for {
+		var rec Record
+		if err := fq.Read(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			b.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+	buf := bufio.NewWriterSize(io.Discard, DefaultBufferSize)
+	o := &Output{files: []AmbiWriter{{buf: buf, r: buf}}}
+	b.SetBytes(int64(len(benchFastq())))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for j := range records {
+			if err := o.Write("read", records[j:j+1]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.ReportMetric(float64(len(records)), "reads/op")
+}
@@ -0,0 +1,100 @@
+package fqfilter
+
+import (
+	"fmt"
+	"io"
+)
+
+/* Reads a record from each of several inputs in step, one input per
+ * mate, checking that they all end together. An interleaved reader instead
+ * takes both mates, one after the other, from a single input. With
+ * CheckNames, the mates of each read must also have the same name, once any
+ * /1 or /2 suffix or Illumina comment is removed. */
+type PairedReader struct {
+	CheckNames  bool
+	names       []string
+	readers     []RecordReader
+	interleaved bool
+	records     int
+}
+
+/* Read the inputs in step. The names are used in error messages. */
+func NewPairedReader(names []string, readers []RecordReader) *PairedReader {
+	return &PairedReader{names: names, readers: readers}
+}
+
+/* Read pairs of mates that alternate in a single input */
+func NewInterleavedReader(name string, reader RecordReader) *PairedReader {
+	return &PairedReader{names: []string{name}, readers: []RecordReader{reader}, interleaved: true}
+}
+
+/* The number of records in each read, and so the length of the slice that
+ * Read fills in */
+func (p *PairedReader) Mates() int {
+	if p.interleaved {
+		return 2
+	}
+	return len(p.readers)
+}
+
+/* The number of whole reads read so far */
+func (p *PairedReader) Records() int {
+	return p.records
+}
+
+/* Read the next record of each mate into mates. Returns io.EOF once every
+ * input has ended cleanly, or an error if one ends before the others. */
+func (p *PairedReader) Read(mates []Record) error {
+	for i := range mates {
+		// Interleaved mates all come from the one input
+		r := i
+		if p.interleaved {
+			r = 0
+		}
+		err := p.readers[r].Read(&mates[i])
+		if err == nil {
+			continue
+		}
+		if err != io.EOF {
+			return fmt.Errorf("%s (input %d), read %d: %v", p.names[r], r+1, p.records+1, err)
+		}
+		if i > 0 && p.interleaved {
+			return fmt.Errorf("Interleaved input ended without a mate for %s\n", mates[0].Header)
+		}
+		if i > 0 {
+			return fmt.Errorf("%s (input %d) ended after %d reads, but %s has more\n", p.names[i], i+1, p.records, p.names[0])
+		}
+		// The first input has ended, so the others should have too
+		for j := 1; j < len(p.readers); j++ {
+			var extra Record
+			if err := p.readers[j].Read(&extra); err != io.EOF {
+				return fmt.Errorf("%s ended after %d reads, but %s (input %d) has more\n", p.names[0], p.records, p.names[j], j+1)
+			}
+		}
+		return io.EOF
+	}
+	p.records++
+	if p.CheckNames {
+		return p.checkNames(mates)
+	}
+	return nil
+}
+
+/* The name of the fragment a mate came from */
+func fragmentName(header string) string {
+	return firstWord(stripMate(header))
+}
+
+func (p *PairedReader) checkNames(mates []Record) error {
+	name := fragmentName(mates[0].Header)
+	for i := 1; i < len(mates); i++ {
+		if other := fragmentName(mates[i].Header); other != name {
+			input := p.names[0]
+			if !p.interleaved {
+				input = p.names[i]
+			}
+			return fmt.Errorf("Read %d of %s is %s, but the mate in %s is %s, so the inputs are out of step\n", p.records, p.names[0], name, input, other)
+		}
+	}
+	return nil
+}
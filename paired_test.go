@@ -0,0 +1,75 @@
+package fqfilter
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+/* FASTQ of n reads named read1, read2 and so on, with the Illumina comment
+ * for the given mate */
+func fastqReads(n, mate int) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, "@read%d %d:N:0:ACGT\nACGT\n+\nIIII\n", i, mate)
+	}
+	return b.String()
+}
+
+func threeInputs(r1, r2, i1 int) *PairedReader {
+	readers := []RecordReader{
+		NewFastqReader(strings.NewReader(fastqReads(r1, 1))),
+		NewFastqReader(strings.NewReader(fastqReads(r2, 2))),
+		NewFastqReader(strings.NewReader(fastqReads(i1, 1))),
+	}
+	return NewPairedReader([]string{"R1.fq", "R2.fq", "I1.fq"}, readers)
+}
+
+func TestPairedReaderThreeInputs(t *testing.T) {
+	p := threeInputs(3, 3, 3)
+	if p.Mates() != 3 {
+		t.Fatalf("Mates() = %d, want 3", p.Mates())
+	}
+	mates := make([]Record, p.Mates())
+	for i := 1; i <= 3; i++ {
+		if err := p.Read(mates); err != nil {
+			t.Fatalf("read %d: %v", i, err)
+		}
+		for j := range mates {
+			if want := fmt.Sprintf("read%d", i); strings.Fields(mates[j].Header)[0] != want {
+				t.Errorf("read %d, mate %d is %s, want %s", i, j+1, mates[j].Header, want)
+			}
+		}
+	}
+	if err := p.Read(mates); err != io.EOF {
+		t.Errorf("after the last read got %v, want io.EOF", err)
+	}
+	if p.Records() != 3 {
+		t.Errorf("Records() = %d, want 3", p.Records())
+	}
+}
+
+func TestPairedReaderUnevenInputs(t *testing.T) {
+	tests := []struct {
+		r1, r2, i1 int
+		want       string
+	}{
+		// The index read ends first
+		{3, 3, 2, "I1.fq (input 3) ended after 2 reads, but R1.fq has more"},
+		// The index read has one more than the rest
+		{3, 3, 4, "R1.fq ended after 3 reads, but I1.fq (input 3) has more"},
+		{3, 2, 3, "R2.fq (input 2) ended after 2 reads, but R1.fq has more"},
+	}
+	for _, tt := range tests {
+		p := threeInputs(tt.r1, tt.r2, tt.i1)
+		mates := make([]Record, p.Mates())
+		var err error
+		for err == nil {
+			err = p.Read(mates)
+		}
+		if err == io.EOF || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("with %d, %d and %d reads got %v, want %q", tt.r1, tt.r2, tt.i1, err, tt.want)
+		}
+	}
+}
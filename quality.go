@@ -0,0 +1,131 @@
+package fqfilter
+
+/* The usual offset of Phred scores in FASTQ quality strings (Sanger and
+ * Illumina 1.8+) */
+const DefaultQualOffset = 33
+
+/* Requirements on the base qualities of a record. The zero value, apart from
+ * the offset, passes everything. */
+type QualityFilter struct {
+	// The character that stands for a quality of 0, DefaultQualOffset if unset
+	Offset int
+	// The lowest mean quality over the read
+	MinMean float64
+	// Bases with a quality below MinBase are low quality, and up to
+	// MaxLowFrac of a read's bases may be
+	MinBase    int
+	MaxLowFrac float64
+}
+
+/* Whether any of the tests are turned on */
+func (q QualityFilter) Enabled() bool {
+	return q.MinMean > 0 || q.MinBase > 0
+}
+
+func (q QualityFilter) offset() int {
+	if q.Offset == 0 {
+		return DefaultQualOffset
+	}
+	return q.Offset
+}
+
+/* Whether a record's qualities meet every requirement. An empty read has no
+ * qualities to judge and fails. */
+func (q QualityFilter) Pass(rec *Record) bool {
+	n := len(rec.Quality)
+	if n == 0 {
+		return false
+	}
+	offset := q.offset()
+	sum, low := 0, 0
+	for i := 0; i < n; i++ {
+		score := int(rec.Quality[i]) - offset
+		sum += score
+		if score < q.MinBase {
+			low++
+		}
+	}
+	if q.MinMean > 0 && float64(sum)/float64(n) < q.MinMean {
+		return false
+	}
+	return q.MinBase == 0 || float64(low)/float64(n) <= q.MaxLowFrac
+}
+
+/* The mean quality of a quality string, or 0 if it is empty */
+func meanQuality(qual string, offset int) float64 {
+	if len(qual) == 0 {
+		return 0
+	}
+	sum := 0
+	for i := 0; i < len(qual); i++ {
+		sum += int(qual[i]) - offset
+	}
+	return float64(sum) / float64(len(qual))
+}
+
+/* The range of quality characters seen across some records, from which the
+ * encoding can be guessed */
+type QualityRange struct {
+	min, max byte
+	seen     bool
+}
+
+func (q *QualityRange) Add(qual string) {
+	for i := 0; i < len(qual); i++ {
+		c := qual[i]
+		if !q.seen || c < q.min {
+			q.min = c
+		}
+		if !q.seen || c > q.max {
+			q.max = c
+		}
+		q.seen = true
+	}
+}
+
+/* The offset the qualities seem to use, 33 or 64, and whether that is sure.
+ * Characters below ';' only occur in Phred+33. Phred+64 starts at '@' (or
+ * ';' for old Solexa scores) and runs well above 'J', where Illumina's
+ * Phred+33 stops. Qualities that all fall in between could be either, and
+ * are taken to be Phred+33. */
+func (q QualityRange) Offset() (int, bool) {
+	switch {
+	case !q.seen:
+		return DefaultQualOffset, false
+	case q.min < ';':
+		return 33, true
+	case q.max > 'J':
+		return 64, true
+	}
+	return DefaultQualOffset, false
+}
+
+/* Whether the qualities seem to mix encodings: some only Phred+33 uses, and
+ * some far above what Phred+33 reaches in short reads */
+func (q QualityRange) Mixed() bool {
+	return q.seen && q.min < ';' && q.max > 'h'
+}
+
+/* Rewrites qualities from one ASCII offset to another, such as old Phred+64
+ * files to Phred+33. Scores that would fall below 0, as Solexa's negative
+ * ones do, become 0, and are counted in Clamped. */
+type QualityConverter struct {
+	From, To int
+	Clamped  int
+	buf      []byte
+}
+
+func (c *QualityConverter) Convert(rec *Record) {
+	c.buf = c.buf[:0]
+	for i := 0; i < len(rec.Quality); i++ {
+		q := int(rec.Quality[i]) - c.From + c.To
+		if q < c.To {
+			q = c.To
+			c.Clamped++
+		} else if q > '~' {
+			q = '~'
+		}
+		c.buf = append(c.buf, byte(q))
+	}
+	rec.Quality = string(c.buf)
+}
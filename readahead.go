@@ -0,0 +1,77 @@
+package fqfilter
+
+/* Reads records in the background, a batch at a time, so that decompressing
+ * and parsing an input overlaps with whatever is done with its records. Each
+ * input of a pair gets its own, so the mates are read in parallel. */
+type ReadAhead struct {
+	batches  chan readBatch
+	done     chan struct{}
+	finished chan struct{}
+	stopped  bool
+	batch    readBatch
+	pos      int
+}
+
+/* Some records, and the error that ended the input after them, if it ended */
+type readBatch struct {
+	records []Record
+	err     error
+}
+
+/* Start reading from r, in batches of size records, with a few batches
+ * waiting at a time */
+func NewReadAhead(r RecordReader, size int) *ReadAhead {
+	a := &ReadAhead{
+		batches:  make(chan readBatch, 4),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	go func() {
+		defer close(a.finished)
+		for {
+			b := readBatch{records: make([]Record, 0, size)}
+			for len(b.records) < size {
+				var rec Record
+				if err := r.Read(&rec); err != nil {
+					b.err = err
+					break
+				}
+				b.records = append(b.records, rec)
+			}
+			select {
+			case a.batches <- b:
+			case <-a.done:
+				return
+			}
+			if b.err != nil {
+				return
+			}
+		}
+	}()
+	return a
+}
+
+/* Read the next record, returning io.EOF, or the error the input ended
+ * with, after the last */
+func (a *ReadAhead) Read(rec *Record) error {
+	for a.pos == len(a.batch.records) {
+		if a.batch.err != nil {
+			return a.batch.err
+		}
+		a.batch = <-a.batches
+		a.pos = 0
+	}
+	*rec = a.batch.records[a.pos]
+	a.pos++
+	return nil
+}
+
+/* Stop reading and wait for the background reader to finish, so the input
+ * can be closed. Records not yet read are dropped. */
+func (a *ReadAhead) Stop() {
+	if !a.stopped {
+		a.stopped = true
+		close(a.done)
+	}
+	<-a.finished
+}
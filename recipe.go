@@ -0,0 +1,180 @@
+package fqfilter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/* A recipe is a config file for a run, in a small subset of TOML: settings
+ * of the form key = value at the top, followed by a [[step]] table for each
+ * operation, in the order they run. A value is a quoted string, a number, a
+ * boolean, or a list of these in square brackets on one line, and # starts a
+ * comment. For example:
+ *
+ *	out = "kept"
+ *	inputs = ["r1.fq.gz", "r2.fq.gz"]
+ *
+ *	[[step]]
+ *	reads = "names.txt"
+ *
+ *	[[step]]
+ *	trim-qual = 20
+ */
+type Recipe struct {
+	Settings []RecipeSetting
+	Steps    [][]RecipeSetting
+}
+
+/* A key with its value, or each of its values for a list, as text */
+type RecipeSetting struct {
+	Key    string
+	Values []string
+	Line   int
+}
+
+func LoadRecipe(fn string) (*Recipe, error) {
+	fp, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	return ParseRecipe(fp)
+}
+
+func ParseRecipe(r io.Reader) (*Recipe, error) {
+	recipe := &Recipe{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		if text[0] == '[' {
+			if table := strings.TrimSpace(stripComment(text)); table != "[[step]]" {
+				return nil, fmt.Errorf("Line %d: only [[step]] tables are allowed, not %s", line, table)
+			}
+			recipe.Steps = append(recipe.Steps, nil)
+			continue
+		}
+		key, value, ok := strings.Cut(text, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !validKey(key) {
+			return nil, fmt.Errorf("Line %d should be key = value: %s", line, text)
+		}
+		values, err := parseValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %v", line, err)
+		}
+		setting := RecipeSetting{Key: key, Values: values, Line: line}
+		if n := len(recipe.Steps); n > 0 {
+			recipe.Steps[n-1] = append(recipe.Steps[n-1], setting)
+		} else {
+			recipe.Settings = append(recipe.Settings, setting)
+		}
+	}
+	return recipe, scanner.Err()
+}
+
+func validKey(key string) bool {
+	for _, c := range key {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return key != ""
+}
+
+/* Drop a # comment from the end of a line, outside any quotes */
+func stripComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0 && c == '\\' && quote == '"':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return s[:i]
+		}
+	}
+	return s
+}
+
+/* The values of a setting: one, or each in a list */
+func parseValue(s string) ([]string, error) {
+	s = strings.TrimSpace(stripComment(s))
+	if !strings.HasPrefix(s, "[") {
+		v, rest, err := parseScalar(s)
+		if err == nil && rest != "" {
+			err = fmt.Errorf("unexpected %s after the value", rest)
+		}
+		return []string{v}, err
+	}
+	if !strings.HasSuffix(s, "]") {
+		return nil, fmt.Errorf("a list must end with ] on the same line")
+	}
+	var values []string
+	rest := strings.TrimSpace(s[1 : len(s)-1])
+	for rest != "" {
+		v, after, err := parseScalar(rest)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		rest = strings.TrimSpace(after)
+		if rest != "" && !strings.HasPrefix(rest, ",") {
+			return nil, fmt.Errorf("expected a comma between the values of a list, got %s", rest)
+		}
+		rest = strings.TrimSpace(strings.TrimPrefix(rest, ","))
+	}
+	return values, nil
+}
+
+/* Parse a string, number or boolean from the start of s, returning its text
+ * and what follows it */
+func parseScalar(s string) (string, string, error) {
+	if s == "" {
+		return "", "", fmt.Errorf("missing value")
+	}
+	switch s[0] {
+	case '"':
+		for i := 1; i < len(s); i++ {
+			if s[i] == '\\' {
+				i++
+			} else if s[i] == '"' {
+				v, err := strconv.Unquote(s[:i+1])
+				if err != nil {
+					return "", "", fmt.Errorf("invalid string %s", s[:i+1])
+				}
+				return v, strings.TrimSpace(s[i+1:]), nil
+			}
+		}
+		return "", "", fmt.Errorf("unterminated string %s", s)
+	case '\'':
+		i := strings.IndexByte(s[1:], '\'')
+		if i < 0 {
+			return "", "", fmt.Errorf("unterminated string %s", s)
+		}
+		return s[1 : i+1], strings.TrimSpace(s[i+2:]), nil
+	}
+	end := strings.IndexAny(s, ", \t")
+	if end < 0 {
+		end = len(s)
+	}
+	v := s[:end]
+	if v != "true" && v != "false" {
+		if _, err := strconv.ParseFloat(strings.ReplaceAll(v, "_", ""), 64); err != nil {
+			return "", "", fmt.Errorf("invalid value %s: strings must be quoted", v)
+		}
+		v = strings.ReplaceAll(v, "_", "")
+	}
+	return v, strings.TrimSpace(s[end:]), nil
+}
@@ -0,0 +1,91 @@
+package fqfilter
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/* Selects reads by their place in the inputs rather than by name: the first
+ * read is 1, the next 2 and so on, whatever their names. This suits subsets
+ * picked by another program that only gives the positions. */
+type RecordSet struct {
+	// Whether each record has been seen
+	records map[int]bool
+	matched int
+	max     int
+}
+
+func NewRecordSet() *RecordSet {
+	return &RecordSet{records: make(map[int]bool)}
+}
+
+func (s *RecordSet) Add(n int) error {
+	if n < 1 {
+		return fmt.Errorf("Record numbers count from 1, so %d is not one", n)
+	}
+	if _, ok := s.records[n]; !ok {
+		s.records[n] = false
+	}
+	s.max = max(s.max, n)
+	return nil
+}
+
+/* Whether the record is in the set, noting that it has been seen */
+func (s *RecordSet) Contains(n int) bool {
+	seen, ok := s.records[n]
+	if ok && !seen {
+		s.records[n] = true
+		s.matched++
+	}
+	return ok
+}
+
+/* Whether the record is in the set, without noting that it has been seen */
+func (s *RecordSet) Has(n int) bool {
+	_, ok := s.records[n]
+	return ok
+}
+
+func (s *RecordSet) Len() int {
+	return len(s.records)
+}
+
+/* The number of records in the set that Contains has been asked about */
+func (s *RecordSet) Matched() int {
+	return s.matched
+}
+
+/* The highest record number, past which no read is in the set */
+func (s *RecordSet) Max() int {
+	return s.max
+}
+
+/* Add the record numbers listed in a file, one per line, returning the
+ * number of lines read */
+func LoadRecordNumbers(set *RecordSet, fn string) (int, error) {
+	reads, err := openReads(fn)
+	if err != nil {
+		return 0, err
+	}
+	defer reads.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(reads)
+	for scanner.Scan() {
+		lines++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return lines, fmt.Errorf("Line %d should be a record number: %s", lines, line)
+		}
+		if err := set.Add(n); err != nil {
+			return lines, fmt.Errorf("Line %d: %v", lines, err)
+		}
+	}
+	return lines, scanner.Err()
+}
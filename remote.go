@@ -0,0 +1,146 @@
+package fqfilter
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+/* Whether a filename is a URL to stream over the network: http://,
+ * https:// or s3:// */
+func IsRemote(fn string) bool {
+	return strings.HasPrefix(fn, "http://") || strings.HasPrefix(fn, "https://") || strings.HasPrefix(fn, "s3://")
+}
+
+/* A URL without its query or fragment, so its suffix tells the format */
+func remotePath(fn string) string {
+	if !IsRemote(fn) {
+		return fn
+	}
+	if i := strings.IndexAny(fn, "?#"); i >= 0 {
+		return fn[:i]
+	}
+	return fn
+}
+
+/* Open a file or URL to read from */
+func openSource(fn string) (io.ReadCloser, error) {
+	if IsRemote(fn) {
+		body, _, err := openRemote(fn)
+		return body, err
+	}
+	return os.Open(fn)
+}
+
+/* Start fetching a URL, returning the body to stream and its size, or 0 if
+ * the server didn't say. An s3://bucket/key URL is fetched from the bucket's
+ * endpoint, signed with the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY (and
+ * any AWS_SESSION_TOKEN) from the environment, or unsigned, for a public
+ * bucket, if they aren't set. */
+func openRemote(fn string) (io.ReadCloser, int64, error) {
+	req, err := http.NewRequest("GET", fn, nil)
+	if strings.HasPrefix(fn, "s3://") {
+		req, err = newS3Request(fn, time.Now())
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("Failed to fetch %s: %s", fn, resp.Status)
+	}
+	size := resp.ContentLength
+	if size < 0 {
+		size = 0
+	}
+	return resp.Body, size, nil
+}
+
+/* The GET request for an s3:// URL. The region comes from AWS_REGION or
+ * AWS_DEFAULT_REGION (us-east-1 if neither is set), and AWS_ENDPOINT_URL
+ * points at another S3 compatible service, addressing the bucket by path. */
+func newS3Request(fn string, now time.Time) (*http.Request, error) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(fn, "s3://"), "/")
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("Invalid S3 URL %s: it should be s3://bucket/key", fn)
+	}
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	path := "/" + awsEscape(key)
+	endpoint := fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, region)
+	if e := os.Getenv("AWS_ENDPOINT_URL"); e != "" {
+		endpoint = strings.TrimSuffix(e, "/")
+		path = "/" + awsEscape(bucket) + path
+	}
+	req, err := http.NewRequest("GET", endpoint+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if id != "" && secret != "" {
+		signS3(req, path, id, secret, os.Getenv("AWS_SESSION_TOKEN"), region, now)
+	}
+	return req, nil
+}
+
+/* Sign a GET request with AWS Signature Version 4, leaving the payload
+ * unsigned, as S3 allows */
+func signS3(req *http.Request, path, id, secret, token, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	req.Header.Set("X-Amz-Date", amzDate)
+	headers := "host:" + req.URL.Host + "\nx-amz-content-sha256:UNSIGNED-PAYLOAD\nx-amz-date:" + amzDate + "\n"
+	signed := "host;x-amz-content-sha256;x-amz-date"
+	if token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+		headers += "x-amz-security-token:" + token + "\n"
+		signed += ";x-amz-security-token"
+	}
+	canonical := strings.Join([]string{"GET", path, "", headers, signed, "UNSIGNED-PAYLOAD"}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+	k := hmacSHA256([]byte("AWS4"+secret), date)
+	k = hmacSHA256(k, region)
+	k = hmacSHA256(k, "s3")
+	k = hmacSHA256(k, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(k, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", id, scope, signed, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+/* Percent-encode a key as AWS signs it: everything but letters, digits,
+ * -._~ and the / between path segments */
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
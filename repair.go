@@ -0,0 +1,63 @@
+package fqfilter
+
+import (
+	"sort"
+)
+
+/* A mate still waiting for its partner, with when it arrived */
+type pendingMate struct {
+	index int
+	rec   Record
+}
+
+/* Matches up the mates of reads from two inputs that are no longer in the
+ * same order, such as after each was filtered on its own. Records are
+ * offered from the two inputs alternately, and a read is complete as soon
+ * as both its mates have been seen. Only the mates still waiting are held in
+ * memory, so this stays small when the inputs are roughly in step, even with
+ * many mates missing from one side. Mates are matched by name, ignoring /1,
+ * /2 and Illumina comments. */
+type Repairer struct {
+	offered int
+	pending [2]map[string]pendingMate
+}
+
+func NewRepairer() *Repairer {
+	return &Repairer{pending: [2]map[string]pendingMate{make(map[string]pendingMate), make(map[string]pendingMate)}}
+}
+
+/* Offer a record from the given mate, 0 or 1. If its partner has already
+ * been seen, both are filled into pair, in mate order, and true returned. */
+func (r *Repairer) Add(mate int, rec Record, pair []Record) bool {
+	r.offered++
+	name := fragmentName(rec.Header)
+	other := r.pending[1-mate]
+	if p, ok := other[name]; ok {
+		delete(other, name)
+		pair[mate] = rec
+		pair[1-mate] = p.rec
+		return true
+	}
+	r.pending[mate][name] = pendingMate{index: r.offered, rec: rec}
+	return false
+}
+
+/* The number of mates of the given input still waiting for a partner */
+func (r *Repairer) Waiting(mate int) int {
+	return len(r.pending[mate])
+}
+
+/* The records of the given mate that never found a partner, in the order
+ * they were offered */
+func (r *Repairer) Orphans(mate int) []Record {
+	waiting := make([]pendingMate, 0, len(r.pending[mate]))
+	for _, p := range r.pending[mate] {
+		waiting = append(waiting, p)
+	}
+	sort.Slice(waiting, func(i, j int) bool { return waiting[i].index < waiting[j].index })
+	orphans := make([]Record, len(waiting))
+	for i, p := range waiting {
+		orphans[i] = p.rec
+	}
+	return orphans
+}
@@ -0,0 +1,70 @@
+package fqfilter
+
+import (
+	"math/rand"
+	"sort"
+)
+
+/* A read held back by reservoir sampling, with its position in the input so
+ * the sample can be written out in input order */
+type sampledRead struct {
+	index int
+	name  string
+	bases int
+	mates []Record
+}
+
+/* Keeps a uniform random sample of exactly size reads (algorithm R), or all of
+ * them if fewer are offered. The sample is held in memory until WriteTo. */
+type Reservoir struct {
+	size  int
+	seen  int
+	rng   *rand.Rand
+	reads []sampledRead
+}
+
+func NewReservoir(size int, rng *rand.Rand) *Reservoir {
+	return &Reservoir{size: size, rng: rng}
+}
+
+/* Offer a read, and its untrimmed length, to the sample. The mates are copied
+ * since the caller reuses its slice. */
+func (r *Reservoir) Add(name string, mates []Record, bases int) {
+	r.seen++
+	read := sampledRead{index: r.seen, name: name, bases: bases}
+	if len(r.reads) < r.size {
+		read.mates = append([]Record(nil), mates...)
+		r.reads = append(r.reads, read)
+		return
+	}
+	j := r.rng.Intn(r.seen)
+	if j < r.size {
+		read.mates = append(r.reads[j].mates[:0], mates...)
+		r.reads[j] = read
+	}
+}
+
+/* The number of reads offered but not kept */
+func (r *Reservoir) Dropped() int {
+	return r.seen - len(r.reads)
+}
+
+/* The total untrimmed length of the sampled reads */
+func (r *Reservoir) Bases() int {
+	n := 0
+	for _, read := range r.reads {
+		n += read.bases
+	}
+	return n
+}
+
+/* Write the sampled reads in the order they appeared in the input */
+func (r *Reservoir) WriteTo(output *Output) (int, error) {
+	sort.Slice(r.reads, func(i, j int) bool { return r.reads[i].index < r.reads[j].index })
+	for _, read := range r.reads {
+		if err := output.Write(read.name, read.mates); err != nil {
+			return 0, err
+		}
+	}
+	return len(r.reads), nil
+}
@@ -0,0 +1,107 @@
+package fqfilter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+/* Matches against a reads file that is sorted by name, without loading it.
+ * The FASTQ must be sorted the same way (byte order, as with LC_ALL=C sort)
+ * so that the list can be walked forward in step with the reads. Any error,
+ * including out of order input in either stream, sticks and is reported by
+ * Err; Contains returns false from then on. */
+type SortedReads struct {
+	scanner *bufio.Scanner
+	norm    Normalizer
+	current string
+	more    bool
+	hit     bool
+	last    string
+	started bool
+	lines   int
+	matched int
+	err     error
+}
+
+/* Walk the names in r, normalized as entries like any other reads file */
+func NewSortedReads(r io.Reader, norm Normalizer) *SortedReads {
+	s := &SortedReads{scanner: bufio.NewScanner(r), norm: norm}
+	s.advance()
+	return s
+}
+
+func (s *SortedReads) advance() {
+	if !s.scanner.Scan() {
+		s.more = false
+		s.err = s.scanner.Err()
+		return
+	}
+	next := s.norm.Entry(strings.TrimSuffix(s.scanner.Text(), "\r"))
+	s.lines++
+	if s.more && next < s.current {
+		s.err = fmt.Errorf("Reads file is not sorted: %s on line %d comes after %s\n", next, s.lines, s.current)
+		s.more = false
+		return
+	}
+	if !s.more || next != s.current {
+		s.hit = false
+	}
+	s.current = next
+	s.more = true
+}
+
+func (s *SortedReads) Add(entry string) error {
+	return fmt.Errorf("Cannot add names in -sorted mode")
+}
+
+func (s *SortedReads) Contains(name string) bool {
+	if s.err != nil {
+		return false
+	}
+	if s.started && name < s.last {
+		s.err = fmt.Errorf("Input is not sorted: %s comes after %s\n", name, s.last)
+		return false
+	}
+	s.started = true
+	s.last = name
+	for s.more && s.current < name {
+		s.advance()
+	}
+	if s.more && s.current == name {
+		if !s.hit {
+			s.hit = true
+			s.matched++
+		}
+		return true
+	}
+	return false
+}
+
+/* The number of lines read from the reads file so far. Unlike the other sets
+ * this counts duplicates. */
+func (s *SortedReads) Len() int {
+	return s.lines
+}
+
+func (s *SortedReads) Matched() int {
+	return s.matched
+}
+
+func (s *SortedReads) Unmatched() ([]string, error) {
+	return nil, fmt.Errorf("unmatched names are not kept with -sorted")
+}
+
+/* Read through the rest of the reads file, so that out of order names past the
+ * last read are still caught */
+func (s *SortedReads) Finish() error {
+	for s.more {
+		s.advance()
+	}
+	return s.err
+}
+
+func (s *SortedReads) Err() error {
+	return s.err
+}
@@ -0,0 +1,120 @@
+package fqfilter
+
+import (
+	"sort"
+)
+
+/* Summary statistics over a stream of records: counts, lengths, base
+ * qualities and composition. Qualities are counted per base, so the mean and
+ * median are over all the bases rather than of each read's mean. */
+type ReadStats struct {
+	// The character that stands for a quality of 0, DefaultQualOffset if unset
+	Offset int
+
+	Reads     int
+	Bases     int
+	MinLength int
+	MaxLength int
+	// The number of reads of each length
+	Lengths map[int]int
+
+	gc, acgt, n int
+	quals       [256]int
+	qualBases   int
+}
+
+func NewReadStats(offset int) *ReadStats {
+	return &ReadStats{Offset: offset, Lengths: make(map[int]int)}
+}
+
+func (s *ReadStats) Add(rec *Record) {
+	n := len(rec.Sequence)
+	if s.Reads == 0 || n < s.MinLength {
+		s.MinLength = n
+	}
+	if n > s.MaxLength {
+		s.MaxLength = n
+	}
+	s.Reads++
+	s.Bases += n
+	s.Lengths[n]++
+	gc, acgt := countGC(rec.Sequence)
+	s.gc += gc
+	s.acgt += acgt
+	s.n += CountN(rec.Sequence)
+	for i := 0; i < len(rec.Quality); i++ {
+		s.quals[rec.Quality[i]]++
+	}
+	s.qualBases += len(rec.Quality)
+}
+
+/* The mean read length */
+func (s *ReadStats) MeanLength() float64 {
+	if s.Reads == 0 {
+		return 0
+	}
+	return float64(s.Bases) / float64(s.Reads)
+}
+
+func (s *ReadStats) offset() int {
+	if s.Offset == 0 {
+		return DefaultQualOffset
+	}
+	return s.Offset
+}
+
+/* The mean quality over all bases, or 0 without qualities */
+func (s *ReadStats) MeanQuality() float64 {
+	if s.qualBases == 0 {
+		return 0
+	}
+	sum := 0
+	for c, count := range s.quals {
+		sum += (c - s.offset()) * count
+	}
+	return float64(sum) / float64(s.qualBases)
+}
+
+/* The median quality over all bases, or 0 without qualities */
+func (s *ReadStats) MedianQuality() int {
+	seen := 0
+	for c, count := range s.quals {
+		seen += count
+		if seen*2 >= s.qualBases && count > 0 {
+			return c - s.offset()
+		}
+	}
+	return 0
+}
+
+/* The percentage of the A, C, G and T bases that are G or C */
+func (s *ReadStats) GCPercent() float64 {
+	if s.acgt == 0 {
+		return 0
+	}
+	return 100 * float64(s.gc) / float64(s.acgt)
+}
+
+/* The percentage of all bases that are N */
+func (s *ReadStats) NPercent() float64 {
+	if s.Bases == 0 {
+		return 0
+	}
+	return 100 * float64(s.n) / float64(s.Bases)
+}
+
+/* A length and the number of reads of that length */
+type LengthCount struct {
+	Length int `json:"length"`
+	Reads  int `json:"reads"`
+}
+
+/* The length histogram, shortest first */
+func (s *ReadStats) Histogram() []LengthCount {
+	hist := make([]LengthCount, 0, len(s.Lengths))
+	for length, reads := range s.Lengths {
+		hist = append(hist, LengthCount{length, reads})
+	}
+	sort.Slice(hist, func(i, j int) bool { return hist[i].Length < hist[j].Length })
+	return hist
+}
@@ -0,0 +1,166 @@
+package fqfilter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+/* Reads back the tabular format Output writes: a row per read of its name
+ * and each mate's sequence, each followed by its quality if the columns hold
+ * them. A first row of column names, as TabHeader writes, says whether they
+ * do. Without one, a sequence column followed by one as long that isn't made
+ * of bases is taken to be its quality. Mates without qualities are given
+ * FillQuality for each base. Every mate gets the name as its header. */
+type TabReader struct {
+	FillQuality byte
+	scanner     *bufio.Scanner
+	maxLine     int
+	line        int
+	started     bool
+	mates       int
+	qualities   bool
+	// The first row, when it holds a read rather than column names
+	first []string
+	// The mates' names from the column names, such as R1 for seq_R1
+	names []string
+}
+
+func NewTabReader(r io.Reader) *TabReader {
+	t := &TabReader{FillQuality: 'I', scanner: bufio.NewScanner(r)}
+	t.SetMaxLineBytes(DefaultMaxLineBytes)
+	return t
+}
+
+/* Set the longest row allowed, or with 0, let rows be any length. This must
+ * be called before Start or the first Read. */
+func (t *TabReader) SetMaxLineBytes(n int) {
+	t.maxLine = setMaxLine(t.scanner, n)
+}
+
+/* Read the next row's columns, returning io.EOF at the end */
+func (t *TabReader) row() ([]string, error) {
+	if !t.scanner.Scan() {
+		if err := t.scanner.Err(); err == bufio.ErrTooLong {
+			return nil, lineTooLong(t.line+1, t.maxLine, nil)
+		} else if err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	t.line++
+	return strings.Split(strings.TrimSuffix(t.scanner.Text(), "\r"), "\t"), nil
+}
+
+/* Work out the layout from the first row. Read calls this itself, but it
+ * can be called first to find the number of mates. */
+func (t *TabReader) Start() error {
+	if t.started {
+		return nil
+	}
+	t.started = true
+	cols, err := t.row()
+	if err == io.EOF {
+		return fmt.Errorf("The table is empty")
+	} else if err != nil {
+		return err
+	}
+	if len(cols) < 2 {
+		return fmt.Errorf("Line 1 has no sequence column")
+	}
+	if cols[0] == "name" && strings.HasPrefix(cols[1], "seq_") {
+		t.qualities = len(cols) > 2 && strings.HasPrefix(cols[2], "qual_")
+		step := 1
+		if t.qualities {
+			step = 2
+		}
+		for i := 1; i < len(cols); i += step {
+			if !strings.HasPrefix(cols[i], "seq_") || t.qualities && (i+1 >= len(cols) || !strings.HasPrefix(cols[i+1], "qual_")) {
+				return fmt.Errorf("Line 1 names column %d %s, out of the order it should be in", i+1, cols[i])
+			}
+			t.names = append(t.names, cols[i][len("seq_"):])
+		}
+		t.mates = len(t.names)
+		return nil
+	}
+	t.qualities = len(cols)%2 == 1
+	for i := 1; i+1 < len(cols) && t.qualities; i += 2 {
+		t.qualities = len(cols[i]) == len(cols[i+1]) && !isSequence(cols[i+1])
+	}
+	t.mates = len(cols) - 1
+	if t.qualities {
+		t.mates /= 2
+	}
+	t.first = cols
+	return nil
+}
+
+/* Whether a column holds only bases, so can't be a quality. An empty one
+ * could be either. */
+func isSequence(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !validBases[s[i]] {
+			return false
+		}
+	}
+	return s != ""
+}
+
+/* The number of mates in each row */
+func (t *TabReader) Mates() int {
+	return t.mates
+}
+
+/* Whether the rows hold qualities */
+func (t *TabReader) Qualities() bool {
+	return t.qualities
+}
+
+/* The names of the mates given by a row of column names, such as 1 and 2,
+ * or R1 and I1, or nil if there wasn't one */
+func (t *TabReader) MateNames() []string {
+	return t.names
+}
+
+/* Read the next row into a record for each mate. Returns io.EOF after the
+ * last. */
+func (t *TabReader) Read(mates []Record) error {
+	if err := t.Start(); err != nil {
+		return err
+	}
+	cols := t.first
+	t.first = nil
+	if cols == nil {
+		var err error
+		if cols, err = t.row(); err != nil {
+			return err
+		}
+	}
+	want := 1 + t.mates
+	if t.qualities {
+		want += t.mates
+	}
+	if len(cols) != want {
+		return fmt.Errorf("Line %d has %d columns, but should have %d\n", t.line, len(cols), want)
+	}
+	if cols[0] == "" {
+		return fmt.Errorf("Line %d has no read name\n", t.line)
+	}
+	for i := range mates {
+		rec := &mates[i]
+		rec.Header = cols[0]
+		rec.Plus = "+"
+		if t.qualities {
+			rec.Sequence = cols[1+2*i]
+			rec.Quality = cols[2+2*i]
+			if len(rec.Quality) != len(rec.Sequence) {
+				return fmt.Errorf("Line %d: the quality of mate %d is %d long but its sequence is %d\n", t.line, i+1, len(rec.Quality), len(rec.Sequence))
+			}
+		} else {
+			rec.Sequence = cols[1+i]
+			rec.Quality = strings.Repeat(string(t.FillQuality), len(rec.Sequence))
+		}
+	}
+	return nil
+}
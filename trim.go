@@ -0,0 +1,196 @@
+package fqfilter
+
+import (
+	"strings"
+)
+
+/* Cuts bases from a mate before the filters look at it, so a read that
+ * trims too short can still be dropped by MinLen. The mate's index is passed
+ * for trims that treat the mates differently. */
+type Trimmer interface {
+	TrimMate(rec *Record, mate int)
+}
+
+/* Hard trims each mate to a fixed structure: first cut it down to its
+ * Crop, then remove its HeadCrop from the start. Both are indexed by
+ * mate, and a 0 (or a mate beyond the end of the slice) leaves it alone. */
+type CropTrimmer struct {
+	Crop     []int
+	HeadCrop []int
+}
+
+func (t *CropTrimmer) TrimMate(rec *Record, mate int) {
+	if mate < len(t.Crop) && t.Crop[mate] > 0 {
+		rec.Trim(t.Crop[mate])
+	}
+	if mate < len(t.HeadCrop) && t.HeadCrop[mate] > 0 {
+		rec.Clip(t.HeadCrop[mate])
+	}
+}
+
+/* How many mismatches an alignment of overlap bases may have at the given
+ * rate */
+func allowedMismatches(overlap int, rate float64) int {
+	return int(float64(overlap) * rate)
+}
+
+/* Trims 3' adapters: the read is cut where the first of the adapters starts,
+ * whether the whole adapter is in the read or just its start runs off the
+ * end. Matching ignores case, N in the read matches anything, and up to
+ * ErrorRate of the aligned bases may mismatch. An adapter must overlap the
+ * read by at least MinOverlap bases, so a base or two at the end that happen
+ * to match aren't cut. */
+type AdapterTrimmer struct {
+	Adapters   []string
+	ErrorRate  float64
+	MinOverlap int
+	// The number of mates that were trimmed
+	Trimmed int
+}
+
+/* The defaults for AdapterTrimmer, as in cutadapt */
+const (
+	DefaultAdapterErrorRate  = 0.1
+	DefaultAdapterMinOverlap = 3
+)
+
+func NewAdapterTrimmer(adapters []string) *AdapterTrimmer {
+	t := &AdapterTrimmer{ErrorRate: DefaultAdapterErrorRate, MinOverlap: DefaultAdapterMinOverlap}
+	for _, a := range adapters {
+		t.Adapters = append(t.Adapters, strings.ToUpper(a))
+	}
+	return t
+}
+
+func (t *AdapterTrimmer) TrimMate(rec *Record, mate int) {
+	cut := len(rec.Sequence)
+	for _, adapter := range t.Adapters {
+		if i := t.find(rec.Sequence[:cut], adapter); i >= 0 {
+			cut = i
+		}
+	}
+	if cut < len(rec.Sequence) {
+		rec.Trim(cut)
+		t.Trimmed++
+	}
+}
+
+/* The first position in seq where the adapter matches, or -1 */
+func (t *AdapterTrimmer) find(seq, adapter string) int {
+	for i := 0; i+t.MinOverlap <= len(seq); i++ {
+		overlap := min(len(adapter), len(seq)-i)
+		limit := allowedMismatches(overlap, t.ErrorRate)
+		mismatches := 0
+		for j := 0; j < overlap && mismatches <= limit; j++ {
+			c := seq[i+j]
+			if c >= 'a' && c <= 'z' {
+				c -= 'a' - 'A'
+			}
+			if c != adapter[j] && c != 'N' {
+				mismatches++
+			}
+		}
+		if mismatches <= limit {
+			return i
+		}
+	}
+	return -1
+}
+
+/* The window size of a QualityTrimmer unless told otherwise, as in
+ * Trimmomatic's examples */
+const DefaultTrimWindow = 4
+
+/* Sliding window quality trimming, like Trimmomatic's SLIDINGWINDOW: the
+ * read is cut at the start of the first window of Window bases whose mean
+ * quality is below Threshold. A read shorter than the window is judged as a
+ * whole. */
+type QualityTrimmer struct {
+	Threshold float64
+	Window    int
+	// The character that stands for a quality of 0, DefaultQualOffset if unset
+	Offset int
+	// The number of mates that were trimmed
+	Trimmed int
+}
+
+func (t *QualityTrimmer) TrimMate(rec *Record, mate int) {
+	offset := t.Offset
+	if offset == 0 {
+		offset = DefaultQualOffset
+	}
+	q := rec.Quality
+	window := min(max(t.Window, 1), len(q))
+	if window == 0 {
+		return
+	}
+	need := t.Threshold * float64(window)
+	sum := 0
+	for i := 0; i < window; i++ {
+		sum += int(q[i]) - offset
+	}
+	for start := 0; ; start++ {
+		if float64(sum) < need {
+			rec.Trim(start)
+			t.Trimmed++
+			return
+		}
+		if start+window >= len(q) {
+			return
+		}
+		sum += int(q[start+window]) - int(q[start])
+	}
+}
+
+/* Trims homopolymer tails, such as the poly-A of RNA-seq reads or the poly-G
+ * that two-color Illumina chemistry reads when the signal runs out. A tail
+ * is a run of at least MinLen of one of Bases at the 3' end, in which an N,
+ * or one base in eight of something else, is allowed. */
+type PolyXTrimmer struct {
+	Bases  string
+	MinLen int
+	// The number of mates trimmed, and of bases cut from them
+	Trimmed      int
+	TrimmedBases int
+}
+
+const DefaultPolyXMinLen = 10
+
+func (t *PolyXTrimmer) TrimMate(rec *Record, mate int) {
+	seq := rec.Sequence
+	cut := len(seq)
+	for i := 0; i < len(t.Bases); i++ {
+		if start := polyXStart(seq, t.Bases[i]|0x20); start < cut && len(seq)-start >= t.MinLen {
+			cut = start
+		}
+	}
+	if cut < len(seq) {
+		t.Trimmed++
+		t.TrimmedBases += len(seq) - cut
+		rec.Trim(cut)
+	}
+}
+
+/* Where a tail of base x (in lower case) starts: the furthest from the end
+ * that two x's begin a run with no more than one other base in eight. Two
+ * are needed so the tail doesn't take in a base before one of the others. */
+func polyXStart(seq string, x byte) int {
+	start := len(seq)
+	others := 0
+	for i := len(seq) - 1; i >= 0; i-- {
+		switch c := seq[i] | 0x20; {
+		case c == x:
+			if others*8 <= len(seq)-i && i+1 < len(seq) && seq[i+1]|0x20 == x {
+				start = i
+			}
+		case c == 'n':
+		default:
+			others++
+			// Too many to be part of a tail, however long it runs on
+			if others*8 > len(seq)-i+8 {
+				return start
+			}
+		}
+	}
+	return start
+}
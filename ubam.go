@@ -0,0 +1,137 @@
+package fqfilter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+/* SAM FLAG bits of unaligned reads */
+const (
+	flagPaired       = 0x1
+	flagMateUnmapped = 0x8
+	flagFirst        = 0x40
+	flagLast         = 0x80
+)
+
+/* The 4-bit codes of BAM sequences, indexed by base */
+var bamBases = func() [256]byte {
+	var codes [256]byte
+	for i := range codes {
+		codes[i] = 15 // N
+	}
+	for i, b := range "=ACMGRSVTWYHKDBN" {
+		codes[b] = byte(i)
+		codes[b+'a'-'A'] = byte(i)
+	}
+	return codes
+}()
+
+/* Writes reads as an unaligned BAM (uBAM), as GATK's pipelines take them.
+ * Pairs are flagged as first and second mates, all unmapped, and every read
+ * can carry a read group (RG) tag. */
+type BAMWriter struct {
+	bgzf      *BGZFWriter
+	readGroup string
+	offset    int
+	rec       []byte
+}
+
+/* Start a uBAM, writing its header. With a readGroup, the header has an @RG
+ * line for it, with the sample (SM) given. Qualities are read with the ASCII
+ * offset given. */
+func NewBAMWriter(w io.Writer, level int, readGroup, sample string, offset int) (*BAMWriter, error) {
+	bgzf, err := NewBGZFWriter(w, level)
+	if err != nil {
+		return nil, err
+	}
+	b := &BAMWriter{bgzf: bgzf, readGroup: readGroup, offset: offset}
+	text := "@HD\tVN:1.6\tSO:unsorted\n"
+	if readGroup != "" {
+		text += fmt.Sprintf("@RG\tID:%s\tSM:%s\n", readGroup, sample)
+	}
+	buf := []byte("BAM\x01")
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(text)))
+	buf = append(buf, text...)
+	// No reference sequences
+	buf = binary.LittleEndian.AppendUint32(buf, 0)
+	if _, err := bgzf.Write(buf); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+/* Write one mate of a read with n mates (counting from 0), named by its
+ * header's first word without any /1 or /2 */
+func (b *BAMWriter) Write(rec *Record, mate, n int) error {
+	name := fragmentName(rec.Header)
+	if name == "" || len(name) > 254 {
+		return fmt.Errorf("Read name %q can't be written to BAM", name)
+	}
+	if n > 2 {
+		return fmt.Errorf("BAM output takes one or two mates, not %d", n)
+	}
+	flag := FlagUnmapped
+	if n == 2 {
+		flag |= flagPaired | flagMateUnmapped | flagFirst
+		if mate == 1 {
+			flag ^= flagFirst | flagLast
+		}
+	}
+	le := binary.LittleEndian
+	seq := rec.Sequence
+	r := b.rec[:0]
+	r = le.AppendUint32(r, 0) // block_size, filled in below
+	r = le.AppendUint32(r, 0xffffffff)
+	r = le.AppendUint32(r, 0xffffffff)
+	r = append(r, byte(len(name)+1), 0)
+	r = le.AppendUint16(r, 4680) // the bin of unmapped reads
+	r = le.AppendUint16(r, 0)
+	r = le.AppendUint16(r, uint16(flag))
+	r = le.AppendUint32(r, uint32(len(seq)))
+	r = le.AppendUint32(r, 0xffffffff)
+	r = le.AppendUint32(r, 0xffffffff)
+	r = le.AppendUint32(r, 0)
+	r = append(r, name...)
+	r = append(r, 0)
+	for i := 0; i < len(seq); i += 2 {
+		code := bamBases[seq[i]] << 4
+		if i+1 < len(seq) {
+			code |= bamBases[seq[i+1]]
+		}
+		r = append(r, code)
+	}
+	if len(rec.Quality) == len(seq) {
+		for i := 0; i < len(seq); i++ {
+			r = append(r, byte(int(rec.Quality[i])-b.offset))
+		}
+	} else {
+		// FASTA input has no qualities
+		for i := 0; i < len(seq); i++ {
+			r = append(r, 0xff)
+		}
+	}
+	if b.readGroup != "" {
+		r = append(r, "RGZ"...)
+		r = append(r, b.readGroup...)
+		r = append(r, 0)
+	}
+	le.PutUint32(r, uint32(len(r)-4))
+	b.rec = r
+	_, err := b.bgzf.Write(r)
+	return err
+}
+
+/* Finish the BGZF stream. The underlying writer is left open. */
+func (b *BAMWriter) Close() error {
+	return b.bgzf.Close()
+}
+
+/* The filename for BAM output from a prefix, as for tabular output */
+func bamFilename(prefix string) string {
+	if strings.HasSuffix(prefix, ".bam") {
+		return prefix
+	}
+	return prefix + ".bam"
+}
@@ -0,0 +1,50 @@
+package fqfilter
+
+import (
+	"strings"
+)
+
+/* Moves a UMI from the start of one mate's sequence into the read names, in
+ * the umi_tools style: the first Length bases of mate Mate (counting from 0)
+ * are clipped off, and every mate's name gets _UMI added to its first word.
+ * A mate shorter than Length gives up all it has. */
+type UMIExtractor struct {
+	Length int
+	Mate   int
+}
+
+/* Extract the UMI, returning it */
+func (u *UMIExtractor) Extract(mates []Record) string {
+	rec := &mates[u.Mate]
+	umi := rec.Sequence[:min(u.Length, len(rec.Sequence))]
+	rec.Clip(u.Length)
+	for i := range mates {
+		mates[i].Header = AddToName(mates[i].Header, "_"+umi)
+	}
+	return umi
+}
+
+/* Add a suffix to the first word of a header, before any comment, and before
+ * a /1 or /2 mate suffix, so that read1/1 becomes read1_ACGT/1 */
+func AddToName(header, suffix string) string {
+	end := len(header)
+	if i := strings.IndexAny(header, " \t"); i >= 0 {
+		end = i
+	}
+	end -= len(mateSuffix(header))
+	return header[:end] + suffix + header[end:]
+}
+
+/* The UMI that umi_tools style extraction added to a header: the part of the
+ * first word after its last _, less any /1 or /2, or "" if there is none */
+func UMIFromName(header string) string {
+	name := header
+	if i := strings.IndexAny(name, " \t"); i >= 0 {
+		name = name[:i]
+	}
+	name = strings.TrimSuffix(name, mateSuffix(name))
+	if i := strings.LastIndexByte(name, '_'); i >= 0 {
+		return name[i+1:]
+	}
+	return ""
+}
@@ -0,0 +1,47 @@
+package fqfilter
+
+import "testing"
+
+func TestAddToName(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"read1", "read1_ACGT"},
+		{"read1 1:N:0:GATT", "read1_ACGT 1:N:0:GATT"},
+		{"read1/1", "read1_ACGT/1"},
+		{"read1/2 extra", "read1_ACGT/2 extra"},
+		// Only a mate suffix on the name itself counts
+		{"read1 x/1", "read1_ACGT x/1"},
+	}
+	for _, tt := range tests {
+		if got := AddToName(tt.header, "_ACGT"); got != tt.want {
+			t.Errorf("AddToName(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+		if umi := UMIFromName(tt.want); umi != "ACGT" {
+			t.Errorf("UMIFromName(%q) = %q, want ACGT", tt.want, umi)
+		}
+	}
+}
+
+func TestExtractMateSuffix(t *testing.T) {
+	mates := []Record{
+		{Header: "read1/1", Sequence: "ACGTTTGA", Plus: "+", Quality: "IIIIJJJJ"},
+		{Header: "read1/2", Sequence: "CCCC", Plus: "+", Quality: "KKKK"},
+	}
+	u := UMIExtractor{Length: 4}
+	if umi := u.Extract(mates); umi != "ACGT" {
+		t.Errorf("extracted %q, want ACGT", umi)
+	}
+	for i, want := range []string{"read1_ACGT/1", "read1_ACGT/2"} {
+		if mates[i].Header != want {
+			t.Errorf("mate %d is named %q, want %q", i+1, mates[i].Header, want)
+		}
+		if got := mateSuffix(mates[i].Header); got != want[len(want)-2:] {
+			t.Errorf("mate %d lost its mate suffix, got %q", i+1, got)
+		}
+	}
+	if mates[0].Sequence != "TTGA" || mates[0].Quality != "JJJJ" {
+		t.Errorf("mate 1 was left with %s %s, want TTGA JJJJ", mates[0].Sequence, mates[0].Quality)
+	}
+}
@@ -0,0 +1,61 @@
+package fqfilter
+
+import "fmt"
+
+/* The characters a sequence may hold: the IUPAC nucleotide codes, in either
+ * case, and . for an unknown base as in some older files */
+var validBases = func() [256]bool {
+	var valid [256]bool
+	for _, b := range "ACGTUNRYKMSWBDHV" {
+		valid[b] = true
+		valid[b+'a'-'A'] = true
+	}
+	valid['.'] = true
+	return valid
+}()
+
+/* Checks whether FASTQ records are well formed, going further than the
+ * reader, which only requires the header and + lines */
+type Validator struct {
+	// With an offset, qualities below it are invalid. With 0, any printable
+	// character from ! to ~ is allowed.
+	QualOffset int
+}
+
+/* The problems with a record, described for a report, or none if it is
+ * valid */
+func (v Validator) Check(rec *Record) []string {
+	var problems []string
+	if firstWord(rec.Header) == "" {
+		problems = append(problems, "the header has no read name")
+	}
+	if len(rec.Plus) > 1 && rec.Plus[1:] != rec.Header {
+		problems = append(problems, fmt.Sprintf("the + line names %s rather than repeating the header", rec.Plus[1:]))
+	}
+	if len(rec.Quality) != len(rec.Sequence) {
+		problems = append(problems, fmt.Sprintf("the quality is %d long but the sequence is %d", len(rec.Quality), len(rec.Sequence)))
+	}
+	for i := 0; i < len(rec.Sequence); i++ {
+		if !validBases[rec.Sequence[i]] {
+			problems = append(problems, fmt.Sprintf("the sequence has an invalid character %q at position %d", rec.Sequence[i], i+1))
+			break
+		}
+	}
+	low := byte('!')
+	if v.QualOffset > 0 {
+		low = byte(v.QualOffset)
+	}
+	for i := 0; i < len(rec.Quality); i++ {
+		if c := rec.Quality[i]; c < low || c > '~' {
+			problems = append(problems, fmt.Sprintf("the quality has an invalid character %q at position %d", c, i+1))
+			break
+		}
+	}
+	return problems
+}
+
+/* Whether two headers name the same fragment, ignoring any /1 or /2 or
+ * Illumina comment, as the mates of a read should */
+func SameFragment(a, b string) bool {
+	return fragmentName(a) == fragmentName(b)
+}
@@ -0,0 +1,90 @@
+package fqfilter
+
+/* Writes an Output's reads in the background, a batch at a time, so that
+ * laying out the records, and the compressing they feed, overlaps with
+ * reading and filtering the next ones. The reads are written in the order
+ * they were given; the first error is returned from the next Write, or from
+ * Close. */
+type writeBehind struct {
+	o        *Output
+	size     int
+	batches  chan []pendingRead
+	failed   chan struct{}
+	finished chan struct{}
+	batch    []pendingRead
+	err      error
+}
+
+/* A read waiting to be written: all its mates, or with mate set, just the
+ * one record as that mate; or, with skip, a read passed over */
+type pendingRead struct {
+	name  string
+	mates []Record
+	mate  int
+	skip  bool
+}
+
+/* Start writing in the background, if WriteBehind is set */
+func (o *Output) startBehind() {
+	if o.opts.WriteBehind <= 0 {
+		return
+	}
+	w := &writeBehind{
+		o:        o,
+		size:     o.opts.WriteBehind,
+		batches:  make(chan []pendingRead, 4),
+		failed:   make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	w.batch = make([]pendingRead, 0, w.size)
+	go func() {
+		defer close(w.finished)
+		// Once a write fails the rest are dropped, but still taken, so
+		// that add never blocks
+		for batch := range w.batches {
+			for i := 0; i < len(batch) && w.err == nil; i++ {
+				if err := w.write(&batch[i]); err != nil {
+					w.err = err
+					close(w.failed)
+				}
+			}
+		}
+	}()
+	o.behind = w
+}
+
+func (w *writeBehind) write(r *pendingRead) error {
+	switch {
+	case r.skip:
+		w.o.skip()
+		return nil
+	case r.mate >= 0:
+		return w.o.writeSingle(r.mate, &r.mates[0])
+	}
+	return w.o.write(r.name, r.mates)
+}
+
+/* Queue a read, handing over the batch once it's full */
+func (w *writeBehind) add(r pendingRead) error {
+	select {
+	case <-w.failed:
+		return w.err
+	default:
+	}
+	w.batch = append(w.batch, r)
+	if len(w.batch) == w.size {
+		w.batches <- w.batch
+		w.batch = make([]pendingRead, 0, w.size)
+	}
+	return nil
+}
+
+/* Hand over what is left and wait for it all to be written */
+func (w *writeBehind) stop() error {
+	if len(w.batch) > 0 {
+		w.batches <- w.batch
+	}
+	close(w.batches)
+	<-w.finished
+	return w.err
+}